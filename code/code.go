@@ -0,0 +1,277 @@
+// Package code defines the bytecode instruction set executed by the vm
+// package and produced by the compiler package.
+package code
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+)
+
+type Instructions []byte
+
+func (ins Instructions) String() string {
+	var out bytes.Buffer
+	i := 0
+	for i < len(ins) {
+		def, err := Lookup(ins[i])
+		if err != nil {
+			fmt.Fprintf(&out, "ERROR: %s\n", err)
+			i++
+			continue
+		}
+		operands, read := ReadOperands(def, ins[i+1:])
+		fmt.Fprintf(&out, "%04d %s\n", i, ins.fmtInstruction(def, operands))
+		i += 1 + read
+	}
+	return out.String()
+}
+
+func (ins Instructions) fmtInstruction(def *Definition, operands []int) string {
+	operandCount := len(def.OperandWidths)
+	if len(operands) != operandCount {
+		return fmt.Sprintf("ERROR: operand len %d does not match defined %d\n",
+			len(operands), operandCount)
+	}
+	switch operandCount {
+	case 0:
+		return def.Name
+	case 1:
+		return fmt.Sprintf("%s %d", def.Name, operands[0])
+	case 2:
+		return fmt.Sprintf("%s %d %d", def.Name, operands[0], operands[1])
+	}
+	return fmt.Sprintf("ERROR: unhandled operandCount for %s\n", def.Name)
+}
+
+type Opcode byte
+
+const (
+	OpConstant Opcode = iota
+	OpPop
+
+	OpAdd
+	OpSub
+	OpMul
+	OpDiv
+	OpMod
+	OpEqual
+	OpNotEqual
+	OpGreaterThan
+	OpLessThan
+	OpMinus
+	OpBang
+
+	OpTrue
+	OpFalse
+	OpNull
+
+	OpJumpNotTruthy
+	OpJump
+	OpIter
+	OpIterNext
+
+	OpGetGlobal
+	OpSetGlobal
+	OpSetGlobalConst
+	OpGetLocal
+	OpSetLocal
+	OpSetLocalConst
+	OpGetBuiltin
+	OpGetFree
+	OpCurrentClosure
+	OpAssignGlobal
+	OpAssignLocal
+	OpAssignFree
+	OpCaptureLocal
+	OpCaptureFree
+	OpBindLocal
+
+	OpArray
+	OpHash
+	OpIndex
+	OpSlice
+	OpSetIndex
+	OpDup
+	OpArrayAppend
+	OpHashInsert
+
+	OpCall
+	OpReturnValue
+	OpReturn
+	OpClosure
+
+	OpTry
+	OpTryFinally
+	OpEndTry
+	OpThrow
+)
+
+// Definition describes an opcode: its readable name and the width in bytes
+// of each of its operands. Constant indices and jump targets take four
+// bytes, variable indices and counts two and builtin indices one.
+type Definition struct {
+	Name          string
+	OperandWidths []int
+}
+
+var definitions = map[Opcode]*Definition{
+	OpConstant: {"OpConstant", []int{4}},
+	OpPop:      {"OpPop", []int{}},
+
+	OpAdd:         {"OpAdd", []int{}},
+	OpSub:         {"OpSub", []int{}},
+	OpMul:         {"OpMul", []int{}},
+	OpDiv:         {"OpDiv", []int{}},
+	OpMod:         {"OpMod", []int{}},
+	OpEqual:       {"OpEqual", []int{}},
+	OpNotEqual:    {"OpNotEqual", []int{}},
+	OpGreaterThan: {"OpGreaterThan", []int{}},
+	OpLessThan:    {"OpLessThan", []int{}},
+	OpMinus:       {"OpMinus", []int{}},
+	OpBang:        {"OpBang", []int{}},
+
+	OpTrue:  {"OpTrue", []int{}},
+	OpFalse: {"OpFalse", []int{}},
+	OpNull:  {"OpNull", []int{}},
+
+	OpJumpNotTruthy: {"OpJumpNotTruthy", []int{4}},
+	OpJump:          {"OpJump", []int{4}},
+	// OpIter replaces the value on the stack with an iterator over it.
+	// OpIterNext pops an iterator and pushes its next element, or jumps to
+	// its operand when there is none left.
+	OpIter:     {"OpIter", []int{}},
+	OpIterNext: {"OpIterNext", []int{4}},
+
+	OpGetGlobal: {"OpGetGlobal", []int{2}},
+	OpSetGlobal: {"OpSetGlobal", []int{2}},
+	OpGetLocal:  {"OpGetLocal", []int{2}},
+	OpSetLocal:  {"OpSetLocal", []int{2}},
+	// The const variants of OpSetGlobal and OpSetLocal make the variable a
+	// constant, which only they may set again.
+	OpSetGlobalConst: {"OpSetGlobalConst", []int{2}},
+	OpSetLocalConst:  {"OpSetLocalConst", []int{2}},
+	OpGetBuiltin:     {"OpGetBuiltin", []int{1}},
+	OpGetFree:        {"OpGetFree", []int{2}},
+	OpCurrentClosure: {"OpCurrentClosure", []int{}},
+	// The assign opcodes store the value on top of the stack into a
+	// variable that must already have a value, leaving the value in place.
+	OpAssignGlobal: {"OpAssignGlobal", []int{2}},
+	OpAssignLocal:  {"OpAssignLocal", []int{2}},
+	OpAssignFree:   {"OpAssignFree", []int{2}},
+	// The capture opcodes push a variable itself rather than its value, for
+	// a closure to share it with the function that defines it.
+	OpCaptureLocal: {"OpCaptureLocal", []int{2}},
+	OpCaptureFree:  {"OpCaptureFree", []int{2}},
+	// OpBindLocal pops a value into a local slot as a new variable, which
+	// closures that captured the variable in the slot before do not see.
+	OpBindLocal: {"OpBindLocal", []int{2}},
+
+	OpArray: {"OpArray", []int{2}},
+	OpHash:  {"OpHash", []int{2}},
+	OpIndex: {"OpIndex", []int{}},
+	// OpSlice pops the high and low bounds, null where they are omitted,
+	// and a collection, and pushes the slice of the collection.
+	OpSlice: {"OpSlice", []int{}},
+	// OpSetIndex pops a value, an index and a collection, stores the value
+	// in the collection and pushes it back.
+	OpSetIndex: {"OpSetIndex", []int{}},
+	// OpDup pushes copies of the given number of values on top of the stack.
+	OpDup: {"OpDup", []int{1}},
+	// OpArrayAppend and OpHashInsert pop the given number of elements, or
+	// keys and values, and add them to the array or hash below them, for
+	// literals too long to build on the stack at once.
+	OpArrayAppend: {"OpArrayAppend", []int{2}},
+	OpHashInsert:  {"OpHashInsert", []int{2}},
+
+	OpCall:        {"OpCall", []int{2}},
+	OpReturnValue: {"OpReturnValue", []int{}},
+	OpReturn:      {"OpReturn", []int{}},
+	// OpClosure takes the constant index of the compiled function and the
+	// number of free variables on the stack.
+	OpClosure: {"OpClosure", []int{4, 2}},
+
+	// OpTry installs a handler that catches the errors raised until the
+	// matching OpEndTry: it restores the stack, pushes the caught value and
+	// jumps to its operand. OpTryFinally does the same for a finally block,
+	// pushing the error itself, which OpThrow raises again at the end.
+	OpTry:        {"OpTry", []int{4}},
+	OpTryFinally: {"OpTryFinally", []int{4}},
+	OpEndTry:     {"OpEndTry", []int{}},
+	// OpThrow pops a value and raises an error carrying it.
+	OpThrow: {"OpThrow", []int{}},
+}
+
+func Lookup(op byte) (*Definition, error) {
+	def, ok := definitions[Opcode(op)]
+	if !ok {
+		return nil, fmt.Errorf("opcode %d undefined", op)
+	}
+	return def, nil
+}
+
+// Make encodes an instruction. Operands are written big endian with the
+// widths given by the opcode definition.
+func Make(op Opcode, operands ...int) []byte {
+	def, ok := definitions[op]
+	if !ok {
+		return []byte{}
+	}
+
+	instructionLen := 1
+	for _, w := range def.OperandWidths {
+		instructionLen += w
+	}
+
+	instruction := make([]byte, instructionLen)
+	instruction[0] = byte(op)
+
+	offset := 1
+	for i, o := range operands {
+		width := def.OperandWidths[i]
+		switch width {
+		case 4:
+			binary.BigEndian.PutUint32(instruction[offset:], uint32(o))
+		case 2:
+			binary.BigEndian.PutUint16(instruction[offset:], uint16(o))
+		case 1:
+			instruction[offset] = byte(o)
+		}
+		offset += width
+	}
+	return instruction
+}
+
+// ReadOperands decodes the operands of an instruction and returns them
+// together with the number of bytes read.
+func ReadOperands(def *Definition, ins Instructions) ([]int, int) {
+	operands := make([]int, len(def.OperandWidths))
+	offset := 0
+	for i, width := range def.OperandWidths {
+		switch width {
+		case 4:
+			operands[i] = int(ReadUint32(ins[offset:]))
+		case 2:
+			operands[i] = int(ReadUint16(ins[offset:]))
+		case 1:
+			operands[i] = int(ReadUint8(ins[offset:]))
+		}
+		offset += width
+	}
+	return operands, offset
+}
+
+// Fits reports whether operand can be encoded in width bytes.
+func Fits(operand, width int) bool {
+	return operand >= 0 && uint64(operand) < 1<<(8*uint(width))
+}
+
+func ReadUint32(ins Instructions) uint32 {
+	return binary.BigEndian.Uint32(ins)
+}
+
+func ReadUint16(ins Instructions) uint16 {
+	return binary.BigEndian.Uint16(ins)
+}
+
+func ReadUint8(ins Instructions) uint8 { return uint8(ins[0]) }
@@ -0,0 +1,935 @@
+// Package compiler lowers an AST to bytecode for the vm package.
+package compiler
+
+import (
+	"fmt"
+	"monkey/ast"
+	"monkey/code"
+	"monkey/evaluator"
+	"monkey/object"
+	"monkey/token"
+	"strings"
+)
+
+type Compiler struct {
+	constants []object.Object
+
+	symbolTable *SymbolTable
+
+	scopes     []CompilationScope
+	scopeIndex int
+
+	// err is the first operand found too large for its instruction.
+	err error
+}
+
+// CompilationScope holds the instructions of the function being compiled.
+type CompilationScope struct {
+	instructions        code.Instructions
+	lastInstruction     EmittedInstruction
+	previousInstruction EmittedInstruction
+
+	positions   map[int]token.Position
+	identifiers map[int]string
+
+	// loops are the loops enclosing the code being compiled, innermost last.
+	loops []*loop
+	// tries are the try expressions enclosing the code being compiled,
+	// innermost last.
+	tries []*tryBlock
+}
+
+// loop tracks the jumps of a loop being compiled.
+type loop struct {
+	continueTarget int   // the offset continue jumps to
+	breaks         []int // the offsets of the break jumps, patched at the end
+	tries          int   // the number of tries enclosing the loop
+}
+
+// tryBlock tracks a try expression being compiled, for the break, continue
+// and return statements that leave it early.
+type tryBlock struct {
+	handlers int                 // the handlers installed for the code being compiled
+	finally  *ast.BlockStatement // nil if there is none
+}
+
+type EmittedInstruction struct {
+	Opcode   code.Opcode
+	Position int
+}
+
+// Bytecode is the compiled main program together with its constant pool.
+type Bytecode struct {
+	Instructions code.Instructions
+	Constants    []object.Object
+	// Positions and Identifiers carry the debug information of the main
+	// program, see object.CompiledFunction.
+	Positions   map[int]token.Position
+	Identifiers map[int]string
+	// NumLocals is the number of local variables of the main program,
+	// which only the parameters of catches at the top level use.
+	NumLocals int
+}
+
+func New() *Compiler {
+	symbolTable := NewSymbolTable()
+	for i, name := range evaluator.BuiltinNames() {
+		symbolTable.DefineBuiltin(i, name)
+	}
+	return &Compiler{
+		constants:   []object.Object{},
+		symbolTable: symbolTable,
+		scopes:      []CompilationScope{newCompilationScope()},
+		scopeIndex:  0,
+	}
+}
+
+// NewWithState creates a compiler that continues with the globals and
+// constants of an earlier compilation, as the REPL needs to.
+func NewWithState(s *SymbolTable, constants []object.Object) *Compiler {
+	compiler := New()
+	compiler.symbolTable = s
+	compiler.constants = constants
+	return compiler
+}
+
+func newCompilationScope() CompilationScope {
+	return CompilationScope{
+		instructions: code.Instructions{},
+		positions:    map[int]token.Position{},
+		identifiers:  map[int]string{},
+	}
+}
+
+// Compile compiles node. It fails if the program has more constants,
+// variables, arguments or code than the operands of the instructions can
+// address.
+func (c *Compiler) Compile(node ast.Node) error {
+	if err := c.compile(node); err != nil {
+		return err
+	}
+	return c.err
+}
+
+func (c *Compiler) compile(node ast.Node) error {
+	switch node := node.(type) {
+	case *ast.Program:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.ExpressionStatement:
+		if node.Expression == nil {
+			return nil
+		}
+		if err := c.Compile(node.Expression); err != nil {
+			return err
+		}
+		c.emit(code.OpPop)
+
+	case *ast.BlockStatement:
+		for _, s := range node.Statements {
+			if err := c.Compile(s); err != nil {
+				return err
+			}
+		}
+
+	case *ast.LetStatement:
+		// The name is defined after compiling the value, so that the value
+		// still sees an outer binding of the same name, as in Eval.
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.declare(node.Name, node.IsConst())
+
+	case *ast.ReturnStatement:
+		if err := c.Compile(node.ReturnValue); err != nil {
+			return err
+		}
+		if err := c.leaveTries(0); err != nil {
+			return err
+		}
+		c.emit(code.OpReturnValue)
+
+	case *ast.ThrowStatement:
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		c.emitAt(node.Token.Pos, code.OpThrow)
+
+	case *ast.TryExpression:
+		return c.compileTry(node)
+
+	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return c.compileLogical(node)
+		}
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		op, ok := infixOperators[node.Operator]
+		if !ok {
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+		c.emitAt(node.Token.Pos, op)
+
+	case *ast.PrefixOperator:
+		if err := c.Compile(node.Right); err != nil {
+			return err
+		}
+		switch node.Operator {
+		case "!":
+			c.emitAt(node.Token.Pos, code.OpBang)
+		case "-":
+			c.emitAt(node.Token.Pos, code.OpMinus)
+		default:
+			return fmt.Errorf("unknown operator %s", node.Operator)
+		}
+
+	case *ast.IfExpression:
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		// Emit an `OpJumpNotTruthy` with a bogus value, patched below.
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		if err := c.compileBlockValue(node.Consequence); err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+
+		if node.Alternative == nil {
+			c.emit(code.OpNull)
+		} else if err := c.compileBlockValue(node.Alternative); err != nil {
+			return err
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+
+	case *ast.AssignExpression:
+		return c.compileAssign(node)
+
+	case *ast.WhileStatement:
+		start := len(c.currentInstructions())
+		if err := c.Compile(node.Condition); err != nil {
+			return err
+		}
+		jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+		if err := c.compileLoopBody(start, node.Body); err != nil {
+			return err
+		}
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		// A loop evaluates to null, like in Eval.
+		c.emit(code.OpNull)
+		c.emit(code.OpPop)
+
+	case *ast.ForStatement:
+		if err := c.Compile(node.Iterable); err != nil {
+			return err
+		}
+		c.emitAt(node.Iterable.Pos(), code.OpIter)
+		// The iterator lives in a hidden variable, named so that it cannot
+		// clash with an identifier, and reused by loops at the same depth.
+		iterator := c.symbolTable.Define(fmt.Sprintf("@iter%d", len(c.scopes[c.scopeIndex].loops)))
+		c.storeSymbol(iterator)
+
+		start := len(c.currentInstructions())
+		c.loadSymbol(iterator)
+		iterNextPos := c.emit(code.OpIterNext, 9999)
+		c.declare(node.Variable, false)
+		if err := c.compileLoopBody(start, node.Body); err != nil {
+			return err
+		}
+		c.changeOperand(iterNextPos, len(c.currentInstructions()))
+		c.emit(code.OpNull)
+		c.emit(code.OpPop)
+
+	case *ast.BranchStatement:
+		loops := c.scopes[c.scopeIndex].loops
+		if len(loops) == 0 {
+			return fmt.Errorf("%s: %s outside of a loop", node.Pos(), node.Token.Literal)
+		}
+		innermost := loops[len(loops)-1]
+		if err := c.leaveTries(innermost.tries); err != nil {
+			return err
+		}
+		if node.Token.Type == token.BREAK {
+			innermost.breaks = append(innermost.breaks, c.emit(code.OpJump, 9999))
+		} else {
+			c.emit(code.OpJump, innermost.continueTarget)
+		}
+
+	case *ast.IntegerLiteral:
+		integer := &object.Integer{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(integer))
+
+	case *ast.BigIntLiteral:
+		bigInt := &object.BigInt{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(bigInt))
+
+	case *ast.FloatLiteral:
+		float := &object.Float{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(float))
+
+	case *ast.StringLiteral:
+		str := &object.String{Value: node.Value}
+		c.emit(code.OpConstant, c.addConstant(str))
+
+	case *ast.Boolean:
+		if node.Value {
+			c.emit(code.OpTrue)
+		} else {
+			c.emit(code.OpFalse)
+		}
+
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.Resolve(node.Value)
+		if !ok {
+			// Like Eval, an unknown name is only an error once it is read.
+			// It may still be bound by a later global let, so it gets a
+			// global slot that stays empty until then.
+			symbol = c.symbolTable.global().Define(node.Value)
+		}
+		c.loadSymbol(symbol)
+		c.setIdentifier(node.Value)
+		c.setPosition(node.Pos())
+
+	case *ast.ArrayLiteral:
+		// Long literals are built in chunks, so that they do not need more
+		// of the stack than a chunk.
+		for start := 0; start == 0 || start < len(node.Elements); start += literalChunk {
+			end := start + literalChunk
+			if end > len(node.Elements) {
+				end = len(node.Elements)
+			}
+			for _, el := range node.Elements[start:end] {
+				if err := c.Compile(el); err != nil {
+					return err
+				}
+			}
+			if start == 0 {
+				c.emit(code.OpArray, end-start)
+			} else {
+				c.emit(code.OpArrayAppend, end-start)
+			}
+		}
+
+	case *ast.HashLiteral:
+		for start := 0; start == 0 || start < len(node.Pairs); start += literalChunk {
+			end := start + literalChunk
+			if end > len(node.Pairs) {
+				end = len(node.Pairs)
+			}
+			for _, pair := range node.Pairs[start:end] {
+				if err := c.Compile(pair.Key); err != nil {
+					return err
+				}
+				if err := c.Compile(pair.Value); err != nil {
+					return err
+				}
+			}
+			if start == 0 {
+				c.emitAt(node.Pos(), code.OpHash, (end-start)*2)
+			} else {
+				c.emitAt(node.Pos(), code.OpHashInsert, (end-start)*2)
+			}
+		}
+
+	case *ast.IndexExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(node.Index); err != nil {
+			return err
+		}
+		c.emitAt(node.Token.Pos, code.OpIndex)
+
+	case *ast.SliceExpression:
+		if err := c.Compile(node.Left); err != nil {
+			return err
+		}
+		for _, bound := range []ast.Expression{node.Low, node.High} {
+			if bound == nil {
+				c.emit(code.OpNull)
+			} else if err := c.Compile(bound); err != nil {
+				return err
+			}
+		}
+		c.emitAt(node.Token.Pos, code.OpSlice)
+
+	case *ast.FunctionLiteral:
+		c.enterScope()
+		c.symbolTable.pending = map[string]bool{}
+		declaredNames(node.Body, c.symbolTable.pending)
+		if node.Name != "" {
+			c.symbolTable.DefineFunctionName(node.Name)
+		}
+		for _, p := range node.Parameters {
+			c.symbolTable.Define(p.Value)
+		}
+		if err := c.Compile(node.Body); err != nil {
+			return err
+		}
+		if c.lastInstructionIs(code.OpPop) {
+			c.replaceLastPopWithReturn()
+		}
+		if !c.lastInstructionIs(code.OpReturnValue) {
+			c.emit(code.OpReturn)
+		}
+
+		freeSymbols := c.symbolTable.FreeSymbols
+		numLocals := c.symbolTable.numDefinitions
+		scope := c.leaveScope()
+
+		for _, s := range freeSymbols {
+			c.captureSymbol(s)
+		}
+
+		compiledFn := &object.CompiledFunction{
+			Instructions:  scope.instructions,
+			NumLocals:     numLocals,
+			NumParameters: len(node.Parameters),
+			Positions:     scope.positions,
+			Identifiers:   scope.identifiers,
+			Literal:       node,
+		}
+		fnIndex := c.addConstant(compiledFn)
+		c.emit(code.OpClosure, fnIndex, len(freeSymbols))
+
+	case *ast.CallExpression:
+		if err := c.Compile(node.Function); err != nil {
+			return err
+		}
+		for _, a := range node.Arguments {
+			if err := c.Compile(a); err != nil {
+				return err
+			}
+		}
+		c.emitAt(node.Pos(), code.OpCall, len(node.Arguments))
+
+	case *ast.BadStatement, *ast.BadExpression:
+		return fmt.Errorf("%s: invalid syntax: %s", node.Pos(), node.String())
+
+	default:
+		return fmt.Errorf("cannot compile %T", node)
+	}
+
+	return nil
+}
+
+// declaredNames adds the names declared by let anywhere in block to names,
+// including nested blocks and loops but not nested functions.
+func declaredNames(block *ast.BlockStatement, names map[string]bool) {
+	if block == nil {
+		return
+	}
+	for _, stmt := range block.Statements {
+		switch stmt := stmt.(type) {
+		case *ast.LetStatement:
+			names[stmt.Name.Value] = true
+			declaredBranchNames(stmt.Value, names)
+		case *ast.ExpressionStatement:
+			declaredBranchNames(stmt.Expression, names)
+		case *ast.BlockStatement:
+			declaredNames(stmt, names)
+		case *ast.WhileStatement:
+			declaredNames(stmt.Body, names)
+		case *ast.ForStatement:
+			names[stmt.Variable.Value] = true
+			declaredNames(stmt.Body, names)
+		}
+	}
+}
+
+func declaredBranchNames(exp ast.Expression, names map[string]bool) {
+	switch exp := exp.(type) {
+	case *ast.IfExpression:
+		declaredNames(exp.Consequence, names)
+		declaredNames(exp.Alternative, names)
+	case *ast.TryExpression:
+		declaredNames(exp.Block, names)
+		declaredNames(exp.Catch, names)
+		declaredNames(exp.Finally, names)
+	}
+}
+
+// literalChunk is the number of elements, or pairs, of an array or hash
+// literal that are put on the stack at once.
+const literalChunk = 64
+
+// operandNames names what the operands of the instructions count, for the
+// error reported when a program has too many of them.
+var operandNames = map[code.Opcode][]string{
+	code.OpConstant:       {"constants"},
+	code.OpJumpNotTruthy:  {"bytes of code in a function"},
+	code.OpJump:           {"bytes of code in a function"},
+	code.OpIterNext:       {"bytes of code in a function"},
+	code.OpTry:            {"bytes of code in a function"},
+	code.OpTryFinally:     {"bytes of code in a function"},
+	code.OpGetGlobal:      {"global variables"},
+	code.OpSetGlobal:      {"global variables"},
+	code.OpSetGlobalConst: {"global variables"},
+	code.OpAssignGlobal:   {"global variables"},
+	code.OpGetLocal:       {"local variables in a function"},
+	code.OpSetLocal:       {"local variables in a function"},
+	code.OpSetLocalConst:  {"local variables in a function"},
+	code.OpAssignLocal:    {"local variables in a function"},
+	code.OpCaptureLocal:   {"local variables in a function"},
+	code.OpBindLocal:      {"local variables in a function"},
+	code.OpGetFree:        {"free variables in a function"},
+	code.OpAssignFree:     {"free variables in a function"},
+	code.OpCaptureFree:    {"free variables in a function"},
+	code.OpCall:           {"arguments in a call"},
+	code.OpClosure:        {"constants", "free variables in a function"},
+}
+
+var infixOperators = map[string]code.Opcode{
+	"+":  code.OpAdd,
+	"-":  code.OpSub,
+	"*":  code.OpMul,
+	"/":  code.OpDiv,
+	"%":  code.OpMod,
+	"==": code.OpEqual,
+	"!=": code.OpNotEqual,
+	">":  code.OpGreaterThan,
+	"<":  code.OpLessThan,
+}
+
+// compileBlockValue compiles a block used as an expression, leaving the
+// value of its last expression statement on the stack, or null if it does
+// not end with one.
+func (c *Compiler) compileBlockValue(block *ast.BlockStatement) error {
+	start := len(c.currentInstructions())
+	if err := c.Compile(block); err != nil {
+		return err
+	}
+	// An empty block must not take the OpPop of the code before it.
+	if len(c.currentInstructions()) > start && c.lastInstructionIs(code.OpPop) {
+		c.removeLastPop()
+	} else {
+		c.emit(code.OpNull)
+	}
+	return nil
+}
+
+// compileLogical compiles && and || so that the right operand is only
+// evaluated when the left one does not decide the result. A right operand
+// that is evaluated is converted to a boolean with a double negation.
+func (c *Compiler) compileLogical(node *ast.InfixExpression) error {
+	if err := c.Compile(node.Left); err != nil {
+		return err
+	}
+	// Emit an `OpJumpNotTruthy` with a bogus value, patched below.
+	jumpNotTruthyPos := c.emit(code.OpJumpNotTruthy, 9999)
+
+	if node.Operator == "&&" {
+		if err := c.compileTruthiness(node.Right); err != nil {
+			return err
+		}
+		jumpPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+		c.emit(code.OpFalse)
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+		return nil
+	}
+
+	c.emit(code.OpTrue)
+	jumpPos := c.emit(code.OpJump, 9999)
+	c.changeOperand(jumpNotTruthyPos, len(c.currentInstructions()))
+	if err := c.compileTruthiness(node.Right); err != nil {
+		return err
+	}
+	c.changeOperand(jumpPos, len(c.currentInstructions()))
+	return nil
+}
+
+func (c *Compiler) compileTruthiness(node ast.Expression) error {
+	if err := c.Compile(node); err != nil {
+		return err
+	}
+	c.emit(code.OpBang)
+	c.emit(code.OpBang)
+	return nil
+}
+
+func (c *Compiler) compileAssign(node *ast.AssignExpression) error {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		symbol, ok := c.symbolTable.resolveVariable(target.Value)
+		switch {
+		case !ok:
+			// As for reads, the name may still be declared by a later let.
+			symbol = c.symbolTable.global().Define(target.Value)
+		case symbol.Scope == BuiltinScope:
+			// Builtins are not variables. Assign to a slot that never has a
+			// value, to fail like assigning to an undeclared name.
+			symbol = c.symbolTable.global().Define("@builtin " + target.Value)
+		}
+		if node.Operator != "=" {
+			c.loadSymbol(symbol)
+			c.setIdentifier(target.Value)
+			c.setPosition(target.Pos())
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if node.Operator != "=" {
+			if err := c.emitCompoundOperator(node); err != nil {
+				return err
+			}
+		}
+		switch symbol.Scope {
+		case GlobalScope:
+			c.emitAt(target.Pos(), code.OpAssignGlobal, symbol.Index)
+		case LocalScope:
+			c.emitAt(target.Pos(), code.OpAssignLocal, symbol.Index)
+		case FreeScope:
+			c.emitAt(target.Pos(), code.OpAssignFree, symbol.Index)
+		}
+		c.setIdentifier(target.Value)
+
+	case *ast.IndexExpression:
+		if err := c.Compile(target.Left); err != nil {
+			return err
+		}
+		if err := c.Compile(target.Index); err != nil {
+			return err
+		}
+		if node.Operator != "=" {
+			c.emit(code.OpDup, 2)
+			c.emitAt(target.Token.Pos, code.OpIndex)
+		}
+		if err := c.Compile(node.Value); err != nil {
+			return err
+		}
+		if node.Operator != "=" {
+			if err := c.emitCompoundOperator(node); err != nil {
+				return err
+			}
+		}
+		c.emitAt(target.Token.Pos, code.OpSetIndex)
+
+	default:
+		return fmt.Errorf("%s: cannot assign to %s", node.Pos(), node.Target.String())
+	}
+	return nil
+}
+
+// emitCompoundOperator emits the operator of a compound assignment such as
+// +=, which combines the current value and the assigned value.
+func (c *Compiler) emitCompoundOperator(node *ast.AssignExpression) error {
+	op, ok := infixOperators[strings.TrimSuffix(node.Operator, "=")]
+	if !ok {
+		return fmt.Errorf("unknown operator %s", node.Operator)
+	}
+	c.emitAt(node.Token.Pos, op)
+	return nil
+}
+
+// compileLoopBody compiles the body of a loop that starts at offset start,
+// followed by the jump back to it. The breaks in the body jump past it.
+func (c *Compiler) compileLoopBody(start int, body *ast.BlockStatement) error {
+	l := &loop{continueTarget: start, tries: len(c.scopes[c.scopeIndex].tries)}
+	c.scopes[c.scopeIndex].loops = append(c.scopes[c.scopeIndex].loops, l)
+	if err := c.Compile(body); err != nil {
+		return err
+	}
+	c.emit(code.OpJump, start)
+	loops := c.scopes[c.scopeIndex].loops
+	c.scopes[c.scopeIndex].loops = loops[:len(loops)-1]
+
+	end := len(c.currentInstructions())
+	for _, pos := range l.breaks {
+		c.changeOperand(pos, end)
+	}
+	return nil
+}
+
+// compileTry compiles a try expression. The finally block is compiled
+// once for leaving the try normally, and once more for a handler that runs
+// it and raises the error that ended the try again:
+//
+//	OpTryFinally finally
+//	OpTry catch
+//	<block>
+//	OpEndTry
+//	OpJump done
+//	catch: <bind the caught value> <catch block>
+//	done: OpEndTry
+//	<finally block>
+//	OpJump end
+//	finally: <finally block> OpThrow
+//	end:
+func (c *Compiler) compileTry(node *ast.TryExpression) error {
+	t := &tryBlock{finally: node.Finally}
+	c.scopes[c.scopeIndex].tries = append(c.scopes[c.scopeIndex].tries, t)
+
+	finallyPos := -1
+	if node.Finally != nil {
+		finallyPos = c.emit(code.OpTryFinally, 9999)
+		t.handlers++
+	}
+	if node.Catch == nil {
+		if err := c.compileBlockValue(node.Block); err != nil {
+			return err
+		}
+	} else {
+		catchPos := c.emit(code.OpTry, 9999)
+		t.handlers++
+		if err := c.compileBlockValue(node.Block); err != nil {
+			return err
+		}
+		c.emit(code.OpEndTry)
+		t.handlers--
+		jumpPos := c.emit(code.OpJump, 9999)
+
+		c.changeOperand(catchPos, len(c.currentInstructions()))
+		if node.Parameter != nil {
+			// The parameter is a new variable each time, seen only in
+			// the catch block.
+			symbol, restore := c.symbolTable.defineScoped(node.Parameter.Value)
+			c.emit(code.OpBindLocal, symbol.Index)
+			err := c.compileBlockValue(node.Catch)
+			restore()
+			if err != nil {
+				return err
+			}
+		} else {
+			c.emit(code.OpPop)
+			if err := c.compileBlockValue(node.Catch); err != nil {
+				return err
+			}
+		}
+		c.changeOperand(jumpPos, len(c.currentInstructions()))
+	}
+	tries := c.scopes[c.scopeIndex].tries
+	c.scopes[c.scopeIndex].tries = tries[:len(tries)-1]
+
+	if node.Finally != nil {
+		c.emit(code.OpEndTry)
+		if err := c.Compile(node.Finally); err != nil {
+			return err
+		}
+		endPos := c.emit(code.OpJump, 9999)
+		c.changeOperand(finallyPos, len(c.currentInstructions()))
+		if err := c.Compile(node.Finally); err != nil {
+			return err
+		}
+		c.emit(code.OpThrow)
+		c.changeOperand(endPos, len(c.currentInstructions()))
+	}
+	return nil
+}
+
+// leaveTries emits the code for leaving the enclosing tries early, down to
+// the one at index depth: it removes their handlers and runs their finally
+// blocks, innermost first.
+func (c *Compiler) leaveTries(depth int) error {
+	tries := c.scopes[c.scopeIndex].tries
+	defer func() { c.scopes[c.scopeIndex].tries = tries }()
+	for i := len(tries) - 1; i >= depth; i-- {
+		for j := 0; j < tries[i].handlers; j++ {
+			c.emit(code.OpEndTry)
+		}
+		if tries[i].finally != nil {
+			c.scopes[c.scopeIndex].tries = tries[:i]
+			if err := c.Compile(tries[i].finally); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// declare binds ident to the value on top of the stack, as let or const.
+// Redeclaring a constant with let fails at runtime, so the instruction
+// records the position and name of ident.
+func (c *Compiler) declare(ident *ast.Identifier, constant bool) {
+	symbol := c.symbolTable.Define(ident.Value)
+	switch {
+	case constant && symbol.Scope == GlobalScope:
+		c.emit(code.OpSetGlobalConst, symbol.Index)
+	case constant:
+		c.emit(code.OpSetLocalConst, symbol.Index)
+	default:
+		c.storeSymbol(symbol)
+	}
+	c.setPosition(ident.Pos())
+	c.setIdentifier(ident.Value)
+}
+
+func (c *Compiler) storeSymbol(s Symbol) {
+	if s.Scope == GlobalScope {
+		c.emit(code.OpSetGlobal, s.Index)
+	} else {
+		c.emit(code.OpSetLocal, s.Index)
+	}
+}
+
+// captureSymbol pushes a variable for a closure to capture. Capturing does
+// not read the variable, so a variable without a value yet is only reported
+// when the closure reads it.
+func (c *Compiler) captureSymbol(s Symbol) {
+	switch s.Scope {
+	case LocalScope:
+		c.emit(code.OpCaptureLocal, s.Index)
+	case FreeScope:
+		c.emit(code.OpCaptureFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+func (c *Compiler) loadSymbol(s Symbol) {
+	switch s.Scope {
+	case GlobalScope:
+		c.emit(code.OpGetGlobal, s.Index)
+	case LocalScope:
+		c.emit(code.OpGetLocal, s.Index)
+	case BuiltinScope:
+		c.emit(code.OpGetBuiltin, s.Index)
+	case FreeScope:
+		c.emit(code.OpGetFree, s.Index)
+	case FunctionScope:
+		c.emit(code.OpCurrentClosure)
+	}
+}
+
+func (c *Compiler) Bytecode() *Bytecode {
+	scope := c.scopes[c.scopeIndex]
+	return &Bytecode{
+		Instructions: scope.instructions,
+		Constants:    c.constants,
+		Positions:    scope.positions,
+		Identifiers:  scope.identifiers,
+		NumLocals:    c.symbolTable.numMainLocals,
+	}
+}
+
+// SymbolTable returns the global symbol table, to be passed to NewWithState
+// when compiling more code against the same globals.
+func (c *Compiler) SymbolTable() *SymbolTable {
+	return c.symbolTable
+}
+
+func (c *Compiler) addConstant(obj object.Object) int {
+	c.constants = append(c.constants, obj)
+	return len(c.constants) - 1
+}
+
+func (c *Compiler) emit(op code.Opcode, operands ...int) int {
+	c.checkOperands(op, operands)
+	ins := code.Make(op, operands...)
+	pos := c.addInstruction(ins)
+	c.setLastInstruction(op, pos)
+	return pos
+}
+
+// emitAt emits an instruction that may fail at runtime, recording the
+// source position its error is reported at.
+func (c *Compiler) emitAt(pos token.Position, op code.Opcode, operands ...int) int {
+	offset := c.emit(op, operands...)
+	c.setPosition(pos)
+	return offset
+}
+
+// setPosition records pos for the last emitted instruction.
+func (c *Compiler) setPosition(pos token.Position) {
+	scope := c.scopes[c.scopeIndex]
+	scope.positions[scope.lastInstruction.Position] = pos
+}
+
+// setIdentifier records the name read by the last emitted instruction, for
+// the error raised when the variable has no value.
+func (c *Compiler) setIdentifier(name string) {
+	scope := c.scopes[c.scopeIndex]
+	scope.identifiers[scope.lastInstruction.Position] = name
+}
+
+// checkOperands records an error if an operand does not fit the width the
+// definition of op gives it.
+func (c *Compiler) checkOperands(op code.Opcode, operands []int) {
+	def, err := code.Lookup(byte(op))
+	if err != nil || c.err != nil {
+		return
+	}
+	for i, width := range def.OperandWidths {
+		if i < len(operands) && !code.Fits(operands[i], width) {
+			c.err = fmt.Errorf("too many %s: the limit is %d",
+				operandNames[op][i], 1<<(8*uint(width)))
+			return
+		}
+	}
+}
+
+func (c *Compiler) setLastInstruction(op code.Opcode, pos int) {
+	previous := c.scopes[c.scopeIndex].lastInstruction
+	last := EmittedInstruction{Opcode: op, Position: pos}
+	c.scopes[c.scopeIndex].previousInstruction = previous
+	c.scopes[c.scopeIndex].lastInstruction = last
+}
+
+func (c *Compiler) currentInstructions() code.Instructions {
+	return c.scopes[c.scopeIndex].instructions
+}
+
+func (c *Compiler) addInstruction(ins []byte) int {
+	posNewInstruction := len(c.currentInstructions())
+	updatedInstructions := append(c.currentInstructions(), ins...)
+	c.scopes[c.scopeIndex].instructions = updatedInstructions
+	return posNewInstruction
+}
+
+func (c *Compiler) lastInstructionIs(op code.Opcode) bool {
+	if len(c.currentInstructions()) == 0 {
+		return false
+	}
+	return c.scopes[c.scopeIndex].lastInstruction.Opcode == op
+}
+
+func (c *Compiler) removeLastPop() {
+	last := c.scopes[c.scopeIndex].lastInstruction
+	previous := c.scopes[c.scopeIndex].previousInstruction
+
+	old := c.currentInstructions()
+	c.scopes[c.scopeIndex].instructions = old[:last.Position]
+	c.scopes[c.scopeIndex].lastInstruction = previous
+}
+
+func (c *Compiler) replaceInstruction(pos int, newInstruction []byte) {
+	ins := c.currentInstructions()
+	for i := 0; i < len(newInstruction); i++ {
+		ins[pos+i] = newInstruction[i]
+	}
+}
+
+func (c *Compiler) replaceLastPopWithReturn() {
+	lastPos := c.scopes[c.scopeIndex].lastInstruction.Position
+	c.replaceInstruction(lastPos, code.Make(code.OpReturnValue))
+	c.scopes[c.scopeIndex].lastInstruction.Opcode = code.OpReturnValue
+}
+
+func (c *Compiler) changeOperand(opPos int, operand int) {
+	op := code.Opcode(c.currentInstructions()[opPos])
+	c.checkOperands(op, []int{operand})
+	newInstruction := code.Make(op, operand)
+	c.replaceInstruction(opPos, newInstruction)
+}
+
+func (c *Compiler) enterScope() {
+	c.scopes = append(c.scopes, newCompilationScope())
+	c.scopeIndex++
+	c.symbolTable = NewEnclosedSymbolTable(c.symbolTable)
+}
+
+func (c *Compiler) leaveScope() CompilationScope {
+	scope := c.scopes[c.scopeIndex]
+	c.scopes = c.scopes[:len(c.scopes)-1]
+	c.scopeIndex--
+	c.symbolTable = c.symbolTable.Outer
+	return scope
+}
@@ -0,0 +1,166 @@
+package compiler
+
+type SymbolScope string
+
+const (
+	GlobalScope   SymbolScope = "GLOBAL"
+	LocalScope    SymbolScope = "LOCAL"
+	BuiltinScope  SymbolScope = "BUILTIN"
+	FreeScope     SymbolScope = "FREE"
+	FunctionScope SymbolScope = "FUNCTION"
+)
+
+type Symbol struct {
+	Name  string
+	Scope SymbolScope
+	Index int
+}
+
+// SymbolTable resolves names to storage slots. There is one table for the
+// globals and one for each function being compiled, linked by Outer.
+type SymbolTable struct {
+	Outer *SymbolTable
+
+	store          map[string]Symbol
+	numDefinitions int
+	// numMainLocals counts the locals of the main program, in the global
+	// table, see defineScoped.
+	numMainLocals int
+
+	// FreeSymbols are the symbols of enclosing functions referenced from
+	// this one, in the order the closure captures them.
+	FreeSymbols []Symbol
+
+	// pending are the names a let in this function declares further on. A
+	// nested function referring to one gets the local it will be bound to,
+	// since by the time the nested function runs the let usually has been.
+	pending map[string]bool
+}
+
+func NewSymbolTable() *SymbolTable {
+	s := make(map[string]Symbol)
+	free := []Symbol{}
+	return &SymbolTable{store: s, FreeSymbols: free}
+}
+
+func NewEnclosedSymbolTable(outer *SymbolTable) *SymbolTable {
+	s := NewSymbolTable()
+	s.Outer = outer
+	return s
+}
+
+// Define binds name in this table. Like let in the evaluator, defining a
+// name again in the same scope reuses its slot rather than creating a new
+// one. That includes a name bound by defineScoped.
+func (s *SymbolTable) Define(name string) Symbol {
+	scope := LocalScope
+	if s.Outer == nil {
+		scope = GlobalScope
+	}
+	if symbol, ok := s.store[name]; ok && (symbol.Scope == scope || symbol.Scope == LocalScope) {
+		return symbol
+	}
+	symbol := Symbol{Name: name, Index: s.numDefinitions, Scope: scope}
+	s.store[name] = symbol
+	s.numDefinitions++
+	return symbol
+}
+
+// defineScoped binds name to a new local variable for the code compiled
+// until restore is called, such as the parameter of a catch, which covers
+// only the catch block. In the global table, the variable is a local of
+// the main program.
+func (s *SymbolTable) defineScoped(name string) (symbol Symbol, restore func()) {
+	previous, shadowed := s.store[name]
+	if s.Outer == nil {
+		symbol = Symbol{Name: name, Index: s.numMainLocals, Scope: LocalScope}
+		s.numMainLocals++
+	} else {
+		symbol = Symbol{Name: name, Index: s.numDefinitions, Scope: LocalScope}
+		s.numDefinitions++
+	}
+	s.store[name] = symbol
+	return symbol, func() {
+		if shadowed {
+			s.store[name] = previous
+		} else {
+			delete(s.store, name)
+		}
+	}
+}
+
+func (s *SymbolTable) DefineBuiltin(index int, name string) Symbol {
+	symbol := Symbol{Name: name, Index: index, Scope: BuiltinScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+// DefineFunctionName makes name refer to the function being compiled, which
+// lets a function bound by let call itself.
+func (s *SymbolTable) DefineFunctionName(name string) Symbol {
+	symbol := Symbol{Name: name, Index: 0, Scope: FunctionScope}
+	s.store[name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) defineFree(original Symbol) Symbol {
+	s.FreeSymbols = append(s.FreeSymbols, original)
+	symbol := Symbol{Name: original.Name, Index: len(s.FreeSymbols) - 1}
+	symbol.Scope = FreeScope
+	s.store[original.Name] = symbol
+	return symbol
+}
+
+func (s *SymbolTable) Resolve(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	if !ok && s.Outer != nil {
+		obj, ok = s.Outer.resolveFromNested(name)
+		if !ok {
+			return obj, ok
+		}
+		if obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+			return obj, ok
+		}
+		free := s.defineFree(obj)
+		return free, true
+	}
+	return obj, ok
+}
+
+// resolveFromNested resolves a name referenced by a function nested in this
+// one, defining it early if a later let in this function declares it.
+func (s *SymbolTable) resolveFromNested(name string) (Symbol, bool) {
+	if _, ok := s.store[name]; !ok && s.pending[name] {
+		return s.Define(name), true
+	}
+	return s.Resolve(name)
+}
+
+// resolveVariable resolves name like Resolve, but as the target of an
+// assignment: the name of the function being compiled then refers to the
+// variable the function is bound to in an enclosing scope.
+func (s *SymbolTable) resolveVariable(name string) (Symbol, bool) {
+	obj, ok := s.store[name]
+	if ok && obj.Scope != FunctionScope {
+		return obj, true
+	}
+	if s.Outer == nil {
+		return Symbol{}, false
+	}
+	if _, defined := s.Outer.store[name]; !defined && s.Outer.pending[name] {
+		s.Outer.Define(name)
+	}
+	obj, ok = s.Outer.resolveVariable(name)
+	if !ok || obj.Scope == GlobalScope || obj.Scope == BuiltinScope {
+		return obj, ok
+	}
+	return s.defineFree(obj), true
+}
+
+// global returns the outermost table, which holds the global bindings.
+func (s *SymbolTable) global() *SymbolTable {
+	for s.Outer != nil {
+		s = s.Outer
+	}
+	return s
+}
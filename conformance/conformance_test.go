@@ -0,0 +1,543 @@
+package conformance
+
+import (
+	"bytes"
+	"fmt"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/vm"
+	"strings"
+	"testing"
+)
+
+// backend runs a program and returns the Inspect output of its result, the
+// Inspect output of the error it stopped with, or "" if it has no result.
+type backend func(t *testing.T, input string) string
+
+var backends = map[string]backend{
+	"eval": runEval,
+	"vm":   runVM,
+}
+
+type conformanceTest struct {
+	input    string
+	expected string
+}
+
+var tests = []conformanceTest{
+	// Integers and booleans
+	{"5", "5"},
+	{"-50 + 100 + -50", "0"},
+	{"2 * (5 + 10) / 3", "10"},
+	{"1 < 2 == true", "true"},
+	{"!!5", "true"},
+	{"(1 > 2) != false", "false"},
+	{"true == true", "true"},
+
+	// Division and modulo
+	{"7 / 2", "3"},
+	{"-7 / 2", "-3"},
+	{"7 % 3", "1"},
+	{"-7 % 3", "-1"},
+	{"7.5 % 2", "1.5"},
+	{"let x = 10; x %= 4; x", "2"},
+	{"1 / 0", "ERROR: 1:3: division by zero"},
+	{"5 % 0", "ERROR: 1:3: modulo by zero"},
+	{"let x = 1; x /= 0", "ERROR: 1:14: division by zero"},
+	{"1.0 / 0", "+Inf"},
+	{"[2.0, 1.5 * 2, 1e-9, 0.1 + 0.2]", "[2.0, 3.0, 1e-09, 0.30000000000000004]"},
+	{"9223372036854775807 + 1", "9223372036854775808"},
+
+	// Big integers
+	{"123456789012345678901234567890", "123456789012345678901234567890"},
+	{"-9223372036854775808", "-9223372036854775808"},
+	{"99999999999999999999 + 1", "100000000000000000000"},
+	{"99999999999999999999 - 99999999999999999998", "1"},
+	{"99999999999999999999 * 99999999999999999999", "9999999999999999999800000000000000000001"},
+	{"100000000000000000000 / 3", "33333333333333333333"},
+	{"-100000000000000000000 % 3", "-1"},
+	{"99999999999999999999 / 0", "ERROR: 1:22: division by zero"},
+	{"[99999999999999999999 > 1, 1 < 99999999999999999999, 99999999999999999999 == 99999999999999999999, 99999999999999999999 != 1]",
+		"[true, true, true, true]"},
+	{"99999999999999999999 == 99999999999999999999.0", "true"},
+	{"-18446744073709551616 < -9223372036854775808", "true"},
+	{`{99999999999999999999: "big", 1: "small"}[99999999999999999998 + 1]`, "big"},
+	{"let x = 99999999999999999999; x -= 99999999999999999990; [x, x + 1]", "[9, 10]"},
+	{"[1, 2][99999999999999999999]", "null"},
+	{"[1, 2][-99999999999999999999]", "null"},
+	{`"ab"[99999999999999999999]`, "null"},
+	{`"ab"[-99999999999999999999]`, "null"},
+	{"[1, 2, 3][-99999999999999999999:99999999999999999999]", "[1, 2, 3]"},
+	{"[1, 2, 3][99999999999999999999:]", "[]"},
+	{`"abc"[1:99999999999999999999]`, "bc"},
+	{`"abc"[:-99999999999999999999]`, ""},
+	{"let a = [1]; a[99999999999999999999] = 2", "ERROR: 1:15: index out of range: 99999999999999999999"},
+
+	// Floats
+	{"3.5", "3.5"},
+	{"-1.25 * 2", "-2.5"},
+	{"1 + 0.5", "1.5"},
+	{"10 / 4.0", "2.5"},
+	{"2.0 == 2", "true"},
+	{"1.5 < 1", "false"},
+	{"1.5 + \"a\"", "ERROR: 1:5: type mismatch: FLOAT + STRING"},
+
+	// Logical operators
+	{"true && false", "false"},
+	{"1 && 2", "true"},
+	{"false || 0", "true"},
+	{"false || false", "false"},
+	{"false && missing", "false"},
+	{"true || missing", "true"},
+	{"true && missing", "ERROR: 1:9: identifier not found: missing"},
+	{"let f = fn(x) { x > 0 || x < -10 }; [f(1), f(0), f(-11)]", "[true, false, true]"},
+
+	// Loops
+	{"let i = 0; while (i < 3) { let i = i + 1; } i", "3"},
+	{"while (false) { 1 }", "null"},
+	{"let i = 0; while (true) { let i = i + 1; if (i == 4) { break; } } i", "4"},
+	{"let s = 0; for (x in [1, 2, 3, 4]) { if (x == 3) { continue } let s = s + x; } s", "7"},
+	{"let s = \"\"; for (c in \"abc\") { let s = c + s; } s", "cba"},
+	{"let f = fn(xs) { let n = 0; for (x in xs) { for (y in xs) { let n = n + 1; } } n }; f([1, 2, 3])", "9"},
+	{"let f = fn() { while (true) { return 1; } }; f()", "1"},
+	{"let f = fn(xs) { for (x in xs) { if (x > 1) { break } } x }; f([1, 2, 3])", "2"},
+	{"for (x in true) { 1 }", "ERROR: 1:11: cannot iterate over BOOLEAN"},
+
+	// Assignment
+	{"let x = 1; x = 2; x", "2"},
+	{"let x = 10; x += 5; x -= 1; x *= 2; x /= 7", "4"},
+	{"let x = 0; let y = 0; x = y = 3; x + y", "6"},
+	{"let x = 1; let f = fn() { x += 1 }; f(); f(); x", "3"},
+	{"let newCounter = fn() { let n = 0; fn() { n += 1 } }; let c = newCounter(); c(); c()", "2"},
+	{"let f = fn() { let n = 0; let inc = fn() { n += 1 }; inc(); inc(); n }; f()", "2"},
+	{"let f = fn() { let n = 0; let g = fn() { fn() { n = n + 10 } }; g()(); n }; f()", "10"},
+	{"let f = fn() { let a = fn() { b() }; let b = fn() { 1 }; a() }; f()", "1"},
+	{"let f = fn() { f = 5; 1 }; f(); f", "5"},
+	{"let f = fn() { let i = 0; let s = 0; while (i < 4) { i += 1; s += i; } s }; f()", "10"},
+	{"let a = [1, 2]; a[0] = 5; a[-1] *= 3; a", "[5, 6]"},
+	{`let h = {"n": 1}; h["n"] += 1; h["n"]`, "2"},
+	{`let h = {}; h["new"] = 1; h["new"]`, "1"},
+	{"let f = fn(xs) { xs[0] = 9 }; let a = [1]; f(a); a", "[9]"},
+	{"undeclared = 1", "ERROR: 1:1: identifier not found: undeclared"},
+	{"let f = fn() { nope += 1 }; f()", "ERROR: 1:16: identifier not found: nope\n    at f (1:16)\n    at <main> (1:29)"},
+	{"len = 1", "ERROR: 1:1: identifier not found: len"},
+	{"let x = 1; x += \"a\"", "ERROR: 1:14: type mismatch: INTEGER + STRING"},
+	{"let a = []; a[0] = 1", "ERROR: 1:14: index out of range: 0"},
+	{"let a = [1]; a[0] += true", "ERROR: 1:19: type mismatch: INTEGER + BOOLEAN"},
+
+	// Constants
+	{"const x = 5; x * 2", "10"},
+	{"const x = 5; let f = fn() { let x = 1; x += 1 }; f() + x", "7"},
+	{"if (true) { const x = 1 } x = 2", "ERROR: 1:27: cannot assign to constant x"},
+	{"if (true) { const x = 1 } let x = 2", "ERROR: 1:31: cannot redeclare constant x"},
+	{"let f = fn() { x = 2 }; const x = 1; f()", "ERROR: 1:16: cannot assign to constant x\n    at f (1:16)\n    at <main> (1:38)"},
+	{"let f = fn() { if (true) { const y = 1 } let g = fn() { y += 1 }; g() }; f()", "ERROR: 1:57: cannot assign to constant y\n    at g (1:57)\n    at f (1:67)\n    at <main> (1:74)"},
+	{"let f = fn() { if (true) { const y = 1 } for (y in [1]) {} }; f()", "ERROR: 1:47: cannot redeclare constant y\n    at f (1:47)\n    at <main> (1:63)"},
+	{"let i = 0; while (i < 2) { const x = i; i += 1; } [i, x]", "ERROR: 1:34: cannot redeclare constant x"},
+
+	// Strings
+	{`"Hello" + " " + "World!"`, "Hello World!"},
+	{`"a\tb\n"`, "a\tb\n"},
+	{`"say \"hi\" \\o/"`, `say "hi" \o/`},
+	{`"\u{48}\u{e9}\u{1F600}"`, "H\u00e9\U0001F600"},
+	{`len("\u{e9}")`, "1"},
+	{`[len("héllo 😀"), bytelen("héllo 😀")]`, "[7, 11]"},
+	{`chars("né😀")`, "[n, é, 😀]"},
+	{`bytes("né")`, "[110, 195, 169]"},
+	{`[ord("é"), ord("😀")]`, "[233, 128512]"},
+	{`chr(233) + chr(128512)`, "é😀"},
+	{`ord("ab")`, `ERROR: 1:1: argument 1 to ` + "`ord`" + ` must be a single char, got "ab"`},
+	{`chr(55296)`, "ERROR: 1:1: invalid code point 55296"},
+	{`bytes(1)`, "ERROR: 1:1: argument 1 to `bytes` must be STRING, got INTEGER"},
+	{`let größe = 3; let 名前 = "猿"; 名前 * größe`, "猿猿猿"},
+	{`["a" == "a", "a" != "a", "a" == "b", "a" < "b", "b" > "ab", "" < "a"]`, "[true, false, false, true, true, true]"},
+	{`"é" > "z"`, "true"},
+	{`"a" < 1`, "ERROR: 1:5: type mismatch: STRING < INTEGER"},
+	{`"a" - "b"`, "ERROR: 1:5: unknown operator STRING - STRING"},
+	{`"ab" * 3`, "ababab"},
+	{`2 * "ab" + "c" * 0`, "abab"},
+	{`let s = "-"; s *= 4; s`, "----"},
+	{`"ab" * -1`, "ERROR: 1:6: negative repeat count: -1"},
+	{`"ab" * 1.5`, "ERROR: 1:6: type mismatch: STRING * FLOAT"},
+	{`"ab" * 1000000000`, "ERROR: 1:6: repeated string too long: 1000000000 * 2 bytes"},
+	{`"monkey"[0]`, "m"},
+	{`"monkey"[-1]`, "y"},
+	{`"monkey"[6]`, "null"},
+	{`"héllo"[1] + "héllo"[4]`, "éo"},
+	{`"abc"["0"]`, "ERROR: 1:6: string index must be INTEGER, got STRING"},
+	{`let s = "abc"; s[0] = "x"`, "ERROR: 1:17: index assignment not supported: STRING"},
+	{`"monkey"[1:3]`, "on"},
+	{`"monkey"[3:]`, "key"},
+	{`"monkey"[:-3]`, "mon"},
+	{`"monkey"[:]`, "monkey"},
+	{`"monkey"[-100:100]`, "monkey"},
+	{`"monkey"[4:2]`, ""},
+	{`"héllo"[1:3]`, "él"},
+	{`"abc"[true:]`, "ERROR: 1:6: slice index must be INTEGER, got BOOLEAN"},
+	{"[1, 2, 3, 4][1:3]", "[2, 3]"},
+	{"let a = [1, 2, 3]; let b = a[:]; b[0] = 9; [a, b]", "[[1, 2, 3], [9, 2, 3]]"},
+	{"let a = [1, 2, 3]; a[1:][0]", "2"},
+	{"5[1:]", "ERROR: 1:2: slice operator not supported: INTEGER"},
+
+	// Conditionals
+	{"if (1) { 10 }", "10"},
+	{"if (1 > 2) { 10 }", "null"},
+	{"if (1 > 2) { 10 } else { 20 }", "20"},
+	{"if (if (false) { 10 }) { 10 } else { 20 }", "20"},
+
+	// Bindings and return
+	{"let a = 5; let b = a; let c = a + b + 5; c;", "15"},
+	{"let a = 5;", ""},
+	{"let a = 1; let a = a + 1; a", "2"},
+	{"9; return 2 * 5; 9;", "10"},
+	{"if (10 > 1) { if (10 > 1) { return 10; } return 1; }", "10"},
+
+	// Functions and closures
+	{"let identity = fn(x) { x; }; identity(5);", "5"},
+	{"fn(x) { x; }(5)", "5"},
+	{"fn(x) { x + 2; };", "fn(x) {\n(x + 2)\n}"},
+	{"let add = fn(a, b) { a + b }; add(5 + 5, add(5, 5));", "20"},
+	{"let newAdder = fn(x) { fn(y) { x + y } }; let addTwo = newAdder(2); addTwo(2);", "4"},
+	{"let f = fn(a) { let b = a * 2; fn(c) { a + b + c } }; f(1)(2)", "5"},
+	{"let countDown = fn(x) { if (x == 0) { return 0; } countDown(x - 1); }; countDown(10);", "0"},
+	{"let wrapper = fn() { let inner = fn(x) { if (x == 0) { 0 } else { inner(x - 1) } }; inner(3) }; wrapper();", "0"},
+	{"let g = 10; let f = fn() { g }; f()", "10"},
+	{"let x = 1; let f = fn() { let x = 2; x }; f() + x", "3"},
+
+	// Arrays and hashes
+	{"[1, 2 * 2, 3 + 3]", "[1, 4, 6]"},
+	{"[1, 2, 3][0]", "1"},
+	{"let i = 0; [1][i];", "1"},
+	{"[1, 2, 3][3]", "null"},
+	{"[1, 2, 3][-1]", "3"},
+	{"[1, 2, 3][-4]", "null"},
+	{`{"one": 1}["one"]`, "1"},
+	{`{"one": 1}["two"]`, "null"},
+	{`let key = "k"; {key: 5}["k"]`, "5"},
+	{`{true: 5}[true]`, "5"},
+	{`{1: 5}[1]`, "5"},
+	{`{-1: "a"}[-1]`, "a"},
+	{`{1: "a"}[1.0]`, "a"},
+	{`{1.0: "a"}[1]`, "a"},
+	{`{1: "a", 1.0: "b", 1.5: "c"}`, "{1: b, 1.5: c}"},
+	{`{-0.0: "a"}[0]`, "a"},
+	{`{0.5: "a"}[0]`, "null"},
+	{`{100000000000000000000: "a"}[1e20]`, "a"},
+	{`{"a" + "b": 1}`, "{ab: 1}"},
+	{`let f = fn() { 2 }; {f(): 1}`, "{2: 1}"},
+	{`{"b": 1, "a": 2, 3: 3, true: 4}`, "{b: 1, a: 2, 3: 3, true: 4}"},
+	{`{"a": 1, "b": 2, "a": 3}`, "{a: 3, b: 2}"},
+	{`let h = {"z": 1}; h["y"] = 2; h["z"] = 3; h["x"] = 4; h`, "{z: 3, y: 2, x: 4}"},
+	{`let s = ""; for (k in {"c": 1, "a": 2, "b": 3}) { s = s + k } s`, "cab"},
+	{`let h = {"c": 1, "a": 2, "b": 3}; [keys(h), values(h)]`, "[[c, a, b], [1, 2, 3]]"},
+	{`let h = {"c": 1, "a": 2, "b": 3}; let d = delete(h, "a"); d["a"] = 4; [d, h]`, "[{c: 1, b: 3, a: 4}, {c: 1, a: 2, b: 3}]"},
+	{`merge({"b": 1, "a": 2}, {"c": 3, "b": 4})`, "{b: 4, a: 2, c: 3}"},
+	{`try { throw "x" } catch (e) { keys(e) }`, "[message, kind, position, value, trace]"},
+	{`[{"a": 1} == {"a": 1}, {"a": 1} != {"a": 1}, {"a": 1} == {"a": 2}, {"a": 1} == {"b": 1}]`, "[true, false, false, false]"},
+	{`[{"a": 1, "b": 2} == {"b": 2, "a": 1}, {1: 1} == {1.0: 1}, {} == {}]`, "[false, false, true]"},
+	{`let h = {"a": [1, {"b": 2}]}; let g = delete(merge(h, {"c": 3}), "c"); [h == g, h == {"a": [1, {"b": 3}]}]`, "[true, false]"},
+	{`[[1, 2] == [1, 2], [1, 2] != [1, 2], [1, 2] == [2, 1], [1] == [1, 2], [1, "a"] == [1.0, "a"]]`, "[true, false, false, false, true]"},
+	{`let a = [1]; let b = [1]; a[0] = a; b[0] = b; [a == b, a == a, a == [1]]`, "[true, true, false]"},
+	{`let a = [1, 2]; a[0] = a; a`, "[[...], 2]"},
+	{`let h = {}; h["x"] = h; h`, "{x: {...}}"},
+	{`let a = [1]; let h = {"a": a}; a[0] = h; [a, h]`, "[[{a: [...]}], {a: [{...}]}]"},
+	{`let a = [1]; [a, a, {"b": a}]`, "[[1], [1], {b: [1]}]"},
+
+	// Builtins
+	{`len("")`, "0"},
+	{`len("four")`, "4"},
+	{`let l = len; l("abc")`, "3"},
+	{`[len([1, 2]), len({"a": 1}), len([])]`, "[2, 1, 0]"},
+	{"let a = [1, 2]; [push(a, 3), a]", "[[1, 2, 3], [1, 2]]"},
+	{"[first([1, 2]), last([1, 2]), rest([1, 2, 3])]", "[1, 2, [2, 3]]"},
+	{"[first([]), last([]), rest([])]", "[null, null, null]"},
+	{`[slice([1, 2, 3, 4], 1, 3), slice([1, 2, 3], -2), slice("héllo", 1, 3)]`, "[[2, 3], [2, 3], él]"},
+	{"concat([1], [], [2, 3])", "[1, 2, 3]"},
+	{`[reverse([1, 2, 3]), reverse("né😀")]`, "[[3, 2, 1], 😀én]"},
+	{`[sort([3, 1, 2]), sort(["b", "a"]), sort([2.5, 1, -3])]`, "[[1, 2, 3], [a, b], [-3, 1, 2.5]]"},
+	{"sort([3, 1, 2], fn(a, b) { a > b })", "[3, 2, 1]"},
+	{`sort([[2, "b"], [1, "a"], [2, "a"]], fn(x, y) { x[0] < y[0] })`, "[[1, a], [2, b], [2, a]]"},
+	{`sort([1, "a"])`, "ERROR: 1:1: type mismatch: STRING < INTEGER"},
+	{`let h = {"a": 1, "b": 2}; [sort(keys(h)), sort(values(h))]`, "[[a, b], [1, 2]]"},
+	{`let h = {"a": 1}; [has(h, "a"), has(h, "b")]`, "[true, false]"},
+	{`let h = {"a": 1, "b": 2}; let d = delete(h, "a"); [len(d), d["b"], len(h)]`, "[1, 2, 2]"},
+	{`let m = merge({"a": 1, "b": 2}, {"b": 3}); [m["a"], m["b"], len(m)]`, "[1, 3, 2]"},
+	{"map([1, 2, 3], fn(x) { x * 2 })", "[2, 4, 6]"},
+	{`map(["a", "bc"], len)`, "[1, 2]"},
+	{"filter(range(10), fn(x) { x % 3 == 0 })", "[0, 3, 6, 9]"},
+	{"reduce([1, 2, 3, 4], 0, fn(acc, x) { acc + x })", "10"},
+	{"reduce([], 5, fn(acc, x) { acc + x })", "5"},
+	{"map([[1, 2], [3]], fn(a) { map(a, fn(x) { x + 1 }) })", "[[2, 3], [4]]"},
+	{"let total = 0; map([1, 2], fn(x) { total += x }); total", "3"},
+	{"[range(3), range(2, 5), range(5, 0, -2), range(3, 1)]", "[[0, 1, 2], [2, 3, 4], [5, 3, 1], []]"},
+	{"range(0, 10, 0)", "ERROR: 1:1: range step must not be zero"},
+	{"range(100000000)", "ERROR: 1:1: range too long: 100000000 elements"},
+	{"push(1, 2)", "ERROR: 1:1: argument 1 to `push` must be ARRAY, got INTEGER"},
+	{`keys([1])`, "ERROR: 1:1: argument 1 to `keys` must be HASH, got ARRAY"},
+	{"map([1], 2)", "ERROR: 1:1: argument 2 to `map` must be FUNCTION, got INTEGER"},
+	{`slice([1], "a")`, "ERROR: 1:1: argument 2 to `slice` must be INTEGER, got STRING"},
+	{"concat()", "ERROR: 1:1: wrong number of arguments. got=0, want at least 1"},
+	{"sort()", "ERROR: 1:1: wrong number of arguments. got=0, want=1 or 2"},
+	{"range()", "ERROR: 1:1: wrong number of arguments. got=0, want=1 to 3"},
+	{`has({}, [1])`, "ERROR: 1:1: unusable as hash key: ARRAY"},
+	{"map([1], fn(x, y) { x })", "ERROR: 1:1: wrong number of arguments: want=2, got=1"},
+	{"map([1, 2], fn(x) { x + true })",
+		"ERROR: 1:23: type mismatch: INTEGER + BOOLEAN\n    at <anonymous> (1:23)\n    at <main> (1:1)"},
+	{"let f = fn(x) { throw x }; try { map([1, 2], f) } catch (e) { e[\"value\"] }", "1"},
+	{"map([1, 2], fn(x) { try { throw x } catch (e) { e[\"value\"] * 10 } })", "[10, 20]"},
+
+	// Runtime errors
+	{"5 + true;", "ERROR: 1:3: type mismatch: INTEGER + BOOLEAN"},
+	{"5 + true; 5;", "ERROR: 1:3: type mismatch: INTEGER + BOOLEAN"},
+	{"-true", "ERROR: 1:1: unknown operator: -BOOLEAN"},
+	{"true + false;", "ERROR: 1:6: unknown operator: BOOLEAN + BOOLEAN"},
+	{`"Hello" - "World"`, "ERROR: 1:9: unknown operator STRING - STRING"},
+	{"if (10 > 1) { true + false; }", "ERROR: 1:20: unknown operator: BOOLEAN + BOOLEAN"},
+	{"foobar", "ERROR: 1:1: identifier not found: foobar"},
+	{"let f = fn() {\n  missing\n}; f()", "ERROR: 2:3: identifier not found: missing\n    at f (2:3)\n    at <main> (3:4)"},
+	{`{"name": "Monkey"}[fn(x) { x }];`, "ERROR: 1:19: unusable as hash key: FUNCTION"},
+	{`[1][true]`, "ERROR: 1:4: array index must be INTEGER, got BOOLEAN"},
+	{`1[0]`, "ERROR: 1:2: index operator not supported: INTEGER"},
+	{`len(1)`, "ERROR: 1:1: argument 1 to `len` must be STRING, ARRAY or HASH, got INTEGER"},
+	{`len("one", "two")`, "ERROR: 1:1: wrong number of arguments. got=2, want=1"},
+	{"fn(a) { a }()", "ERROR: 1:1: wrong number of arguments: want=1, got=0"},
+	{"let one = 1; one(2)", "ERROR: 1:14: not a function: INTEGER"},
+
+	// Tracebacks
+	{"fn() { 1 + true }()", "ERROR: 1:10: type mismatch: INTEGER + BOOLEAN\n    at <anonymous> (1:10)\n    at <main> (1:1)"},
+	{"let g = fn(x) { len(x) };\nlet f = fn() { g(1) };\nf()",
+		"ERROR: 1:17: argument 1 to `len` must be STRING, ARRAY or HASH, got INTEGER\n    at g (1:17)\n    at f (2:16)\n    at <main> (3:1)"},
+	{"let f = fn(x) { x }; let g = fn() { f() }; g()",
+		"ERROR: 1:37: wrong number of arguments: want=1, got=0\n    at g (1:37)\n    at <main> (1:44)"},
+	{"let down = fn(n) { if (n == 0) { n + true } else { down(n - 1) } }; down(3)",
+		"ERROR: 1:36: type mismatch: INTEGER + BOOLEAN\n    at down (1:36)\n    at down (1:52)\n    ... repeated 2 more times\n    at <main> (1:69)"},
+
+	// Exceptions
+	{"try { 1 } catch (e) { 2 }", "1"},
+	{"try { 1 + true } catch (e) { e[\"message\"] }", "type mismatch: INTEGER + BOOLEAN"},
+	{"try { 1 + true } catch (e) { e[\"kind\"] + \" at \" + e[\"position\"] }", "RuntimeError at 1:9"},
+	{"try { 1 + true } catch { 2 }", "2"},
+	{`try { throw "boom" } catch (e) { e["message"] }`, "boom"},
+	{`try { throw 42 } catch (e) { [e["kind"], e["message"], e["value"]] }`, "[Error, 42, 42]"},
+	{`try { throw {"message": "bad input", "kind": "ValueError"} } catch (e) { e["kind"] + ": " + e["message"] }`, "ValueError: bad input"},
+	{`throw "boom"`, "ERROR: 1:1: boom"},
+	{`let f = fn() { throw "deep" }; f()`, "ERROR: 1:16: deep\n    at f (1:16)\n    at <main> (1:32)"},
+	{`let f = fn() { throw "deep" }; try { f() } catch (e) { e["trace"][0]["position"] }`, "1:16"},
+	{`let f = fn() { throw "deep" }; try { f() } catch (e) { e["trace"][0]["function"] }`, "f"},
+	{`try { try { throw "inner" } catch (e) { throw e } } catch (e) { e["message"] + " " + e["position"] }`, "inner 1:41"},
+	{`try { try { throw "inner" } finally { 1 } } catch (e) { e["message"] }`, "inner"},
+	{"let x = 0; try { x = 1 } finally { x = x + 10 }; x", "11"},
+	{"let x = 0; try { 1 + true } catch { x = 1 } finally { x = x + 10 }; x", "11"},
+	{"try { 5 } finally { 6 }", "5"},
+	{"let x = 0; let f = fn() { try { return 1 } finally { x = 2 } }; f() + x", "3"},
+	{"let f = fn() { try { return 1 } finally { return 2 } }; f()", "2"},
+	{`let f = fn() { try { throw "lost" } finally { return 2 } }; f()`, "2"},
+	{"let n = 0; let i = 0; while (i < 5) { i = i + 1; try { if (i == 3) { break } } finally { n = n + 1 } } n", "3"},
+	{"let n = 0; let i = 0; while (i < 5) { i = i + 1; try { continue } finally { n = n + 1 } } n", "5"},
+	{`let safe = fn(f) { try { f() } catch (e) { "failed: " + e["message"] } }; safe(fn() { throw "x" }) + " " + safe(fn() { "ok" })`, "failed: x ok"},
+	{`let check = fn(n) { if (n > 2) { throw "too big" } n }; let total = 0; let i = 0; while (i < 5) { i = i + 1; try { total = total + check(i) } catch {} } total`, "3"},
+	{`try { throw "a" } catch (e) { throw "b" } finally { 1 }`, "ERROR: 1:31: b"},
+	{`let e = 1; try { throw "a" } catch (e) { 2 }; e`, "1"},
+	{`try { throw "a" } catch (e) { 2 }; e`, "ERROR: 1:36: identifier not found: e"},
+	{`let f = fn() { let e = 1; try { throw "a" } catch (e) { e = 2; let x = 3 }; [e, x] }; f()`, "[1, 3]"},
+	{`const e = 1; try { throw "a" } catch (e) { e["message"] }`, "a"},
+	{`let fs = []; let i = 0; while (i < 2) { try { throw i } catch (e) { fs = push(fs, fn() { e["value"] }) } i += 1 } [fs[0](), fs[1]()]`, "[0, 1]"},
+	{`let f = fn() { let fs = []; for (i in [0, 1]) { try { throw i } catch (e) { fs = push(fs, fn() { e["value"] }) } } fs }; let fs = f(); [fs[0](), fs[1]()]`, "[0, 1]"},
+}
+
+var overflowTests = []struct {
+	overflow object.Overflow
+	input    string
+	expected string
+}{
+	{object.OverflowWrap, "9223372036854775807 * 2", "-2"},
+	{object.OverflowWrap, "-(-9223372036854775807 - 1)", "-9223372036854775808"},
+	{object.OverflowChecked, "9223372036854775807 + 1", "ERROR: 1:21: integer overflow: 9223372036854775807 + 1"},
+	{object.OverflowChecked, "-9223372036854775807 - 2", "ERROR: 1:22: integer overflow: -9223372036854775807 - 2"},
+	{object.OverflowChecked, "let f = fn(x) { x * x }; f(4294967296)", "ERROR: 1:19: integer overflow: 4294967296 * 4294967296\n    at f (1:19)\n    at <main> (1:26)"},
+	{object.OverflowChecked, "(-9223372036854775807 - 1) / -1", "ERROR: 1:28: integer overflow: -9223372036854775808 / -1"},
+	{object.OverflowChecked, "-(-9223372036854775807 - 1)", "ERROR: 1:1: integer overflow: -(-9223372036854775808)"},
+	{object.OverflowChecked, "4611686018427387904 + 4611686018427387903", "9223372036854775807"},
+	{object.OverflowPromote, "9223372036854775807 + 1", "9223372036854775808"},
+	{object.OverflowPromote, "let x = 4294967296; x * x * x", "79228162514264337593543950336"},
+	{object.OverflowPromote, "let x = 9223372036854775807 + 1; x - 1", "9223372036854775807"},
+	{object.OverflowPromote, "let x = 9223372036854775807 * 10; [x / 10, x % 7, x > 1, x == x + 0, -x]",
+		"[9223372036854775807, 0, true, true, -92233720368547758070]"},
+	{object.OverflowPromote, "-(-9223372036854775807 - 1)", "9223372036854775808"},
+	{object.OverflowPromote, "(9223372036854775807 + 1) / 0", "ERROR: 1:27: division by zero"},
+	{object.OverflowPromote, "(9223372036854775807 + 1) + 1.5", "9.223372036854776e+18"},
+	{object.OverflowChecked, "18446744073709551616 * 2", "36893488147419103232"},
+	{object.OverflowChecked, "18446744073709551616 - 18446744073709551615 + 9223372036854775807", "ERROR: 1:45: integer overflow: 1 + 9223372036854775807"},
+}
+
+func TestOverflow(t *testing.T) {
+	for _, tt := range overflowTests {
+		env := object.NewEnvironment()
+		env.SetOverflow(tt.overflow)
+		if got := inspect(evaluator.Eval(parse(t, tt.input), env)); got != tt.expected {
+			t.Errorf("eval, %s: %q\nwant=%q\ngot =%q", tt.overflow, tt.input, tt.expected, got)
+		}
+
+		comp := compiler.New()
+		if err := comp.Compile(parse(t, tt.input)); err != nil {
+			t.Fatalf("%q: compiler error: %s", tt.input, err)
+		}
+		machine := vm.New(comp.Bytecode())
+		machine.Overflow = tt.overflow
+		if got := runMachine(t, tt.input, machine); got != tt.expected {
+			t.Errorf("vm, %s: %q\nwant=%q\ngot =%q", tt.overflow, tt.input, tt.expected, got)
+		}
+	}
+}
+
+var ioTests = []struct {
+	input          string
+	stdin          string
+	expectedOutput string
+	expected       string
+}{
+	{`puts("a", 1, [true]); puts()`, "", "a\n1\n[true]\n\n", "null"},
+	{`print("x", 2); print(); print("!")`, "", "x 2!", "null"},
+	{`printf("%s=%d|%5.2f|%-3s|%x|%q|%t|%c|%%\n", "n", 42, 3.14159, "ab", 255, "hi", true, 233)`, "",
+		"n=42| 3.14|ab |ff|\"hi\"|true|é|%\n", "null"},
+	{`format("%v and %v", [1, 2], {"k": "v"})`, "", "", "[1, 2] and {k: v}"},
+	{`let a = [1]; a[0] = a; puts(a); format("%v", a)`, "", "[[...]]\n", "[[...]]"},
+	{`format("%d", 99999999999999999999)`, "", "", "99999999999999999999"},
+	{`format("%f", 1)`, "", "", "1.000000"},
+	{`format("%d", "x")`, "", "", "ERROR: 1:1: argument 2 to `format` must be INTEGER, got STRING"},
+	{`format("%d %d", 1)`, "", "", "ERROR: 1:1: missing argument for %d in the format of `format`"},
+	{`format("%d", 1, 2)`, "", "", "ERROR: 1:1: too many arguments to `format`: the format uses 1"},
+	{`format("%z", 1)`, "", "", "ERROR: 1:1: unknown verb %z in the format of `format`"},
+	{`format("%5", 1)`, "", "", "ERROR: 1:1: incomplete verb at the end of the format of `format`"},
+	{`printf(1)`, "", "", "ERROR: 1:1: argument 1 to `printf` must be STRING, got INTEGER"},
+	{`[input(), input("? "), input(), input()]`, "one\r\ntwo\nthree", "? ", "[one, two, three, null]"},
+	{`let f = fn(x) { puts(x) }; map([1, 2], f); input()`, "", "1\n2\n", "null"},
+	{`input(1)`, "", "", "ERROR: 1:1: argument 1 to `input` must be STRING, got INTEGER"},
+}
+
+func TestIO(t *testing.T) {
+	for _, tt := range ioTests {
+		var out bytes.Buffer
+		env := object.NewEnvironment()
+		env.SetIO(object.NewIO(strings.NewReader(tt.stdin), &out))
+		got := inspect(evaluator.Eval(parse(t, tt.input), env))
+		if got != tt.expected || out.String() != tt.expectedOutput {
+			t.Errorf("eval: %q\nwant=%q, output %q\ngot =%q, output %q",
+				tt.input, tt.expected, tt.expectedOutput, got, out.String())
+		}
+
+		out.Reset()
+		comp := compiler.New()
+		if err := comp.Compile(parse(t, tt.input)); err != nil {
+			t.Fatalf("%q: compiler error: %s", tt.input, err)
+		}
+		machine := vm.New(comp.Bytecode())
+		machine.IO = object.NewIO(strings.NewReader(tt.stdin), &out)
+		got = runMachine(t, tt.input, machine)
+		if got != tt.expected || out.String() != tt.expectedOutput {
+			t.Errorf("vm: %q\nwant=%q, output %q\ngot =%q, output %q",
+				tt.input, tt.expected, tt.expectedOutput, got, out.String())
+		}
+	}
+}
+
+func TestConformance(t *testing.T) {
+	for name, run := range backends {
+		for _, tt := range tests {
+			if got := run(t, tt.input); got != tt.expected {
+				t.Errorf("%s: %q\nwant=%q\ngot =%q", name, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+// TestLargePrograms runs programs with more variables, elements and code
+// than a byte or two of an operand can address.
+func TestLargePrograms(t *testing.T) {
+	var locals, statements strings.Builder
+	params := make([]string, 300)
+	args := make([]string, 300)
+	for i := 0; i < 300; i++ {
+		fmt.Fprintf(&locals, "let v%d = %d; ", i, i)
+		params[i] = fmt.Sprintf("a%d", i)
+		args[i] = fmt.Sprint(i)
+	}
+	for i := 0; i < 12000; i++ {
+		statements.WriteString("1; ")
+	}
+	elements := make([]string, 70000)
+	pairs := make([]string, 1000)
+	for i := range elements {
+		elements[i] = fmt.Sprint(i)
+	}
+	for i := range pairs {
+		pairs[i] = fmt.Sprintf("%d: %d", i%600, i)
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"let f = fn() { " + locals.String() + "[v299, v0, v256] }; f()", "[299, 0, 256]"},
+		{"fn(" + strings.Join(params, ", ") + ") { [a0, a256, a299] }(" + strings.Join(args, ", ") + ")", "[0, 256, 299]"},
+		{"let a = [" + strings.Join(elements, ", ") + "]; [len(a), a[0], a[256], a[69999]]", "[70000, 0, 256, 69999]"},
+		{"let h = {" + strings.Join(pairs, ", ") + "}; [len(h), h[0], h[599], first(keys(h))]", "[600, 600, 599, 0]"},
+		{"if (false) { " + statements.String() + "2 } else { 3 }", "3"},
+		{"let n = 0; while (n < 2) { " + statements.String() + "n += 1 } n", "2"},
+	}
+	for name, run := range backends {
+		for _, tt := range tests {
+			if got := run(t, tt.input); got != tt.expected {
+				t.Errorf("%s: %.60q...\nwant=%q\ngot =%q", name, tt.input, tt.expected, got)
+			}
+		}
+	}
+}
+
+func runEval(t *testing.T, input string) string {
+	t.Helper()
+	return inspect(evaluator.Eval(parse(t, input), object.NewEnvironment()))
+}
+
+func runVM(t *testing.T, input string) string {
+	t.Helper()
+	comp := compiler.New()
+	if err := comp.Compile(parse(t, input)); err != nil {
+		t.Fatalf("%q: compiler error: %s", input, err)
+	}
+	return runMachine(t, input, vm.New(comp.Bytecode()))
+}
+
+// runMachine runs the program compiled from input on machine.
+func runMachine(t *testing.T, input string, machine *vm.VM) string {
+	t.Helper()
+	if err := machine.Run(); err != nil {
+		rtErr, ok := err.(*object.Error)
+		if !ok {
+			t.Fatalf("%q: vm failed: %s", input, err)
+		}
+		return rtErr.Inspect()
+	}
+	return inspect(machine.LastPoppedStackElem())
+}
+
+func inspect(result object.Object) string {
+	if result == nil {
+		return ""
+	}
+	return result.Inspect()
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%q: parser errors: %v", input, p.Errors())
+	}
+	return program
+}
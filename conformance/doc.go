@@ -0,0 +1,5 @@
+// Package conformance holds the tests that every backend of the language
+// must pass. Each program runs on the tree-walking evaluator and on the
+// bytecode VM, and both must produce the same result or the same error,
+// at the same position.
+package conformance
@@ -0,0 +1,575 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"sort"
+	"unicode/utf8"
+)
+
+// maxRangeLength bounds the number of elements range creates, which could
+// otherwise exhaust memory in a single step.
+const maxRangeLength = 1 << 24
+
+// The builtins never modify the arrays and hashes passed to them; those
+// that change a collection return a new one.
+var builtins = map[string]*object.Builtin{
+	"len":     {Fn: builtinLen},
+	"bytelen": {Fn: builtinByteLen},
+	"bytes":   {Fn: builtinBytes},
+	"chars":   {Fn: builtinChars},
+	"chr":     {Fn: builtinChr},
+	"ord":     {Fn: builtinOrd},
+	"push":    {Fn: builtinPush},
+	"first":   {Fn: builtinFirst},
+	"last":    {Fn: builtinLast},
+	"rest":    {Fn: builtinRest},
+	"slice":   {Fn: builtinSlice},
+	"concat":  {Fn: builtinConcat},
+	"reverse": {Fn: builtinReverse},
+	"sort":    {Fn: builtinSort},
+	"keys":    {Fn: builtinKeys},
+	"values":  {Fn: builtinValues},
+	"has":     {Fn: builtinHas},
+	"delete":  {Fn: builtinDelete},
+	"merge":   {Fn: builtinMerge},
+	"map":     {Fn: builtinMap},
+	"filter":  {Fn: builtinFilter},
+	"reduce":  {Fn: builtinReduce},
+	"range":   {Fn: builtinRange},
+	"puts":    {Fn: builtinPuts},
+	"print":   {Fn: builtinPrint},
+	"printf":  {Fn: builtinPrintf},
+	"format":  {Fn: builtinFormat},
+	"input":   {Fn: builtinInput},
+}
+
+// checkArguments returns an error unless a builtin got from min to max
+// arguments. A negative max leaves the number unbounded.
+func checkArguments(args []object.Object, min, max int) *object.Error {
+	got := len(args)
+	switch {
+	case got >= min && (max < 0 || got <= max):
+		return nil
+	case max < 0:
+		return newError("wrong number of arguments. got=%d, want at least %d", got, min)
+	case min == max:
+		return newError("wrong number of arguments. got=%d, want=%d", got, min)
+	case max == min+1:
+		return newError("wrong number of arguments. got=%d, want=%d or %d", got, min, max)
+	default:
+		return newError("wrong number of arguments. got=%d, want=%d to %d", got, min, max)
+	}
+}
+
+// argumentError reports that argument i, counting from 0, of a call to the
+// builtin name does not have the type want.
+func argumentError(name string, i int, want string, got object.Object) *object.Error {
+	return newError("argument %d to `%s` must be %s, got %s", i+1, name, want, got.Type())
+}
+
+func stringArgument(name string, args []object.Object, i int) (string, *object.Error) {
+	str, ok := args[i].(*object.String)
+	if !ok {
+		return "", argumentError(name, i, object.StringObj, args[i])
+	}
+	return str.Value, nil
+}
+
+func integerArgument(name string, args []object.Object, i int) (int64, *object.Error) {
+	integer, ok := args[i].(*object.Integer)
+	if !ok {
+		return 0, argumentError(name, i, object.IntegerObj, args[i])
+	}
+	return integer.Value, nil
+}
+
+func arrayArgument(name string, args []object.Object, i int) ([]object.Object, *object.Error) {
+	array, ok := args[i].(*object.Array)
+	if !ok {
+		return nil, argumentError(name, i, object.ArrayObj, args[i])
+	}
+	return array.Elements, nil
+}
+
+func hashArgument(name string, args []object.Object, i int) (*object.Hash, *object.Error) {
+	hash, ok := args[i].(*object.Hash)
+	if !ok {
+		return nil, argumentError(name, i, object.HashObj, args[i])
+	}
+	return hash, nil
+}
+
+// functionArgument checks that argument i can be called: a function of
+// either backend or a builtin.
+func functionArgument(name string, args []object.Object, i int) (object.Object, *object.Error) {
+	switch args[i].(type) {
+	case *object.Function, *object.Closure, *object.Builtin:
+		return args[i], nil
+	default:
+		return nil, argumentError(name, i, object.FunctionObj, args[i])
+	}
+}
+
+// hashKey returns obj as a key of a hash.
+func hashKey(obj object.Object) (object.Hashable, *object.Error) {
+	key, ok := obj.(object.Hashable)
+	if !ok {
+		return nil, newError("unusable as hash key: %s", obj.Type())
+	}
+	return key, nil
+}
+
+// reserve charges an array of length elements, or a string of length
+// bytes, to the allocation limit of the program before a builtin creates
+// it. See object.Runtime.Allocate.
+func reserve(rt object.Runtime, length int) *object.Error {
+	return rt.Allocate(1 + int64(length))
+}
+
+func copyHash(hash *object.Hash) *object.Hash {
+	copied := object.NewHash(hash.Len())
+	for _, pair := range hash.Pairs() {
+		copied.Set(pair.Key.(object.Hashable), pair.Value)
+	}
+	return copied
+}
+
+// builtinLen returns the number of chars of a string, elements of an array
+// or pairs of a hash.
+func builtinLen(_ object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	switch arg := args[0].(type) {
+	case *object.String:
+		return &object.Integer{Value: int64(utf8.RuneCountInString(arg.Value))}
+	case *object.Array:
+		return &object.Integer{Value: int64(len(arg.Elements))}
+	case *object.Hash:
+		return &object.Integer{Value: int64(arg.Len())}
+	default:
+		return argumentError("len", 0, "STRING, ARRAY or HASH", arg)
+	}
+}
+
+// builtinPush returns a copy of an array with a value appended.
+func builtinPush(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 2, 2); err != nil {
+		return err
+	}
+	elements, err := arrayArgument("push", args, 0)
+	if err != nil {
+		return err
+	}
+	if err := reserve(rt, len(elements)+1); err != nil {
+		return err
+	}
+	pushed := make([]object.Object, len(elements), len(elements)+1)
+	copy(pushed, elements)
+	return &object.Array{Elements: append(pushed, args[1])}
+}
+
+// builtinFirst returns the first element of an array, or null if it is
+// empty.
+func builtinFirst(_ object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	elements, err := arrayArgument("first", args, 0)
+	if err != nil {
+		return err
+	}
+	if len(elements) == 0 {
+		return Null
+	}
+	return elements[0]
+}
+
+// builtinLast returns the last element of an array, or null if it is
+// empty.
+func builtinLast(_ object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	elements, err := arrayArgument("last", args, 0)
+	if err != nil {
+		return err
+	}
+	if len(elements) == 0 {
+		return Null
+	}
+	return elements[len(elements)-1]
+}
+
+// builtinRest returns an array without its first element, or null if it is
+// empty.
+func builtinRest(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	elements, err := arrayArgument("rest", args, 0)
+	if err != nil {
+		return err
+	}
+	if len(elements) == 0 {
+		return Null
+	}
+	if err := reserve(rt, len(elements)-1); err != nil {
+		return err
+	}
+	rest := make([]object.Object, len(elements)-1)
+	copy(rest, elements[1:])
+	return &object.Array{Elements: rest}
+}
+
+// builtinSlice is slice(x, low, high), which evaluates x[low:high], and
+// slice(x, low), which evaluates x[low:].
+func builtinSlice(_ object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 2, 3); err != nil {
+		return err
+	}
+	switch args[0].(type) {
+	case *object.Array, *object.String:
+	default:
+		return argumentError("slice", 0, "ARRAY or STRING", args[0])
+	}
+	high := object.Object(Null)
+	if len(args) == 3 {
+		high = args[2]
+	}
+	for i, bound := range []object.Object{args[1], high} {
+		if _, ok := bound.(*object.Integer); !ok && bound != Null {
+			return argumentError("slice", i+1, object.IntegerObj, bound)
+		}
+	}
+	return evalSliceExpression(args[0], args[1], high)
+}
+
+// builtinConcat returns the elements of all its array arguments in one
+// array.
+func builtinConcat(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, -1); err != nil {
+		return err
+	}
+	length := 0
+	for i := range args {
+		elements, err := arrayArgument("concat", args, i)
+		if err != nil {
+			return err
+		}
+		length += len(elements)
+	}
+	if err := reserve(rt, length); err != nil {
+		return err
+	}
+	concatenated := make([]object.Object, 0, length)
+	for _, arg := range args {
+		concatenated = append(concatenated, arg.(*object.Array).Elements...)
+	}
+	return &object.Array{Elements: concatenated}
+}
+
+// builtinReverse returns the elements of an array, or the chars of a
+// string, in reverse order.
+func builtinReverse(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	switch arg := args[0].(type) {
+	case *object.Array:
+		n := len(arg.Elements)
+		if err := reserve(rt, n); err != nil {
+			return err
+		}
+		reversed := make([]object.Object, n)
+		for i, element := range arg.Elements {
+			reversed[n-1-i] = element
+		}
+		return &object.Array{Elements: reversed}
+	case *object.String:
+		if err := reserve(rt, len(arg.Value)); err != nil {
+			return err
+		}
+		runes := []rune(arg.Value)
+		for i, j := 0, len(runes)-1; i < j; i, j = i+1, j-1 {
+			runes[i], runes[j] = runes[j], runes[i]
+		}
+		return &object.String{Value: string(runes)}
+	default:
+		return argumentError("reverse", 0, "ARRAY or STRING", arg)
+	}
+}
+
+// builtinSort returns the elements of an array in ascending order as
+// compared by <. sort(array, less) orders them by the function less
+// instead, which reports whether its first argument goes before its
+// second. The sort is stable.
+func builtinSort(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 2); err != nil {
+		return err
+	}
+	elements, err := arrayArgument("sort", args, 0)
+	if err != nil {
+		return err
+	}
+	var less object.Object
+	if len(args) == 2 {
+		if less, err = functionArgument("sort", args, 1); err != nil {
+			return err
+		}
+	}
+
+	if err := reserve(rt, len(elements)); err != nil {
+		return err
+	}
+	sorted := make([]object.Object, len(elements))
+	copy(sorted, elements)
+	var failure object.Object
+	sort.SliceStable(sorted, func(i, j int) bool {
+		if failure != nil {
+			return false
+		}
+		var result object.Object
+		if less == nil {
+			result = evalInfixExpression("<", sorted[i], sorted[j], object.OverflowWrap)
+		} else {
+			result = rt.Call(less, []object.Object{sorted[i], sorted[j]})
+		}
+		if isError(result) {
+			failure = result
+			return false
+		}
+		return isTruthy(result)
+	})
+	if failure != nil {
+		return failure
+	}
+	return &object.Array{Elements: sorted}
+}
+
+// builtinKeys returns the keys of a hash as an array.
+func builtinKeys(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	hash, err := hashArgument("keys", args, 0)
+	if err != nil {
+		return err
+	}
+	if err := reserve(rt, hash.Len()); err != nil {
+		return err
+	}
+	keys := make([]object.Object, 0, hash.Len())
+	for _, pair := range hash.Pairs() {
+		keys = append(keys, pair.Key)
+	}
+	return &object.Array{Elements: keys}
+}
+
+// builtinValues returns the values of a hash as an array.
+func builtinValues(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	hash, err := hashArgument("values", args, 0)
+	if err != nil {
+		return err
+	}
+	if err := reserve(rt, hash.Len()); err != nil {
+		return err
+	}
+	values := make([]object.Object, 0, hash.Len())
+	for _, pair := range hash.Pairs() {
+		values = append(values, pair.Value)
+	}
+	return &object.Array{Elements: values}
+}
+
+// builtinHas reports whether a hash has a key.
+func builtinHas(_ object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 2, 2); err != nil {
+		return err
+	}
+	hash, err := hashArgument("has", args, 0)
+	if err != nil {
+		return err
+	}
+	key, err := hashKey(args[1])
+	if err != nil {
+		return err
+	}
+	_, ok := hash.Get(key)
+	return nativeBoolToBooleanObject(ok)
+}
+
+// builtinDelete returns a copy of a hash without a key.
+func builtinDelete(_ object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 2, 2); err != nil {
+		return err
+	}
+	hash, err := hashArgument("delete", args, 0)
+	if err != nil {
+		return err
+	}
+	key, err := hashKey(args[1])
+	if err != nil {
+		return err
+	}
+	deleted := copyHash(hash)
+	deleted.Delete(key)
+	return deleted
+}
+
+// builtinMerge returns the pairs of all its hash arguments in one hash. A
+// key in several of them takes the value of the last one.
+func builtinMerge(_ object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, -1); err != nil {
+		return err
+	}
+	merged := &object.Hash{}
+	for i := range args {
+		hash, err := hashArgument("merge", args, i)
+		if err != nil {
+			return err
+		}
+		for _, pair := range hash.Pairs() {
+			merged.Set(pair.Key.(object.Hashable), pair.Value)
+		}
+	}
+	return merged
+}
+
+// builtinMap returns the results of calling a function with each element
+// of an array.
+func builtinMap(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 2, 2); err != nil {
+		return err
+	}
+	elements, err := arrayArgument("map", args, 0)
+	if err != nil {
+		return err
+	}
+	fn, err := functionArgument("map", args, 1)
+	if err != nil {
+		return err
+	}
+	if err := reserve(rt, len(elements)); err != nil {
+		return err
+	}
+	mapped := make([]object.Object, len(elements))
+	for i, element := range elements {
+		result := rt.Call(fn, []object.Object{element})
+		if isError(result) {
+			return result
+		}
+		mapped[i] = result
+	}
+	return &object.Array{Elements: mapped}
+}
+
+// builtinFilter returns the elements of an array for which a function
+// returns a truthy value.
+func builtinFilter(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 2, 2); err != nil {
+		return err
+	}
+	elements, err := arrayArgument("filter", args, 0)
+	if err != nil {
+		return err
+	}
+	fn, err := functionArgument("filter", args, 1)
+	if err != nil {
+		return err
+	}
+	filtered := []object.Object{}
+	for _, element := range elements {
+		result := rt.Call(fn, []object.Object{element})
+		if isError(result) {
+			return result
+		}
+		if isTruthy(result) {
+			filtered = append(filtered, element)
+		}
+	}
+	return &object.Array{Elements: filtered}
+}
+
+// builtinReduce is reduce(array, initial, fn). It calls fn with initial and
+// the first element, then with each result and the next element, and
+// returns the last result, or initial if the array is empty.
+func builtinReduce(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 3, 3); err != nil {
+		return err
+	}
+	elements, err := arrayArgument("reduce", args, 0)
+	if err != nil {
+		return err
+	}
+	fn, err := functionArgument("reduce", args, 2)
+	if err != nil {
+		return err
+	}
+	accumulator := args[1]
+	for _, element := range elements {
+		accumulator = rt.Call(fn, []object.Object{accumulator, element})
+		if isError(accumulator) {
+			return accumulator
+		}
+	}
+	return accumulator
+}
+
+// builtinRange is range(end), range(start, end) and range(start, end,
+// step). It returns the integers from start, which defaults to 0, up to
+// but not including end, counting by step, which defaults to 1 and may be
+// negative to count down.
+func builtinRange(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 3); err != nil {
+		return err
+	}
+	values := make([]int64, len(args))
+	for i := range args {
+		n, err := integerArgument("range", args, i)
+		if err != nil {
+			return err
+		}
+		values[i] = n
+	}
+	start, end, step := int64(0), values[0], int64(1)
+	if len(values) > 1 {
+		start, end = values[0], values[1]
+	}
+	if len(values) > 2 {
+		step = values[2]
+	}
+	if step == 0 {
+		return newError("range step must not be zero")
+	}
+
+	length := rangeLength(start, end, step)
+	if length > maxRangeLength {
+		return newError("range too long: %d elements", length)
+	}
+	if err := reserve(rt, int(length)); err != nil {
+		return err
+	}
+	elements := make([]object.Object, length)
+	for i := range elements {
+		elements[i] = &object.Integer{Value: start}
+		start += step
+	}
+	return &object.Array{Elements: elements}
+}
+
+// rangeLength returns the number of integers from start up to but not
+// including end, counting by step, without overflowing.
+func rangeLength(start, end, step int64) uint64 {
+	switch {
+	case step > 0 && start < end:
+		return (uint64(end)-uint64(start)-1)/uint64(step) + 1
+	case step < 0 && start > end:
+		return (uint64(start)-uint64(end)-1)/uint64(-step) + 1
+	default:
+		return 0
+	}
+}
@@ -0,0 +1,114 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+)
+
+func evalTryExpression(node *ast.TryExpression, env *object.Environment) object.Object {
+	result := Eval(node.Block, env)
+	if err, ok := result.(*object.Error); ok && node.Catch != nil && err.Kind != object.LimitExceeded {
+		catchEnv := env
+		if node.Parameter != nil {
+			catchEnv = object.NewBlockEnvironment(env, node.Parameter.Value, caughtError(err))
+		}
+		result = Eval(node.Catch, catchEnv)
+	}
+	if node.Finally == nil || isLimitExceeded(result) {
+		return result
+	}
+	// Finally runs for its effects, unless it leaves the try itself.
+	switch final := Eval(node.Finally, env); final.(type) {
+	case *object.Error, *object.ReturnValue, *object.Break, *object.Continue:
+		return final
+	}
+	return result
+}
+
+func isLimitExceeded(obj object.Object) bool {
+	err, ok := obj.(*object.Error)
+	return ok && err.Kind == object.LimitExceeded
+}
+
+// throwError returns the error that throw raises for val. Its message is
+// val itself if val is a string, the message key of val if val is a hash
+// that has one, and the inspected val otherwise. A hash may also name the
+// kind of the error with a kind key.
+func throwError(val object.Object) *object.Error {
+	if err, ok := val.(*object.Error); ok {
+		// Only the VM can get hold of an error, to rethrow it.
+		return err
+	}
+	err := &object.Error{Message: val.Inspect(), Kind: object.Thrown, Value: val}
+	switch val := val.(type) {
+	case *object.String:
+		err.Message = val.Value
+	case *object.Hash:
+		if message, ok := stringPair(val, "message"); ok {
+			err.Message = message
+		}
+		if kind, ok := stringPair(val, "kind"); ok && object.ErrorKind(kind) != object.LimitExceeded {
+			err.Kind = object.ErrorKind(kind)
+		}
+	}
+	return err
+}
+
+func stringPair(hash *object.Hash, key string) (string, bool) {
+	pair, ok := hash.Get(&object.String{Value: key})
+	if !ok {
+		return "", false
+	}
+	str, ok := pair.Value.(*object.String)
+	if !ok {
+		return "", false
+	}
+	return str.Value, true
+}
+
+// caughtError returns the value catch binds for err: a hash with its
+// message, kind, position, the value it was thrown with or null, and its
+// trace, an array of hashes with the function and position of each call
+// the error propagated out of.
+func caughtError(err *object.Error) *object.Hash {
+	trace := make([]object.Object, 0, len(err.Trace))
+	pos := err.Pos
+	for _, frame := range err.Trace {
+		trace = append(trace, newHash(
+			field{"function", &object.String{Value: frame.Function}},
+			field{"position", &object.String{Value: pos.String()}},
+		))
+		pos = frame.Call
+	}
+
+	kind := err.Kind
+	if kind == "" {
+		kind = object.RuntimeError
+	}
+	value := err.Value
+	if value == nil {
+		value = Null
+	}
+	return newHash(
+		field{"message", &object.String{Value: err.Message}},
+		field{"kind", &object.String{Value: string(kind)}},
+		field{"position", &object.String{Value: err.Pos.String()}},
+		field{"value", value},
+		field{"trace", &object.Array{Elements: trace}},
+	)
+}
+
+// field is a pair of a hash with a string key.
+type field struct {
+	key   string
+	value object.Object
+}
+
+// newHash returns a hash of fields, in their order.
+func newHash(fields ...field) *object.Hash {
+	hash := object.NewHash(len(fields))
+	for _, f := range fields {
+		hash.Set(&object.String{Value: f.key}, f.value)
+	}
+	return hash
+}
@@ -2,42 +2,41 @@ package evaluator
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"monkey/ast"
 	"monkey/object"
+	"monkey/token"
+	"strings"
 )
 
-var builtins = map[string]*object.Builtin{
-	"len": {
-		Fn: func(args ...object.Object) object.Object {
-			if len(args) != 1 {
-				return newError("wrong number of arguments. got=%d, want=1",
-					len(args))
-			}
-			switch arg := args[0].(type) {
-			case *object.String:
-				return &object.Integer{Value: int64(len(arg.Value))}
-			default:
-				return newError("argument to `len` not supported, got %s",
-					args[0].Type())
-			}
-		},
-	},
-}
-
 var (
 	Null  = &object.Null{}
 	True  = &object.Boolean{Value: true}
 	False = &object.Boolean{Value: false}
+
+	breakSignal    = &object.Break{}
+	continueSignal = &object.Continue{}
 )
 
 func Eval(node ast.Node, env *object.Environment) object.Object {
+	if m := env.Meter(); m != nil {
+		if err := m.Step(); err != nil {
+			return withPosition(err, node.Pos())
+		}
+	}
+
 	switch node := node.(type) {
 	case *ast.Program:
 		return evalStatements(node.Statements, env)
 	case *ast.ExpressionStatement:
 		return Eval(node.Expression, env)
 	case *ast.IntegerLiteral:
-		return &object.Integer{Value: node.Value}
+		return allocate(env, &object.Integer{Value: node.Value})
+	case *ast.BigIntLiteral:
+		return allocate(env, &object.BigInt{Value: node.Value})
+	case *ast.FloatLiteral:
+		return allocate(env, &object.Float{Value: node.Value})
 	case *ast.Boolean:
 		return nativeBoolToBooleanObject(node.Value)
 	case *ast.PrefixOperator:
@@ -45,7 +44,7 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalPrefixExpression(node.Operator, right)
+		return withPosition(allocate(env, evalPrefixExpression(node.Operator, right, env.Overflow())), node.Token.Pos)
 	case *ast.IndexExpression:
 		left := Eval(node.Left, env)
 		if isError(left) {
@@ -55,8 +54,13 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(index) {
 			return index
 		}
-		return evalIndexExpression(left, index)
+		return withPosition(evalIndexExpression(left, index), node.Token.Pos)
+	case *ast.SliceExpression:
+		return evalSliceNode(node, env)
 	case *ast.InfixExpression:
+		if node.Operator == "&&" || node.Operator == "||" {
+			return evalLogicalExpression(node, env)
+		}
 		left := Eval(node.Left, env)
 		if isError(left) {
 			return left
@@ -65,29 +69,50 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if isError(right) {
 			return right
 		}
-		return evalInfixExpression(node.Operator, left, right)
+		return withPosition(infixOperation(node.Operator, left, right, env), node.Token.Pos)
 	case *ast.BlockStatement:
 		return evalBlockStatement(node.Statements, env)
 	case *ast.IfExpression:
 		return evalIfExpression(node, env)
+	case *ast.AssignExpression:
+		return evalAssignExpression(node, env)
+	case *ast.WhileStatement:
+		return evalWhileStatement(node, env)
+	case *ast.ForStatement:
+		return evalForStatement(node, env)
+	case *ast.BranchStatement:
+		if node.Token.Type == token.BREAK {
+			return breakSignal
+		}
+		return continueSignal
 	case *ast.ReturnStatement:
 		val := Eval(node.ReturnValue, env)
 		if isError(val) {
 			return val
 		}
 		return &object.ReturnValue{Value: val}
+	case *ast.ThrowStatement:
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		return withPosition(throwError(val), node.Token.Pos)
+	case *ast.TryExpression:
+		return evalTryExpression(node, env)
 	case *ast.LetStatement:
 		val := Eval(node.Value, env)
 		if isError(val) {
 			return val
 		}
-		env.Set(node.Name.Value, val)
+		if !env.Declare(node.Name.Value, val, node.IsConst()) {
+			return withPosition(newError("cannot redeclare constant %s", node.Name.Value), node.Name.Pos())
+		}
 	case *ast.Identifier:
-		return evalIdentifier(node, env)
+		return withPosition(evalIdentifier(node, env), node.Pos())
 	case *ast.FunctionLiteral:
 		params := node.Parameters
 		body := node.Body
-		return &object.Function{Parameters: params, Env: env, Body: body}
+		return allocate(env, &object.Function{Name: node.Name, Parameters: params, Env: env, Body: body})
 	case *ast.CallExpression:
 		function := Eval(node.Function, env)
 		if isError(function) {
@@ -97,11 +122,21 @@ func Eval(node ast.Node, env *object.Environment) object.Object {
 		if len(args) == 1 && isError(args[0]) {
 			return args[0]
 		}
-		return applyFunction(function, args)
+		return withPosition(applyFunction(function, args, env, node.Pos()), node.Pos())
 	case *ast.StringLiteral:
-		return &object.String{Value: node.Value}
+		return allocate(env, &object.String{Value: node.Value})
 	case *ast.HashLiteral:
-		return evalHashLiteral(node, env)
+		return withPosition(allocate(env, evalHashLiteral(node, env)), node.Pos())
+	case *ast.BadStatement:
+		return withPosition(newError("invalid syntax: %s", node.String()), node.Pos())
+	case *ast.BadExpression:
+		return withPosition(newError("invalid syntax: %s", node.String()), node.Pos())
+	case *ast.ArrayLiteral:
+		elements := evalExpressions(node.Elements, env)
+		if len(elements) == 1 && isError(elements[0]) {
+			return elements[0]
+		}
+		return allocate(env, &object.Array{Elements: elements})
 	}
 	return nil
 }
@@ -110,9 +145,9 @@ func evalHashLiteral(
 	node *ast.HashLiteral,
 	env *object.Environment,
 ) object.Object {
-	pairs := make(map[object.HashKey]object.HashPair)
-	for keyNode, valueNode := range node.Pairs {
-		key := Eval(keyNode, env)
+	hash := object.NewHash(len(node.Pairs))
+	for _, pair := range node.Pairs {
+		key := Eval(pair.Key, env)
 		if isError(key) {
 			return key
 		}
@@ -120,51 +155,136 @@ func evalHashLiteral(
 		if !ok {
 			return newError("unusable as hash key: %s", key.Type())
 		}
-		value := Eval(valueNode, env)
+		value := Eval(pair.Value, env)
 		if isError(value) {
 			return value
 		}
-		hashed := hashKey.HashKey()
-		pairs[hashed] = object.HashPair{Key: key, Value: value}
+		hash.Set(hashKey, value)
 	}
-	return &object.Hash{Pairs: pairs}
+	return hash
 }
 
 func evalIndexExpression(left, index object.Object) object.Object {
 	switch {
+	case left.Type() == object.ArrayObj:
+		return evalArrayIndexExpression(left, index)
 	case left.Type() == object.HashObj:
 		return evalHashIndexExpression(left, index)
+	case left.Type() == object.StringObj:
+		return evalStringIndexExpression(left, index)
 	default:
 		return newError("index operator not supported: %s", left.Type())
 	}
 }
 
+// evalArrayIndexExpression looks up an element by position. Negative indices
+// count from the end, so arr[-1] is the last element; indices outside the
+// array evaluate to null.
+func evalArrayIndexExpression(array, index object.Object) object.Object {
+	arrayObject := array.(*object.Array)
+	i, ok := indexValue(index)
+	if !ok {
+		return newError("array index must be INTEGER, got %s", index.Type())
+	}
+	length := int64(len(arrayObject.Elements))
+	if i < 0 {
+		i += length
+	}
+	if i < 0 || i >= length {
+		return Null
+	}
+	return arrayObject.Elements[i]
+}
+
 func evalHashIndexExpression(hash, index object.Object) object.Object {
 	hashObject := hash.(*object.Hash)
 	key, ok := index.(object.Hashable)
 	if !ok {
 		return newError("unusable as hash key: %s", index.Type())
 	}
-	pair, ok := hashObject.Pairs[key.HashKey()]
+	pair, ok := hashObject.Get(key)
 	if !ok {
 		return Null
 	}
 	return pair.Value
 }
 
-func applyFunction(fn object.Object, args []object.Object) object.Object {
+// applyFunction calls fn with args. env is the environment of the caller,
+// which builtins get their IO from, and call is the position of the call,
+// which the traceback of an error propagating out of fn shows.
+func applyFunction(fn object.Object, args []object.Object, env *object.Environment, call token.Position) object.Object {
 	switch fn := fn.(type) {
 	case *object.Function:
+		if len(args) != len(fn.Parameters) {
+			return newError("wrong number of arguments: want=%d, got=%d",
+				len(fn.Parameters), len(args))
+		}
+		depth := 1
+		if env != nil {
+			depth = env.CallDepth() + 1
+		}
+		if depth >= maxDepth(env, fn.Env) {
+			return newError("stack overflow")
+		}
+		if m := fn.Env.Meter(); m != nil {
+			if err := m.Call(); err != nil {
+				return err
+			}
+			defer m.Return()
+		}
 		extendedEnv := extendFunctionEnv(fn, args)
-		evaluated := Eval(fn.Body, extendedEnv)
+		extendedEnv.SetCallDepth(depth)
+		evaluated := branchError(Eval(fn.Body, extendedEnv))
+		if err, ok := evaluated.(*object.Error); ok {
+			err.AddFrame(fn.Name, call)
+		}
 		return unwrapReturnValue(evaluated)
 	case *object.Builtin:
-		return fn.Fn(args...)
+		rt := &evalRuntime{env: env, call: call}
+		result := fn.Fn(rt, args...)
+		if rt.allocated {
+			return result
+		}
+		return allocate(env, result)
 	default:
 		return newError("not a function: %s", fn.Type())
 	}
 }
 
+// evalRuntime lets the builtins called by Eval call back into the program.
+type evalRuntime struct {
+	env       *object.Environment // the environment of the caller, or nil
+	call      token.Position      // the position of the call of the builtin
+	allocated bool                // whether the builtin called Allocate
+}
+
+func (rt *evalRuntime) Call(fn object.Object, args []object.Object) object.Object {
+	result := applyFunction(fn, args, rt.env, rt.call)
+	if result == nil {
+		return Null
+	}
+	return result
+}
+
+// IO returns the IO of the caller's environment, or the standard input and
+// output if it has none.
+func (rt *evalRuntime) IO() *object.IO {
+	if rt.env != nil {
+		if io := rt.env.IO(); io != nil {
+			return io
+		}
+	}
+	return Stdio
+}
+
+func (rt *evalRuntime) Allocate(size int64) *object.Error {
+	rt.allocated = true
+	if rt.env == nil {
+		return nil
+	}
+	return charge(rt.env, size)
+}
+
 func extendFunctionEnv(
 	fn *object.Function,
 	args []object.Object,
@@ -175,6 +295,20 @@ func extendFunctionEnv(
 	}
 	return env
 }
+
+// branchError turns a break or continue that left a function body or the
+// program without a loop to leave into an error. The parser rejects them,
+// so only programs evaluated despite syntax errors have them.
+func branchError(obj object.Object) object.Object {
+	switch obj.(type) {
+	case *object.Break:
+		return newError("break outside of a loop")
+	case *object.Continue:
+		return newError("continue outside of a loop")
+	}
+	return obj
+}
+
 func unwrapReturnValue(obj object.Object) object.Object {
 	if returnValue, ok := obj.(*object.ReturnValue); ok {
 		return returnValue.Value
@@ -244,29 +378,126 @@ func isTruthy(obj object.Object) bool {
 	}
 }
 
-func evalInfixExpression(operator string, left object.Object, right object.Object) object.Object {
+// evalLogicalExpression evaluates && and ||, skipping the right operand when
+// the left one decides the result. The result is always a boolean.
+func evalLogicalExpression(node *ast.InfixExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	if node.Operator == "&&" && !isTruthy(left) {
+		return False
+	}
+	if node.Operator == "||" && isTruthy(left) {
+		return True
+	}
+	right := Eval(node.Right, env)
+	if isError(right) {
+		return right
+	}
+	return nativeBoolToBooleanObject(isTruthy(right))
+}
+
+func evalInfixExpression(operator string, left object.Object, right object.Object, overflow object.Overflow) object.Object {
 	switch {
+	case isInteger(left) && isInteger(right) && (left.Type() == object.BigIntObj || right.Type() == object.BigIntObj):
+		return evalBigIntInfixExpression(operator, left, right)
+	case operator == "*" && left.Type() == object.StringObj && right.Type() == object.IntegerObj:
+		return repeatString(left.(*object.String), right.(*object.Integer))
+	case operator == "*" && left.Type() == object.IntegerObj && right.Type() == object.StringObj:
+		return repeatString(right.(*object.String), left.(*object.Integer))
+	case isNumber(left) && isNumber(right) && left.Type() != right.Type():
+		// Mixed integer and float operands are computed as floats.
+		return evalFloatInfixExpression(operator, toFloat(left), toFloat(right))
 	case left.Type() != right.Type():
 		return newError("type mismatch: %s %s %s", left.Type(), operator, right.Type())
 	case left.Type() == object.IntegerObj && right.Type() == object.IntegerObj:
-		return evalIntegerInfixExpression(operator, left, right)
+		return evalIntegerInfixExpression(operator, left, right, overflow)
+	case left.Type() == object.FloatObj && right.Type() == object.FloatObj:
+		return evalFloatInfixExpression(operator, left, right)
 	case left.Type() == object.StringObj && right.Type() == object.StringObj:
 		return evalStringInfixExpression(operator, left, right)
 	case operator == "==":
-		return nativeBoolToBooleanObject(left == right)
+		return nativeBoolToBooleanObject(equal(left, right, nil))
 	case operator == "!=":
-		return nativeBoolToBooleanObject(left != right)
+		return nativeBoolToBooleanObject(!equal(left, right, nil))
 	default:
 		return newError("unknown operator: %s %s %s", left.Type(), operator, right.Type())
 	}
 }
 
+// equal reports whether left == right. Arrays are equal if their elements
+// are, and hashes if they have equal keys with equal values in the same
+// order. Numbers and strings compare by value, other objects by identity.
+// seen holds the arrays and hashes being compared, so that comparing
+// cyclic ones ends.
+func equal(left, right object.Object, seen map[[2]object.Object]bool) bool {
+	if left == right {
+		return true
+	}
+	switch left := left.(type) {
+	case *object.Array:
+		right, ok := right.(*object.Array)
+		if !ok || len(left.Elements) != len(right.Elements) {
+			return false
+		}
+		if seen[[2]object.Object{left, right}] {
+			return true
+		}
+		if seen == nil {
+			seen = map[[2]object.Object]bool{}
+		}
+		seen[[2]object.Object{left, right}] = true
+		for i, el := range left.Elements {
+			if !equal(el, right.Elements[i], seen) {
+				return false
+			}
+		}
+		return true
+	case *object.Hash:
+		right, ok := right.(*object.Hash)
+		if !ok || left.Len() != right.Len() {
+			return false
+		}
+		if seen[[2]object.Object{left, right}] {
+			return true
+		}
+		if seen == nil {
+			seen = map[[2]object.Object]bool{}
+		}
+		seen[[2]object.Object{left, right}] = true
+		rightPairs := right.Pairs()
+		for i, pair := range left.Pairs() {
+			other := rightPairs[i]
+			if pair.Key.Type() != other.Key.Type() || !equal(pair.Key, other.Key, seen) ||
+				!equal(pair.Value, other.Value, seen) {
+				return false
+			}
+		}
+		return true
+	}
+	if isNumber(left) && isNumber(right) ||
+		left.Type() == object.StringObj && right.Type() == object.StringObj {
+		result, ok := evalInfixExpression("==", left, right, object.OverflowWrap).(*object.Boolean)
+		return ok && result.Value
+	}
+	return false
+}
+
 func evalStringInfixExpression(operator string, left object.Object, right object.Object) object.Object {
 	leftVal := left.(*object.String).Value
 	rightVal := right.(*object.String).Value
 	switch operator {
 	case "+":
 		return &object.String{Value: leftVal + rightVal}
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
 	default:
 		return newError("unknown operator %s %s %s", left.Type(), operator, right.Type())
 	}
@@ -275,18 +506,43 @@ func evalStringInfixExpression(operator string, left object.Object, right object
 func evalIntegerInfixExpression(
 	operator string,
 	left, right object.Object,
+	overflow object.Overflow,
 ) object.Object {
 	leftVal := left.(*object.Integer).Value
 	rightVal := right.(*object.Integer).Value
 	switch operator {
+	case "+", "-", "*", "/", "%":
+		return evalIntegerArithmetic(operator, leftVal, rightVal, overflow)
+	case "<":
+		return nativeBoolToBooleanObject(leftVal < rightVal)
+	case ">":
+		return nativeBoolToBooleanObject(leftVal > rightVal)
+	case "==":
+		return nativeBoolToBooleanObject(leftVal == rightVal)
+	case "!=":
+		return nativeBoolToBooleanObject(leftVal != rightVal)
+	default:
+		return newError("unknown operator %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+func evalFloatInfixExpression(
+	operator string,
+	left, right object.Object,
+) object.Object {
+	leftVal := left.(*object.Float).Value
+	rightVal := right.(*object.Float).Value
+	switch operator {
 	case "+":
-		return &object.Integer{Value: leftVal + rightVal}
+		return &object.Float{Value: leftVal + rightVal}
 	case "-":
-		return &object.Integer{Value: leftVal - rightVal}
+		return &object.Float{Value: leftVal - rightVal}
 	case "*":
-		return &object.Integer{Value: leftVal * rightVal}
+		return &object.Float{Value: leftVal * rightVal}
 	case "/":
-		return &object.Integer{Value: leftVal / rightVal}
+		return &object.Float{Value: leftVal / rightVal}
+	case "%":
+		return &object.Float{Value: math.Mod(leftVal, rightVal)}
 	case "<":
 		return nativeBoolToBooleanObject(leftVal < rightVal)
 	case ">":
@@ -300,23 +556,45 @@ func evalIntegerInfixExpression(
 	}
 }
 
-func evalPrefixExpression(operator string, right object.Object) object.Object {
+func isNumber(obj object.Object) bool {
+	return isInteger(obj) || obj.Type() == object.FloatObj
+}
+
+// toFloat converts a number to a float, promoting integers.
+func toFloat(obj object.Object) *object.Float {
+	switch obj := obj.(type) {
+	case *object.Integer:
+		return &object.Float{Value: float64(obj.Value)}
+	case *object.BigInt:
+		f, _ := new(big.Float).SetInt(obj.Value).Float64()
+		return &object.Float{Value: f}
+	default:
+		return obj.(*object.Float)
+	}
+}
+
+func evalPrefixExpression(operator string, right object.Object, overflow object.Overflow) object.Object {
 	switch operator {
 	case "!":
 		return evalBangOperatorExpression(right)
 	case "-":
-		return evalMinusPrefixOperatorExpression(right)
+		return evalMinusPrefixOperatorExpression(right, overflow)
 	default:
 		return newError("unknown operator: %s%s", operator, right.Type())
 	}
 }
 
-func evalMinusPrefixOperatorExpression(right object.Object) object.Object {
-	if right.Type() != object.IntegerObj {
+func evalMinusPrefixOperatorExpression(right object.Object, overflow object.Overflow) object.Object {
+	switch right := right.(type) {
+	case *object.Integer:
+		return evalIntegerNegation(right.Value, overflow)
+	case *object.BigInt:
+		return integerObject(new(big.Int).Neg(right.Value))
+	case *object.Float:
+		return &object.Float{Value: -right.Value}
+	default:
 		return newError("unknown operator: -%s", right.Type())
 	}
-	value := right.(*object.Integer).Value
-	return &object.Integer{Value: -value}
 }
 
 func evalBangOperatorExpression(right object.Object) object.Object {
@@ -349,6 +627,8 @@ func evalStatements(stmts []ast.Statement, env *object.Environment) object.Objec
 			return result.Value
 		case *object.Error:
 			return result
+		case *object.Break, *object.Continue:
+			return branchError(result)
 		}
 		// if err, ok := result.(*object.Error); ok {
 		// 	return err
@@ -367,14 +647,194 @@ func evalBlockStatement(stmts []ast.Statement, env *object.Environment) object.O
 
 	for _, statement := range stmts {
 		result = Eval(statement, env)
-		if result != nil && (result.Type() == object.ReturnValueObj || result.Type() == object.ErrorObj) {
-			return result
+		if result != nil {
+			switch result.Type() {
+			case object.ReturnValueObj, object.ErrorObj, object.BreakObj, object.ContinueObj:
+				return result
+			}
 		}
 	}
 
 	return result
 }
 
+func evalAssignExpression(node *ast.AssignExpression, env *object.Environment) object.Object {
+	switch target := node.Target.(type) {
+	case *ast.Identifier:
+		var current object.Object
+		if node.Operator != "=" {
+			current = Eval(target, env)
+			if isError(current) {
+				return current
+			}
+		}
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if current != nil {
+			val = withPosition(compoundOperation(node.Operator, current, val, env), node.Token.Pos)
+			if isError(val) {
+				return val
+			}
+		}
+		if env.IsConst(target.Value) {
+			return withPosition(newError("cannot assign to constant %s", target.Value), target.Pos())
+		}
+		if !env.Assign(target.Value, val) {
+			return withPosition(newError("identifier not found: "+target.Value), target.Pos())
+		}
+		return val
+
+	case *ast.IndexExpression:
+		left := Eval(target.Left, env)
+		if isError(left) {
+			return left
+		}
+		index := Eval(target.Index, env)
+		if isError(index) {
+			return index
+		}
+		var current object.Object
+		if node.Operator != "=" {
+			current = withPosition(evalIndexExpression(left, index), target.Token.Pos)
+			if isError(current) {
+				return current
+			}
+		}
+		val := Eval(node.Value, env)
+		if isError(val) {
+			return val
+		}
+		if current != nil {
+			val = withPosition(compoundOperation(node.Operator, current, val, env), node.Token.Pos)
+			if isError(val) {
+				return val
+			}
+		}
+		return withPosition(evalSetIndex(left, index, val), target.Token.Pos)
+
+	default:
+		return withPosition(newError("cannot assign to %s", node.Target.String()), node.Pos())
+	}
+}
+
+// compoundOperation applies the operator of a compound assignment such as
+// += to the current value of the target and the assigned value.
+func compoundOperation(operator string, current, val object.Object, env *object.Environment) object.Object {
+	return infixOperation(strings.TrimSuffix(operator, "="), current, val, env)
+}
+
+// evalSetIndex stores val at left[index] and returns val.
+func evalSetIndex(left, index, val object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		i, ok := indexValue(index)
+		if !ok {
+			return newError("array index must be INTEGER, got %s", index.Type())
+		}
+		if i < 0 {
+			i += int64(len(left.Elements))
+		}
+		if i < 0 || i >= int64(len(left.Elements)) {
+			return newError("index out of range: %s", index.Inspect())
+		}
+		left.Elements[i] = val
+		return val
+	case *object.Hash:
+		key, ok := index.(object.Hashable)
+		if !ok {
+			return newError("unusable as hash key: %s", index.Type())
+		}
+		left.Set(key, val)
+		return val
+	default:
+		return newError("index assignment not supported: %s", left.Type())
+	}
+}
+
+func evalWhileStatement(node *ast.WhileStatement, env *object.Environment) object.Object {
+	for {
+		condition := Eval(node.Condition, env)
+		if isError(condition) {
+			return condition
+		}
+		if !isTruthy(condition) {
+			return Null
+		}
+		if result, done := evalLoopBody(node.Body, env); done {
+			return result
+		}
+	}
+}
+
+func evalForStatement(node *ast.ForStatement, env *object.Environment) object.Object {
+	iterable := Eval(node.Iterable, env)
+	if isError(iterable) {
+		return iterable
+	}
+	elements, err := iterate(iterable)
+	if err != nil {
+		return withPosition(err, node.Iterable.Pos())
+	}
+	for _, element := range elements {
+		if !env.Declare(node.Variable.Value, element, false) {
+			return withPosition(newError("cannot redeclare constant %s", node.Variable.Value), node.Variable.Pos())
+		}
+		if result, done := evalLoopBody(node.Body, env); done {
+			return result
+		}
+	}
+	return Null
+}
+
+// evalLoopBody runs one iteration of a loop. It reports whether the loop
+// ends, and if so the value the loop statement evaluates to.
+func evalLoopBody(body *ast.BlockStatement, env *object.Environment) (object.Object, bool) {
+	switch result := Eval(body, env).(type) {
+	case *object.Break:
+		return Null, true
+	case *object.ReturnValue, *object.Error:
+		return result, true
+	}
+	return nil, false
+}
+
+// iterate returns the elements a for loop visits: the elements of an array,
+// the characters of a string or the keys of a hash.
+func iterate(obj object.Object) ([]object.Object, *object.Error) {
+	switch obj := obj.(type) {
+	case *object.Array:
+		elements := make([]object.Object, len(obj.Elements))
+		copy(elements, obj.Elements)
+		return elements, nil
+	case *object.String:
+		elements := []object.Object{}
+		for _, r := range obj.Value {
+			elements = append(elements, &object.String{Value: string(r)})
+		}
+		return elements, nil
+	case *object.Hash:
+		elements := make([]object.Object, 0, obj.Len())
+		for _, pair := range obj.Pairs() {
+			elements = append(elements, pair.Key)
+		}
+		return elements, nil
+	default:
+		return nil, newError("cannot iterate over %s", obj.Type())
+	}
+}
+
 func newError(format string, a ...interface{}) *object.Error {
 	return &object.Error{Message: fmt.Sprintf(format, a...)}
 }
+
+// withPosition records pos on an error that does not know where it occurred
+// yet. Errors propagating out of nested expressions keep their original,
+// innermost position.
+func withPosition(obj object.Object, pos token.Position) object.Object {
+	if err, ok := obj.(*object.Error); ok && !err.Pos.IsValid() {
+		err.Pos = pos
+	}
+	return obj
+}
@@ -1,10 +1,13 @@
 package evaluator
 
 import (
+	"context"
+	"math"
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
 	"testing"
+	"time"
 )
 
 func TestEvalIntegerExpression(t *testing.T) {
@@ -34,6 +37,116 @@ func TestEvalIntegerExpression(t *testing.T) {
 	}
 }
 
+func TestEvalFloatExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"3.14", 3.14},
+		{"-2.5", -2.5},
+		{"1e-9", 1e-9},
+		{"2.0", 2.0},
+		{"0.1 + 0.2", 0.30000000000000004},
+		{"1.5 * 2", 3.0},
+		{"2 * 1.5", 3.0},
+		{"7 / 2.0", 3.5},
+		{"7 / 2", 3},
+		{"1 - 0.5", 0.5},
+		{"1.0 / 0", math.Inf(1)},
+		{"1 == 1.0", true},
+		{"1.5 > 1", true},
+		{"2 < 1.5", false},
+		{"0.5 != 0.5", false},
+		{"{1.5: 7}[1.5]", 7},
+		{"{0.0: 7}[-0.0]", 7},
+		{"1.5 + true", "type mismatch: FLOAT + BOOLEAN"},
+		{"[1][1.0]", "array index must be INTEGER, got FLOAT"},
+	}
+	for _, tt := range tests {
+		testObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestLoops(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let i = 0; while (i < 5) { let i = i + 1; } i", 5},
+		{"let i = 0; while (true) { let i = i + 1; if (i > 2) { break } } i", 3},
+		{"let n = 0; let i = 0; while (i < 5) { let i = i + 1; if (i == 2) { continue } let n = n + i; } n", 13},
+		{"while (false) { 1 }", nil},
+		{"let s = 0; for (x in [1, 2, 3]) { let s = s + x; } s", 6},
+		{"let s = \"\"; for (c in \"héj\") { let s = c + s; } s == \"jéh\"", true},
+		{"let n = 0; for (k in {\"a\": 1, \"b\": 2}) { let n = n + 1; } n", 2},
+		{"for (x in [1, 2, 3]) { if (x == 2) { break } } x", 2},
+		{"let f = fn() { for (x in [1, 2, 3]) { if (x > 1) { return x * 10 } } }; f()", 20},
+		{"let n = 0; for (x in [1, 2]) { for (y in [1, 2, 3]) { if (y == 2) { break } let n = n + 1; } } n", 2},
+		{"for (x in 5) { x }", "cannot iterate over INTEGER"},
+		{"while (1 + true) { 1 }", "type mismatch: INTEGER + BOOLEAN"},
+		// The parser rejects a break that leaves a function, but Eval does
+		// not rely on it.
+		{"let i = 0; while (i < 3) { i += 1; let f = fn() { break }; f() } i", "break outside of a loop"},
+		{"for (x in [1, 2]) { fn() { continue }() }", "continue outside of a loop"},
+		{"break; 1", "break outside of a loop"},
+	}
+	for _, tt := range tests {
+		testObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestAssignment(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"let x = 1; x = 2; x", 2},
+		{"let x = 1; x = x + 1", 2},
+		{"let x = 10; x += 5; x -= 1; x *= 2; x /= 7; x", 4},
+		{"let x = 1; let y = 2; x = y = 3; [x, y]", []int{3, 3}},
+		{"let x = 1; let f = fn() { x = 5 }; f(); x", 5},
+		{"let x = 1; let f = fn() { let x = 2; x = 3 }; f(); x", 1},
+		{"let newCounter = fn() { let n = 0; fn() { n += 1 } }; let c = newCounter(); c(); c(); c()", 3},
+		{"let a = [1, 2, 3]; a[0] = 10; a[-1] += 1; a", []int{10, 2, 4}},
+		{"let a = [1]; let b = a; b[0] = 2; a", []int{2}},
+		{`let h = {"a": 1}; h["a"] += 1; h["b"] = 3; [h["a"], h["b"]]`, []int{2, 3}},
+		{"let x = 1; let s = \"a\"; s += \"b\"; s == \"ab\"", true},
+		{"y = 1", "identifier not found: y"},
+		{"y += 1", "identifier not found: y"},
+		{"len = 1", "identifier not found: len"},
+		{"let f = fn() { z = 1 }; f()", "identifier not found: z"},
+		{"if (true) { w = 1 }; 2", "identifier not found: w"},
+		{"u[0] = 1", "identifier not found: u"},
+		{"let x = 1; x += true", "type mismatch: INTEGER + BOOLEAN"},
+		{"let a = [1]; a[1] = 2", "index out of range: 1"},
+		{"let a = [1]; a[\"x\"] = 2", "array index must be INTEGER, got STRING"},
+		{"let h = {}; h[[]] = 2", "unusable as hash key: ARRAY"},
+		{"let s = \"ab\"; s[0] = \"c\"", "index assignment not supported: STRING"},
+	}
+	for _, tt := range tests {
+		testObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
+func TestConstants(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"const x = 5; x * 2", 10},
+		{"const x = 5; let f = fn() { let x = 1; x += 1 }; f() + x", 7},
+		{"if (true) { const x = 1 } x = 2", "cannot assign to constant x"},
+		{"if (true) { const x = 1 } x += 2", "cannot assign to constant x"},
+		{"if (true) { const x = 1 } let x = 2", "cannot redeclare constant x"},
+		{"if (true) { const x = 1 } const x = 2", "cannot redeclare constant x"},
+		{"let f = fn() { x = 2 }; const x = 1; f()", "cannot assign to constant x"},
+		{"let i = 0; while (i < 2) { const x = i; i += 1; } i", "cannot redeclare constant x"},
+	}
+	for _, tt := range tests {
+		testObject(t, testEval(tt.input), tt.expected)
+	}
+}
+
 func testEval(input string) object.Object {
 	l := lexer.New(input)
 	p := parser.New(l)
@@ -42,6 +155,67 @@ func testEval(input string) object.Object {
 	return Eval(program, env)
 }
 
+// testObject checks obj against expected: an int, float64 or bool for an
+// integer, float or boolean, []int for an array of integers, nil for null
+// and a string for the message of an error.
+func testObject(t *testing.T, obj object.Object, expected interface{}) bool {
+	switch expected := expected.(type) {
+	case int:
+		return testIntegerObject(t, obj, int64(expected))
+	case float64:
+		return testFloatObject(t, obj, expected)
+	case bool:
+		return testBooleanObject(t, obj, expected)
+	case []int:
+		array, ok := obj.(*object.Array)
+		if !ok || len(array.Elements) != len(expected) {
+			t.Errorf("object is not an Array of %d elements. got=%T (%+v)", len(expected), obj, obj)
+			return false
+		}
+		for i, element := range expected {
+			if !testIntegerObject(t, array.Elements[i], int64(element)) {
+				return false
+			}
+		}
+		return true
+	case string:
+		return testErrorObject(t, obj, expected)
+	case nil:
+		return testNullObject(t, obj)
+	default:
+		t.Fatalf("cannot check for %T", expected)
+		return false
+	}
+}
+
+func testFloatObject(t *testing.T, obj object.Object, expected float64) bool {
+	result, ok := obj.(*object.Float)
+	if !ok {
+		t.Errorf("object is not Float. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if result.Value != expected {
+		t.Errorf("object has wrong value. got=%g, want=%g",
+			result.Value, expected)
+		return false
+	}
+	return true
+}
+
+func testErrorObject(t *testing.T, obj object.Object, expected string) bool {
+	errObj, ok := obj.(*object.Error)
+	if !ok {
+		t.Errorf("object is not Error. got=%T (%+v)", obj, obj)
+		return false
+	}
+	if errObj.Message != expected {
+		t.Errorf("wrong error message. expected=%q, got=%q",
+			expected, errObj.Message)
+		return false
+	}
+	return true
+}
+
 func testIntegerObject(t *testing.T, obj object.Object, expected int64) bool {
 	result, ok := obj.(*object.Integer)
 	if !ok {
@@ -89,6 +263,34 @@ func TestEvalBooleanExpression(t *testing.T) {
 	}
 }
 
+func TestLogicalOperators(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected bool
+	}{
+		{"true && true", true},
+		{"true && false", false},
+		{"false || true", true},
+		{"false || false", false},
+		{"1 && \"\"", true},
+		{"if (false) { 1 } || 0", true},
+		{"1 < 2 && 2 < 3", true},
+		// The right operand is not evaluated when the left decides.
+		{"false && missing", false},
+		{"true || missing()", true},
+		{"let x = [1]; len(\"\") == 1 && x[5] > 0", false},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		testBooleanObject(t, evaluated, tt.expected)
+	}
+
+	evaluated := testEval("true && missing")
+	if errObj, ok := evaluated.(*object.Error); !ok || errObj.Message != "identifier not found: missing" {
+		t.Errorf("expected the right operand to be evaluated. got=%s", evaluated.Inspect())
+	}
+}
+
 func testBooleanObject(t *testing.T, obj object.Object, expected bool) bool {
 	result, ok := obj.(*object.Boolean)
 	if !ok {
@@ -211,6 +413,10 @@ func TestErrorHandling(t *testing.T) {
 			}`,
 			"unknown operator: BOOLEAN + BOOLEAN",
 		},
+		{
+			`[1, 2, 3]["one"]`,
+			"array index must be INTEGER, got STRING",
+		},
 	}
 
 	for _, tt := range tests {
@@ -320,8 +526,14 @@ func TestBuiltinFunctions(t *testing.T) {
 		{`len("")`, 0},
 		{`len("four")`, 4},
 		{`len("hello world")`, 11},
-		{`len(1)`, "argument to `len` not supported, got INTEGER"},
+		{`len(1)`, "argument 1 to `len` must be STRING, ARRAY or HASH, got INTEGER"},
 		{`len("one", "two")`, "wrong number of arguments. got=2, want=1"},
+		{`len([1, 2, 3])`, 3},
+		{`first([1, 2, 3])`, 1},
+		{`last([1, 2, 3])`, 3},
+		{`first(1)`, "argument 1 to `first` must be ARRAY, got INTEGER"},
+		{`reduce(range(5), 0, fn(sum, x) { sum + x })`, 10},
+		{`filter([1], "x")`, "argument 2 to `filter` must be FUNCTION, got STRING"},
 	}
 	for _, tt := range tests {
 		evaluated := testEval(tt.input)
@@ -342,3 +554,165 @@ func TestBuiltinFunctions(t *testing.T) {
 		}
 	}
 }
+
+func TestArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	evaluated := testEval(input)
+	result, ok := evaluated.(*object.Array)
+	if !ok {
+		t.Fatalf("object is not Array. got=%T (%+v)", evaluated, evaluated)
+	}
+	if len(result.Elements) != 3 {
+		t.Fatalf("array has wrong num of elements. got=%d",
+			len(result.Elements))
+	}
+	testIntegerObject(t, result.Elements[0], 1)
+	testIntegerObject(t, result.Elements[1], 4)
+	testIntegerObject(t, result.Elements[2], 6)
+
+	if result.Inspect() != "[1, 4, 6]" {
+		t.Errorf("array.Inspect() wrong. got=%q", result.Inspect())
+	}
+}
+
+func TestArrayIndexExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected interface{}
+	}{
+		{"[1, 2, 3][0]", 1},
+		{"[1, 2, 3][1]", 2},
+		{"[1, 2, 3][2]", 3},
+		{"let i = 0; [1][i];", 1},
+		{"[1, 2, 3][1 + 1];", 3},
+		{"let myArray = [1, 2, 3]; myArray[2];", 3},
+		{"let myArray = [1, 2, 3]; myArray[0] + myArray[1] + myArray[2];", 6},
+		{"let myArray = [1, 2, 3]; let i = myArray[0]; myArray[i]", 2},
+		{"[1, 2, 3][3]", nil},
+		{"[1, 2, 3][-1]", 3},
+		{"[1, 2, 3][-3]", 1},
+		{"[1, 2, 3][-4]", nil},
+		{"[][0]", nil},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		integer, ok := tt.expected.(int)
+		if ok {
+			testIntegerObject(t, evaluated, int64(integer))
+		} else {
+			testNullObject(t, evaluated)
+		}
+	}
+}
+
+func TestErrorPositions(t *testing.T) {
+	tests := []struct {
+		input       string
+		expectedPos string
+	}{
+		{"5 + true;", "1:3"},
+		{"let a = 1;\nlet b = -true;", "2:9"},
+		{"let f = fn(x) {\n  x + y\n};\nf(1);", "2:7"},
+		{"len(1)", "1:1"},
+		{"foobar", "1:1"},
+		{"y = 1", "1:1"},
+		{"let f = fn() { z = 1 }; f()", "1:16"},
+		{"let x = 1; x += true", "1:14"},
+		{"let a = [1]; a[1] = 2", "1:15"},
+		{"let h = {}; h[[]] = 2", "1:14"},
+		{"let s = \"ab\"; s[0] = \"c\"", "1:16"},
+		{"if (true) { const x = 1 } x = 2", "1:27"},
+		{"if (true) { const x = 1 } let x = 2", "1:31"},
+		{"let f = fn() { x = 2 }; const x = 1; f()", "1:16"},
+		{"let i = 0; while (i < 2) { const x = i; i += 1; } i", "1:34"},
+		{"1.5 + true", "1:5"},
+		{"[1][1.0]", "1:4"},
+		{"for (x in 5) { x }", "1:11"},
+		{"while (1 + true) { 1 }", "1:10"},
+		{"while (true) { let f = fn() { break }; f() }", "1:40"},
+	}
+	for _, tt := range tests {
+		evaluated := testEval(tt.input)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+			continue
+		}
+		if errObj.Pos.String() != tt.expectedPos {
+			t.Errorf("wrong error position for %q. expected=%s, got=%s",
+				tt.input, tt.expectedPos, errObj.Pos)
+		}
+	}
+}
+
+func TestLimits(t *testing.T) {
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		input    string
+		ctx      context.Context
+		limits   Limits
+		expected string
+	}{
+		{"fn(x) { x(x) }(fn(x) { x(x) })", context.Background(), Limits{MaxDepth: 100},
+			"1:24: call depth limit exceeded"},
+		{"while (true) {}", context.Background(), Limits{MaxSteps: 1000},
+			"1:8: step limit exceeded"},
+		{`let s = "ab"; while (true) { s = s + s }`, context.Background(), Limits{MaxAllocations: 1 << 20},
+			"1:36: allocation limit exceeded"},
+		{`"x" * 100000000`, context.Background(), Limits{MaxAllocations: 1 << 20},
+			"1:5: allocation limit exceeded"},
+		{"range(10000000)", context.Background(), Limits{MaxAllocations: 1 << 20},
+			"1:1: allocation limit exceeded"},
+		{"concat(range(1000), range(1000))", context.Background(), Limits{MaxAllocations: 2500},
+			"1:1: allocation limit exceeded"},
+		{"1 + 2", canceled, Limits{},
+			"1:1: execution stopped: context canceled"},
+		{"try { while (true) {} } catch { 1 } finally { 2 }", context.Background(), Limits{MaxSteps: 1000},
+			"1:20: step limit exceeded"},
+	}
+	for _, tt := range tests {
+		program := parser.New(lexer.New(tt.input)).ParseProgram()
+		evaluated := EvalContext(tt.ctx, program, object.NewEnvironment(), tt.limits)
+		errObj, ok := evaluated.(*object.Error)
+		if !ok {
+			t.Errorf("%s: no error object returned. got=%T(%+v)", tt.input, evaluated, evaluated)
+			continue
+		}
+		if errObj.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.input, tt.expected, errObj.Error())
+		}
+		if errObj.Kind != object.LimitExceeded {
+			t.Errorf("%s: wrong error kind. want=%q, got=%q", tt.input, object.LimitExceeded, errObj.Kind)
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+	program := parser.New(lexer.New("while (true) {}")).ParseProgram()
+	evaluated := EvalContext(ctx, program, object.NewEnvironment(), Limits{})
+	if errObj, ok := evaluated.(*object.Error); !ok || errObj.Kind != object.LimitExceeded {
+		t.Errorf("timeout did not stop the program. got=%T(%+v)", evaluated, evaluated)
+	}
+
+	evaluated = EvalContext(context.Background(), parser.New(lexer.New(
+		"let f = fn(n) { if (n == 0) { 0 } else { n + f(n - 1) } }; f(50)")).ParseProgram(),
+		object.NewEnvironment(), Limits{MaxDepth: 60, MaxSteps: 10000, MaxAllocations: 10000})
+	testIntegerObject(t, evaluated, 1275)
+}
+
+func TestStackOverflow(t *testing.T) {
+	evaluated := testEval("fn(x) { x(x) }(fn(x) { x(x) })")
+	errObj, ok := evaluated.(*object.Error)
+	if !ok {
+		t.Fatalf("no error object returned. got=%T(%+v)", evaluated, evaluated)
+	}
+	if errObj.Message != "stack overflow" {
+		t.Errorf("wrong error message. want=%q, got=%q", "stack overflow", errObj.Message)
+	}
+	if len(errObj.Trace) != DefaultMaxDepth-1 {
+		t.Errorf("wrong trace length. want=%d, got=%d", DefaultMaxDepth-1, len(errObj.Trace))
+	}
+}
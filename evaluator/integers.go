@@ -0,0 +1,141 @@
+package evaluator
+
+import (
+	"math"
+	"math/big"
+	"monkey/object"
+)
+
+// evalIntegerArithmetic applies an arithmetic operator to two integers. A
+// result that does not fit in 64 bits is handled as overflow says.
+func evalIntegerArithmetic(operator string, x, y int64, overflow object.Overflow) object.Object {
+	if y == 0 && (operator == "/" || operator == "%") {
+		return divisionByZero(operator)
+	}
+	result, exact := wrappingArithmetic(operator, x, y)
+	switch {
+	case exact || overflow == object.OverflowWrap:
+		return &object.Integer{Value: result}
+	case overflow == object.OverflowChecked:
+		return newError("integer overflow: %d %s %d", x, operator, y)
+	default:
+		return evalBigIntArithmetic(operator, big.NewInt(x), big.NewInt(y))
+	}
+}
+
+// wrappingArithmetic returns x operator y wrapped around to 64 bits, and
+// whether that is the exact result.
+func wrappingArithmetic(operator string, x, y int64) (int64, bool) {
+	switch operator {
+	case "+":
+		r := x + y
+		return r, (x^r)&(y^r) >= 0
+	case "-":
+		r := x - y
+		return r, (x^y)&(x^r) >= 0
+	case "*":
+		r := x * y
+		return r, x == 0 || (r/x == y && !(x == -1 && y == math.MinInt64))
+	case "/":
+		return x / y, !(x == math.MinInt64 && y == -1)
+	default:
+		return x % y, true
+	}
+}
+
+func evalIntegerNegation(x int64, overflow object.Overflow) object.Object {
+	switch {
+	case x != math.MinInt64 || overflow == object.OverflowWrap:
+		return &object.Integer{Value: -x}
+	case overflow == object.OverflowChecked:
+		return newError("integer overflow: -(%d)", x)
+	default:
+		return integerObject(new(big.Int).Neg(big.NewInt(x)))
+	}
+}
+
+func evalBigIntInfixExpression(operator string, left, right object.Object) object.Object {
+	x, y := toBigInt(left), toBigInt(right)
+	switch operator {
+	case "+", "-", "*", "/", "%":
+		return evalBigIntArithmetic(operator, x, y)
+	case "<":
+		return nativeBoolToBooleanObject(x.Cmp(y) < 0)
+	case ">":
+		return nativeBoolToBooleanObject(x.Cmp(y) > 0)
+	case "==":
+		return nativeBoolToBooleanObject(x.Cmp(y) == 0)
+	case "!=":
+		return nativeBoolToBooleanObject(x.Cmp(y) != 0)
+	default:
+		return newError("unknown operator %s %s %s", left.Type(), operator, right.Type())
+	}
+}
+
+// evalBigIntArithmetic applies an arithmetic operator exactly. Division
+// truncates towards zero like it does for Integers.
+func evalBigIntArithmetic(operator string, x, y *big.Int) object.Object {
+	z := new(big.Int)
+	switch operator {
+	case "+":
+		z.Add(x, y)
+	case "-":
+		z.Sub(x, y)
+	case "*":
+		z.Mul(x, y)
+	case "/", "%":
+		if y.Sign() == 0 {
+			return divisionByZero(operator)
+		}
+		if operator == "/" {
+			z.Quo(x, y)
+		} else {
+			z.Rem(x, y)
+		}
+	}
+	return integerObject(z)
+}
+
+func divisionByZero(operator string) *object.Error {
+	if operator == "%" {
+		return newError("modulo by zero")
+	}
+	return newError("division by zero")
+}
+
+// integerObject returns z as an Integer if it fits in one, and as a BigInt
+// otherwise.
+func integerObject(z *big.Int) object.Object {
+	if z.IsInt64() {
+		return &object.Integer{Value: z.Int64()}
+	}
+	return &object.BigInt{Value: z}
+}
+
+func isInteger(obj object.Object) bool {
+	return obj.Type() == object.IntegerObj || obj.Type() == object.BigIntObj
+}
+
+// indexValue returns an INTEGER or BIGINT index as an int64. A BIGINT lies
+// beyond every position, so it becomes the int64 bound of its sign and
+// behaves like any other index out of range.
+func indexValue(index object.Object) (int64, bool) {
+	switch index := index.(type) {
+	case *object.Integer:
+		return index.Value, true
+	case *object.BigInt:
+		if index.Value.Sign() < 0 {
+			return math.MinInt64, true
+		}
+		return math.MaxInt64, true
+	default:
+		return 0, false
+	}
+}
+
+func toBigInt(obj object.Object) *big.Int {
+	if i, ok := obj.(*object.Integer); ok {
+		return big.NewInt(i.Value)
+	}
+	return obj.(*object.BigInt).Value
+}
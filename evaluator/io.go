@@ -0,0 +1,191 @@
+package evaluator
+
+import (
+	"fmt"
+	"io"
+	"monkey/object"
+	"strings"
+	"unicode/utf8"
+)
+
+// builtinPuts prints each of its arguments on a line of its own.
+func builtinPuts(rt object.Runtime, args ...object.Object) object.Object {
+	var out strings.Builder
+	for _, arg := range args {
+		out.WriteString(arg.Inspect())
+		out.WriteByte('\n')
+	}
+	if len(args) == 0 {
+		out.WriteByte('\n')
+	}
+	return writeOutput(rt, out.String())
+}
+
+// builtinPrint prints its arguments separated by spaces, without ending
+// the line.
+func builtinPrint(rt object.Runtime, args ...object.Object) object.Object {
+	strs := make([]string, len(args))
+	for i, arg := range args {
+		strs[i] = arg.Inspect()
+	}
+	return writeOutput(rt, strings.Join(strs, " "))
+}
+
+// builtinPrintf prints its arguments formatted like format does.
+func builtinPrintf(rt object.Runtime, args ...object.Object) object.Object {
+	s, err := formatArguments("printf", args)
+	if err != nil {
+		return err
+	}
+	return writeOutput(rt, s)
+}
+
+// builtinFormat returns its arguments formatted by the format string that
+// comes first. The verbs are those of Go's fmt package: %v and %s format
+// any value as puts prints it, %q quotes it, %d, %x, %X, %o, %b and %c
+// format integers, %e, %E, %f, %F, %g and %G format numbers and %t
+// formats booleans. Verbs take the flags, width and precision of fmt.
+func builtinFormat(_ object.Runtime, args ...object.Object) object.Object {
+	s, err := formatArguments("format", args)
+	if err != nil {
+		return err
+	}
+	return &object.String{Value: s}
+}
+
+// builtinInput reads a line from the input and returns it without the line
+// ending, or null at the end of the input. input(prompt) prints prompt
+// first.
+func builtinInput(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 0, 1); err != nil {
+		return err
+	}
+	if len(args) == 1 {
+		prompt, err := stringArgument("input", args, 0)
+		if err != nil {
+			return err
+		}
+		if _, err := io.WriteString(rt.IO().Out, prompt); err != nil {
+			return newError("cannot write output: %s", err)
+		}
+	}
+	line, err := rt.IO().In.ReadString('\n')
+	if err != nil && err != io.EOF {
+		return newError("cannot read input: %s", err)
+	}
+	if err == io.EOF && line == "" {
+		return Null
+	}
+	line = strings.TrimSuffix(line, "\n")
+	line = strings.TrimSuffix(line, "\r")
+	return &object.String{Value: line}
+}
+
+// writeOutput writes s to the output of the program and returns null, or
+// the error writing failed with.
+func writeOutput(rt object.Runtime, s string) object.Object {
+	if _, err := io.WriteString(rt.IO().Out, s); err != nil {
+		return newError("cannot write output: %s", err)
+	}
+	return Null
+}
+
+// formatArguments formats the arguments of a call to the builtin name by
+// the format string that comes first.
+func formatArguments(name string, args []object.Object) (string, *object.Error) {
+	if err := checkArguments(args, 1, -1); err != nil {
+		return "", err
+	}
+	format, err := stringArgument(name, args, 0)
+	if err != nil {
+		return "", err
+	}
+
+	var out strings.Builder
+	next := 1
+	for i := 0; i < len(format); i++ {
+		if format[i] != '%' {
+			out.WriteByte(format[i])
+			continue
+		}
+		end := verbEnd(format, i+1)
+		if end == len(format) {
+			return "", newError("incomplete verb at the end of the format of `%s`", name)
+		}
+		_, width := utf8.DecodeRuneInString(format[end:])
+		spec := format[i : end+width]
+		i = end + width - 1
+		if format[end] == '%' {
+			out.WriteByte('%')
+			continue
+		}
+		if next == len(args) {
+			return "", newError("missing argument for %s in the format of `%s`", spec, name)
+		}
+		value, err := formatValue(name, spec, next, args[next])
+		if err != nil {
+			return "", err
+		}
+		fmt.Fprintf(&out, spec, value)
+		next++
+	}
+	if next < len(args) {
+		return "", newError("too many arguments to `%s`: the format uses %d", name, next-1)
+	}
+	return out.String(), nil
+}
+
+// verbEnd returns the offset of the verb letter of the verb whose flags,
+// width and precision start at offset i of format, or len(format) if the
+// format ends before it.
+func verbEnd(format string, i int) int {
+	for i < len(format) && strings.IndexByte("+-# 0", format[i]) >= 0 {
+		i++
+	}
+	for i < len(format) && isASCIIDigit(format[i]) {
+		i++
+	}
+	if i < len(format) && format[i] == '.' {
+		i++
+		for i < len(format) && isASCIIDigit(format[i]) {
+			i++
+		}
+	}
+	return i
+}
+
+func isASCIIDigit(b byte) bool {
+	return '0' <= b && b <= '9'
+}
+
+// formatValue returns the Go value that the verb spec formats for arg,
+// which is argument i of a call to the builtin name.
+func formatValue(name, spec string, i int, arg object.Object) (interface{}, *object.Error) {
+	switch verb := spec[len(spec)-1]; verb {
+	case 'v', 's', 'q':
+		return arg.Inspect(), nil
+	case 'd', 'x', 'X', 'o', 'b', 'c':
+		switch arg := arg.(type) {
+		case *object.Integer:
+			return arg.Value, nil
+		case *object.BigInt:
+			if verb != 'c' {
+				return arg.Value, nil
+			}
+		}
+		return nil, argumentError(name, i, object.IntegerObj, arg)
+	case 'e', 'E', 'f', 'F', 'g', 'G':
+		if !isNumber(arg) {
+			return nil, argumentError(name, i, "INTEGER or FLOAT", arg)
+		}
+		return toFloat(arg).Value, nil
+	case 't':
+		boolean, ok := arg.(*object.Boolean)
+		if !ok {
+			return nil, argumentError(name, i, object.BooleanObj, arg)
+		}
+		return boolean.Value, nil
+	default:
+		return nil, newError("unknown verb %s in the format of `%s`", spec, name)
+	}
+}
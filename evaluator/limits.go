@@ -0,0 +1,187 @@
+package evaluator
+
+import (
+	"context"
+	"monkey/ast"
+	"monkey/object"
+)
+
+// DefaultMaxDepth bounds how deeply function calls may nest in an
+// environment without a bound set by SetMaxDepth, so that runaway recursion
+// fails with a stack overflow error instead of overflowing the Go stack.
+// Like vm.MaxFrames, the bound counts the main program as one call.
+const DefaultMaxDepth = 1024
+
+// Limits bounds the resources a program run by EvalContext may use. A zero
+// field leaves that resource unlimited.
+type Limits struct {
+	// MaxSteps bounds the number of evaluation steps, about one for each
+	// node of the program evaluated.
+	MaxSteps int64
+	// MaxDepth bounds how deeply function calls may nest. Even when it is
+	// zero, calls nesting beyond the bound of the environment fail with a
+	// stack overflow, see DefaultMaxDepth.
+	MaxDepth int
+	// MaxAllocations bounds the size of the values the program creates,
+	// counting one for each value plus one for each array element, hash
+	// pair, byte of a string and word of a big integer.
+	MaxAllocations int64
+}
+
+// contextCheckInterval is the number of steps between two checks whether
+// the context of a program is done.
+const contextCheckInterval = 1024
+
+// EvalContext is like Eval, but stops with an error of kind
+// object.LimitExceeded as soon as the program exceeds limits or ctx is
+// done. The limits also apply to functions defined in earlier programs and
+// called by this one.
+func EvalContext(ctx context.Context, node ast.Node, env *object.Environment, limits Limits) object.Object {
+	if err := ctx.Err(); err != nil {
+		return withPosition(contextError(err), node.Pos())
+	}
+	previous := env.Meter()
+	env.SetMeter(&meter{ctx: ctx, limits: limits})
+	defer env.SetMeter(previous)
+	return Eval(node, env)
+}
+
+// meter enforces Limits on a program.
+type meter struct {
+	ctx         context.Context
+	limits      Limits
+	steps       int64
+	depth       int
+	allocations int64
+}
+
+func (m *meter) Step() *object.Error {
+	m.steps++
+	if m.limits.MaxSteps > 0 && m.steps > m.limits.MaxSteps {
+		return limitError("step limit exceeded")
+	}
+	if m.steps%contextCheckInterval == 0 {
+		if err := m.ctx.Err(); err != nil {
+			return contextError(err)
+		}
+	}
+	return nil
+}
+
+func (m *meter) Call() *object.Error {
+	if m.limits.MaxDepth > 0 && m.depth >= m.limits.MaxDepth {
+		return limitError("call depth limit exceeded")
+	}
+	m.depth++
+	return nil
+}
+
+func (m *meter) Return() {
+	m.depth--
+}
+
+func (m *meter) Allocate(size int64) *object.Error {
+	m.allocations += size
+	if m.limits.MaxAllocations > 0 && m.allocations > m.limits.MaxAllocations {
+		return limitError("allocation limit exceeded")
+	}
+	return nil
+}
+
+// maxDepth returns the bound on the depth of calls made from env, or from
+// fnEnv, the environment of the function called, if env is nil.
+func maxDepth(env, fnEnv *object.Environment) int {
+	if env == nil {
+		env = fnEnv
+	}
+	if depth := env.MaxDepth(); depth > 0 {
+		return depth
+	}
+	return DefaultMaxDepth
+}
+
+func limitError(message string) *object.Error {
+	return &object.Error{Message: message, Kind: object.LimitExceeded}
+}
+
+func contextError(err error) *object.Error {
+	return limitError("execution stopped: " + err.Error())
+}
+
+// allocate reports obj, a value just created, to the meter of env. It
+// returns obj, or the error of the meter if the program ran out of
+// allocations.
+func allocate(env *object.Environment, obj object.Object) object.Object {
+	if obj == nil || isError(obj) {
+		return obj
+	}
+	if err := charge(env, sizeOf(obj)); err != nil {
+		return err
+	}
+	return obj
+}
+
+func sizeOf(obj object.Object) int64 {
+	switch obj := obj.(type) {
+	case *object.String:
+		return 1 + int64(len(obj.Value))
+	case *object.BigInt:
+		return 1 + int64(len(obj.Value.Bits()))
+	case *object.Array:
+		return 1 + int64(len(obj.Elements))
+	case *object.Hash:
+		return 1 + int64(obj.Len())
+	default:
+		return 1
+	}
+}
+
+// charge reports size to the meter of env, before creating a value that
+// may be too large to create at all, and returns the error of the meter if
+// the program ran out of allocations.
+func charge(env *object.Environment, size int64) *object.Error {
+	m := env.Meter()
+	if m == nil {
+		return nil
+	}
+	return m.Allocate(size)
+}
+
+// infixOperation applies an infix operator and reports the value it
+// creates to the meter of env. Strings built by concatenation and
+// repetition are charged before they are built.
+func infixOperation(operator string, left, right object.Object, env *object.Environment) object.Object {
+	size := stringResultSize(operator, left, right)
+	if size == 0 {
+		return allocate(env, evalInfixExpression(operator, left, right, env.Overflow()))
+	}
+	if err := charge(env, size); err != nil {
+		return err
+	}
+	return evalInfixExpression(operator, left, right, env.Overflow())
+}
+
+// stringResultSize returns the size of the string that operator creates
+// from left and right, or 0 if it does not create one.
+func stringResultSize(operator string, left, right object.Object) int64 {
+	if _, ok := right.(*object.String); ok && operator == "*" {
+		left, right = right, left
+	}
+	str, ok := left.(*object.String)
+	if !ok {
+		return 0
+	}
+	switch right := right.(type) {
+	case *object.String:
+		if operator == "+" {
+			return 1 + int64(len(str.Value)) + int64(len(right.Value))
+		}
+	case *object.Integer:
+		// Longer strings are not built at all, see repeatString.
+		n := int64(len(str.Value))
+		if operator == "*" && right.Value >= 0 && (n == 0 || right.Value <= maxRepeatLength/n) {
+			return 1 + n*right.Value
+		}
+	}
+	return 0
+}
@@ -0,0 +1,97 @@
+package evaluator
+
+import (
+	"monkey/object"
+	"monkey/token"
+	"os"
+	"sort"
+)
+
+// The functions in this file expose the runtime semantics of Eval to the
+// bytecode VM, so that both backends agree on the result and error message
+// of every operation.
+
+// InfixOperation applies a binary operator like an infix expression does,
+// handling integer overflow as overflow says.
+func InfixOperation(operator string, left, right object.Object, overflow object.Overflow) object.Object {
+	return evalInfixExpression(operator, left, right, overflow)
+}
+
+// PrefixOperation applies a unary operator like a prefix expression does.
+func PrefixOperation(operator string, right object.Object, overflow object.Overflow) object.Object {
+	return evalPrefixExpression(operator, right, overflow)
+}
+
+// IndexOperation evaluates left[index].
+func IndexOperation(left, index object.Object) object.Object {
+	return evalIndexExpression(left, index)
+}
+
+// SliceOperation evaluates left[low:high], where a null bound stands for
+// an omitted one.
+func SliceOperation(left, low, high object.Object) object.Object {
+	return evalSliceExpression(left, low, high)
+}
+
+// Iterate returns the elements a for loop visits in obj.
+func Iterate(obj object.Object) ([]object.Object, *object.Error) {
+	return iterate(obj)
+}
+
+// SetIndexOperation evaluates left[index] = val.
+func SetIndexOperation(left, index, val object.Object) object.Object {
+	return evalSetIndex(left, index, val)
+}
+
+// IsTruthy reports whether obj counts as true in a condition.
+func IsTruthy(obj object.Object) bool {
+	return isTruthy(obj)
+}
+
+// NativeBool returns the shared True or False object.
+func NativeBool(input bool) *object.Boolean {
+	return nativeBoolToBooleanObject(input)
+}
+
+// NewError creates an error object the same way Eval does.
+func NewError(format string, a ...interface{}) *object.Error {
+	return newError(format, a...)
+}
+
+// BuiltinNames returns the names of all builtin functions in a stable order.
+// The bytecode compiler and VM refer to a builtin by its index in it.
+func BuiltinNames() []string {
+	names := make([]string, 0, len(builtins))
+	for name := range builtins {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// LookupBuiltin returns the builtin function called name.
+func LookupBuiltin(name string) (*object.Builtin, bool) {
+	builtin, ok := builtins[name]
+	return builtin, ok
+}
+
+// ApplyFunction calls fn, a function or builtin, with args. It lets Go code
+// call back into functions defined by a program. Tracebacks show no
+// position for the call.
+func ApplyFunction(fn object.Object, args []object.Object) object.Object {
+	return applyFunction(fn, args, nil, token.Position{})
+}
+
+// Stdio is the IO of programs that are not given another one: the standard
+// input and output of the process.
+var Stdio = object.NewIO(os.Stdin, os.Stdout)
+
+// Throw returns the error that throw raises for val.
+func Throw(val object.Object) *object.Error {
+	return throwError(val)
+}
+
+// CaughtError returns the value catch binds for err.
+func CaughtError(err *object.Error) object.Object {
+	return caughtError(err)
+}
@@ -0,0 +1,211 @@
+package evaluator
+
+import (
+	"monkey/ast"
+	"monkey/object"
+	"strings"
+	"unicode/utf8"
+)
+
+// maxRepeatLength bounds the length in bytes of a string made by
+// repetition, which could otherwise exhaust memory in a single step.
+const maxRepeatLength = 1 << 30
+
+// evalStringIndexExpression looks up a char by position, counting chars
+// rather than bytes. Negative indices count from the end, and indices
+// outside the string evaluate to null.
+func evalStringIndexExpression(str, index object.Object) object.Object {
+	s := str.(*object.String).Value
+	i, ok := indexValue(index)
+	if !ok {
+		return newError("string index must be INTEGER, got %s", index.Type())
+	}
+	if i < 0 {
+		i += int64(utf8.RuneCountInString(s))
+	}
+	if i < 0 {
+		return Null
+	}
+	for _, r := range s {
+		if i == 0 {
+			return &object.String{Value: string(r)}
+		}
+		i--
+	}
+	return Null
+}
+
+func evalSliceNode(node *ast.SliceExpression, env *object.Environment) object.Object {
+	left := Eval(node.Left, env)
+	if isError(left) {
+		return left
+	}
+	bounds := [2]object.Object{Null, Null}
+	for i, bound := range []ast.Expression{node.Low, node.High} {
+		if bound == nil {
+			continue
+		}
+		bounds[i] = Eval(bound, env)
+		if isError(bounds[i]) {
+			return bounds[i]
+		}
+	}
+	return withPosition(allocate(env, evalSliceExpression(left, bounds[0], bounds[1])), node.Token.Pos)
+}
+
+// evalSliceExpression returns the elements of an array, or the chars of a
+// string, from low up to but not including high. A null bound is omitted
+// and stands for the start or the end. Negative bounds count from the end,
+// and bounds outside the collection are moved to its nearest end.
+func evalSliceExpression(left, low, high object.Object) object.Object {
+	switch left := left.(type) {
+	case *object.Array:
+		start, end, err := sliceBounds(low, high, len(left.Elements))
+		if err != nil {
+			return err
+		}
+		elements := make([]object.Object, end-start)
+		copy(elements, left.Elements[start:end])
+		return &object.Array{Elements: elements}
+	case *object.String:
+		runes := []rune(left.Value)
+		start, end, err := sliceBounds(low, high, len(runes))
+		if err != nil {
+			return err
+		}
+		return &object.String{Value: string(runes[start:end])}
+	default:
+		return newError("slice operator not supported: %s", left.Type())
+	}
+}
+
+func sliceBounds(low, high object.Object, length int) (int, int, *object.Error) {
+	start, err := sliceBound(low, 0, length)
+	if err != nil {
+		return 0, 0, err
+	}
+	end, err := sliceBound(high, length, length)
+	if err != nil {
+		return 0, 0, err
+	}
+	if end < start {
+		end = start
+	}
+	return start, end, nil
+}
+
+func sliceBound(bound object.Object, omitted, length int) (int, *object.Error) {
+	if bound == Null {
+		return omitted, nil
+	}
+	i, ok := indexValue(bound)
+	if !ok {
+		return 0, newError("slice index must be INTEGER, got %s", bound.Type())
+	}
+	if i < 0 {
+		i += int64(length)
+	}
+	switch {
+	case i < 0:
+		return 0, nil
+	case i > int64(length):
+		return length, nil
+	default:
+		return int(i), nil
+	}
+}
+
+// repeatString returns str repeated count times.
+func repeatString(str *object.String, count *object.Integer) object.Object {
+	if count.Value < 0 {
+		return newError("negative repeat count: %d", count.Value)
+	}
+	if len(str.Value) > 0 && count.Value > maxRepeatLength/int64(len(str.Value)) {
+		return newError("repeated string too long: %d * %d bytes", count.Value, len(str.Value))
+	}
+	return &object.String{Value: strings.Repeat(str.Value, int(count.Value))}
+}
+
+// builtinByteLen returns the length of a string in bytes of its UTF-8
+// encoding, where len counts chars.
+func builtinByteLen(_ object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	s, err := stringArgument("bytelen", args, 0)
+	if err != nil {
+		return err
+	}
+	return &object.Integer{Value: int64(len(s))}
+}
+
+// builtinBytes returns the bytes of the UTF-8 encoding of a string as an
+// array of integers.
+func builtinBytes(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	s, err := stringArgument("bytes", args, 0)
+	if err != nil {
+		return err
+	}
+	if err := reserve(rt, len(s)); err != nil {
+		return err
+	}
+	elements := make([]object.Object, len(s))
+	for i := 0; i < len(s); i++ {
+		elements[i] = &object.Integer{Value: int64(s[i])}
+	}
+	return &object.Array{Elements: elements}
+}
+
+// builtinChars splits a string into an array of one-char strings. Bytes
+// that are not valid UTF-8 become U+FFFD.
+func builtinChars(rt object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	s, err := stringArgument("chars", args, 0)
+	if err != nil {
+		return err
+	}
+	if err := reserve(rt, utf8.RuneCountInString(s)); err != nil {
+		return err
+	}
+	elements := make([]object.Object, 0, utf8.RuneCountInString(s))
+	for _, r := range s {
+		elements = append(elements, &object.String{Value: string(r)})
+	}
+	return &object.Array{Elements: elements}
+}
+
+// builtinOrd returns the code point of a one-char string.
+func builtinOrd(_ object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	s, err := stringArgument("ord", args, 0)
+	if err != nil {
+		return err
+	}
+	r, size := utf8.DecodeRuneInString(s)
+	if size == 0 || size != len(s) {
+		return newError("argument 1 to `ord` must be a single char, got %q", s)
+	}
+	return &object.Integer{Value: int64(r)}
+}
+
+// builtinChr returns the one-char string for a code point.
+func builtinChr(_ object.Runtime, args ...object.Object) object.Object {
+	if err := checkArguments(args, 1, 1); err != nil {
+		return err
+	}
+	code, err := integerArgument("chr", args, 0)
+	if err != nil {
+		return err
+	}
+	if code < 0 || code > utf8.MaxRune || !utf8.ValidRune(rune(code)) {
+		return newError("invalid code point %d", code)
+	}
+	return &object.String{Value: string(rune(code))}
+}
@@ -0,0 +1,523 @@
+package interpreter
+
+import (
+	"fmt"
+	"math/big"
+	"monkey/evaluator"
+	"monkey/object"
+	"reflect"
+	"sort"
+)
+
+var (
+	objectType = reflect.TypeOf((*object.Object)(nil)).Elem()
+	errorType  = reflect.TypeOf((*error)(nil)).Elem()
+	bigIntType = reflect.TypeOf(big.Int{})
+)
+
+// ToObject converts a Go value to the Monkey object a program sees:
+//
+//   - nil, nil pointers and nil functions become null
+//   - bools, integers, floats and strings become BOOLEAN, INTEGER, FLOAT
+//     and STRING; integers outside the range of int64, including big.Ints,
+//     become BIGINT
+//   - slices and arrays become arrays, maps become hashes
+//   - structs become hashes from field name to value, including their
+//     exported methods as builtins; the tag `monkey:"name"` renames a field
+//     and `monkey:"-"` hides it
+//   - pointers become what they point to
+//   - errors become error objects
+//   - functions become builtins, see below
+//   - objects are used as they are
+//
+// A builtin made from a Go function converts its arguments with the rules
+// of FromObject and fails if a conversion does. It returns null for no
+// results, the converted result for one and an array for several. A final
+// error result is not converted: a non-nil error fails the call with its
+// message, and is dropped otherwise. Panics fail the call, too.
+func ToObject(value interface{}) (object.Object, error) {
+	return toObject("", value)
+}
+
+// FromObject stores obj in the Go value ptr points to, converting it the
+// opposite way of ToObject. INTEGER and BIGINT convert to big.Int and float
+// types as well, and a hash converts to a struct by field name. Any type
+// that obj is assignable to, such as object.Object, receives obj as it is.
+// Functions and builtins convert to Go functions that call them; if such a
+// call fails, the Go function returns the error if its type has a final
+// error result and panics otherwise.
+//
+// A value of type interface{} receives the natural Go representation of
+// obj: int64, *big.Int, float64, string, bool, nil, []interface{} or
+// map[interface{}]interface{}, or obj itself for other types. An array or
+// hash that contains itself cannot be converted.
+func FromObject(obj object.Object, ptr interface{}) error {
+	target := reflect.ValueOf(ptr)
+	if target.Kind() != reflect.Ptr || target.IsNil() {
+		return fmt.Errorf("FromObject needs a non-nil pointer, got %T", ptr)
+	}
+	value, err := fromObject(obj, target.Type().Elem())
+	if err != nil {
+		return err
+	}
+	target.Elem().Set(value)
+	return nil
+}
+
+// toObject is ToObject for a value bound to name, which builtins made from
+// functions use in their error messages.
+func toObject(name string, value interface{}) (object.Object, error) {
+	if value == nil {
+		return evaluator.Null, nil
+	}
+	return valueToObject(name, reflect.ValueOf(value))
+}
+
+func valueToObject(name string, v reflect.Value) (object.Object, error) {
+	return convertValue(name, v, map[visit]bool{})
+}
+
+// visit identifies a map, slice or pointer being converted.
+type visit struct {
+	ptr uintptr
+	typ reflect.Type
+	len int
+}
+
+// enter marks v, a map, slice or pointer, as being converted. It returns a
+// function that removes the mark, or an error if v is marked already, that
+// is, if v refers to itself.
+func enter(v reflect.Value, visiting map[visit]bool) (func(), error) {
+	key := visit{ptr: v.Pointer(), typ: v.Type()}
+	if v.Kind() == reflect.Slice {
+		key.len = v.Len()
+	}
+	if visiting[key] {
+		return nil, fmt.Errorf("cannot convert %s: it refers to itself", v.Type())
+	}
+	visiting[key] = true
+	return func() { delete(visiting, key) }, nil
+}
+
+// convertValue is valueToObject for a value inside the values marked in
+// visiting.
+func convertValue(name string, v reflect.Value, visiting map[visit]bool) (object.Object, error) {
+	if v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return evaluator.Null, nil
+		}
+		v = v.Elem()
+	}
+	if isNil(v) {
+		return evaluator.Null, nil
+	}
+	if v.Type().Implements(objectType) {
+		return v.Interface().(object.Object), nil
+	}
+	if v.Type().Implements(errorType) {
+		return &object.Error{Message: v.Interface().(error).Error()}, nil
+	}
+
+	switch v.Kind() {
+	case reflect.Bool:
+		return evaluator.NativeBool(v.Bool()), nil
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		return &object.Integer{Value: v.Int()}, nil
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return bigIntToObject(new(big.Int).SetUint64(v.Uint())), nil
+	case reflect.Float32, reflect.Float64:
+		return &object.Float{Value: v.Float()}, nil
+	case reflect.String:
+		return &object.String{Value: v.String()}, nil
+	case reflect.Slice, reflect.Array:
+		if v.Kind() == reflect.Slice {
+			leave, err := enter(v, visiting)
+			if err != nil {
+				return nil, err
+			}
+			defer leave()
+		}
+		elements := make([]object.Object, v.Len())
+		for i := range elements {
+			element, err := convertValue(name, v.Index(i), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("element %d: %w", i, err)
+			}
+			elements[i] = element
+		}
+		return &object.Array{Elements: elements}, nil
+	case reflect.Map:
+		leave, err := enter(v, visiting)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		hash := object.NewHash(v.Len())
+		for _, key := range sortedKeys(v) {
+			keyObj, err := convertValue(name, key, visiting)
+			if err != nil {
+				return nil, err
+			}
+			value, err := convertValue(name, v.MapIndex(key), visiting)
+			if err != nil {
+				return nil, fmt.Errorf("key %s: %w", keyObj.Inspect(), err)
+			}
+			if err := setPair(hash, keyObj, value); err != nil {
+				return nil, err
+			}
+		}
+		return hash, nil
+	case reflect.Ptr:
+		leave, err := enter(v, visiting)
+		if err != nil {
+			return nil, err
+		}
+		defer leave()
+		if v.Elem().Kind() == reflect.Struct && v.Elem().Type() != bigIntType {
+			// Keep the pointer, whose method set includes the methods
+			// with pointer receivers.
+			return structToObject(v, visiting)
+		}
+		return convertValue(name, v.Elem(), visiting)
+	case reflect.Struct:
+		if v.Type() == bigIntType {
+			x := reflect.New(bigIntType)
+			x.Elem().Set(v)
+			return bigIntToObject(new(big.Int).Set(x.Interface().(*big.Int))), nil
+		}
+		return structToObject(v, visiting)
+	case reflect.Func:
+		return funcToBuiltin(name, v), nil
+	default:
+		return nil, fmt.Errorf("cannot convert %s to a Monkey value", v.Type())
+	}
+}
+
+// bigIntToObject returns x, which the caller must not modify afterwards, as
+// an INTEGER if it fits in one and as a BIGINT otherwise.
+func bigIntToObject(x *big.Int) object.Object {
+	if x.IsInt64() {
+		return &object.Integer{Value: x.Int64()}
+	}
+	return &object.BigInt{Value: x}
+}
+
+func isNil(v reflect.Value) bool {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Func, reflect.Interface:
+		return v.IsNil()
+	}
+	return false
+}
+
+// structToObject converts a struct, or a pointer to one, to a hash of its
+// fields and methods.
+func structToObject(v reflect.Value, visiting map[visit]bool) (object.Object, error) {
+	hash := &object.Hash{}
+	s := reflect.Indirect(v)
+	for _, field := range structFields(s.Type()) {
+		value, err := convertValue(field.name, s.FieldByIndex(field.index), visiting)
+		if err != nil {
+			return nil, fmt.Errorf("field %s: %w", field.name, err)
+		}
+		setPair(hash, &object.String{Value: field.name}, value)
+	}
+	for i := 0; i < v.NumMethod(); i++ {
+		name := v.Type().Method(i).Name
+		setPair(hash, &object.String{Value: name}, funcToBuiltin(name, v.Method(i)))
+	}
+	return hash, nil
+}
+
+type structField struct {
+	name  string
+	index []int
+}
+
+// structFields returns the exported fields of t under the names programs
+// use for them.
+func structFields(t reflect.Type) []structField {
+	var fields []structField
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if field.PkgPath != "" {
+			continue
+		}
+		name := field.Name
+		if tag, ok := field.Tag.Lookup("monkey"); ok {
+			if tag == "-" {
+				continue
+			}
+			if tag != "" {
+				name = tag
+			}
+		}
+		fields = append(fields, structField{name: name, index: field.Index})
+	}
+	return fields
+}
+
+func setPair(hash *object.Hash, key, value object.Object) error {
+	hashable, ok := key.(object.Hashable)
+	if !ok {
+		return fmt.Errorf("unusable as hash key: %s", key.Type())
+	}
+	hash.Set(hashable, value)
+	return nil
+}
+
+// sortedKeys returns the keys of map v in a stable order, so that converting
+// the same map twice gives the same hash.
+func sortedKeys(v reflect.Value) []reflect.Value {
+	keys := v.MapKeys()
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		switch a.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return a.Int() < b.Int()
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			return a.Uint() < b.Uint()
+		case reflect.Float32, reflect.Float64:
+			return a.Float() < b.Float()
+		case reflect.String:
+			return a.String() < b.String()
+		}
+		return fmt.Sprint(a.Interface()) < fmt.Sprint(b.Interface())
+	})
+	return keys
+}
+
+// fromObject converts obj to a value of type t, as FromObject does.
+func fromObject(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if err := checkCycles(obj, map[object.Object]bool{}); err != nil {
+		return reflect.Value{}, err
+	}
+	return objectToValue(obj, t)
+}
+
+// checkCycles returns an error if obj is an array or hash that contains
+// itself, which objectToValue would convert forever. visiting holds the
+// arrays and hashes obj is inside of.
+func checkCycles(obj object.Object, visiting map[object.Object]bool) error {
+	var children []object.Object
+	switch obj := obj.(type) {
+	case *object.Array:
+		children = obj.Elements
+	case *object.Hash:
+		for _, pair := range obj.Pairs() {
+			children = append(children, pair.Value)
+		}
+	default:
+		return nil
+	}
+	if visiting[obj] {
+		return fmt.Errorf("cannot convert %s: it contains itself", obj.Type())
+	}
+	visiting[obj] = true
+	defer delete(visiting, obj)
+	for _, child := range children {
+		if err := checkCycles(child, visiting); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func objectToValue(obj object.Object, t reflect.Type) (reflect.Value, error) {
+	if t.Kind() == reflect.Interface && t.NumMethod() == 0 {
+		return convertTo(toNative(obj), t), nil
+	}
+	if reflect.TypeOf(obj).AssignableTo(t) {
+		return convertTo(obj, t), nil
+	}
+	if obj == evaluator.Null {
+		switch t.Kind() {
+		case reflect.Ptr, reflect.Slice, reflect.Map, reflect.Interface, reflect.Func:
+			return reflect.Zero(t), nil
+		}
+	}
+
+	v := reflect.New(t).Elem()
+	switch obj := obj.(type) {
+	case *object.Boolean:
+		if t.Kind() == reflect.Bool {
+			v.SetBool(obj.Value)
+			return v, nil
+		}
+	case *object.Integer:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			if v.OverflowInt(obj.Value) {
+				return v, fmt.Errorf("%d overflows %s", obj.Value, t)
+			}
+			v.SetInt(obj.Value)
+			return v, nil
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if obj.Value < 0 || v.OverflowUint(uint64(obj.Value)) {
+				return v, fmt.Errorf("%d overflows %s", obj.Value, t)
+			}
+			v.SetUint(uint64(obj.Value))
+			return v, nil
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(float64(obj.Value))
+			return v, nil
+		case reflect.Struct:
+			if t == bigIntType {
+				v.Set(reflect.ValueOf(big.NewInt(obj.Value)).Elem())
+				return v, nil
+			}
+		}
+	case *object.BigInt:
+		switch t.Kind() {
+		case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+			return v, fmt.Errorf("%s overflows %s", obj.Value, t)
+		case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+			if !obj.Value.IsUint64() || v.OverflowUint(obj.Value.Uint64()) {
+				return v, fmt.Errorf("%s overflows %s", obj.Value, t)
+			}
+			v.SetUint(obj.Value.Uint64())
+			return v, nil
+		case reflect.Float32, reflect.Float64:
+			f, _ := new(big.Float).SetInt(obj.Value).Float64()
+			v.SetFloat(f)
+			return v, nil
+		case reflect.Struct:
+			if t == bigIntType {
+				v.Set(reflect.ValueOf(new(big.Int).Set(obj.Value)).Elem())
+				return v, nil
+			}
+		}
+	case *object.Float:
+		switch t.Kind() {
+		case reflect.Float32, reflect.Float64:
+			v.SetFloat(obj.Value)
+			return v, nil
+		}
+	case *object.String:
+		if t.Kind() == reflect.String {
+			v.SetString(obj.Value)
+			return v, nil
+		}
+	case *object.Array:
+		switch t.Kind() {
+		case reflect.Slice:
+			v = reflect.MakeSlice(t, len(obj.Elements), len(obj.Elements))
+			return v, setElements(v, obj.Elements)
+		case reflect.Array:
+			if len(obj.Elements) != t.Len() {
+				return v, fmt.Errorf("cannot use ARRAY of length %d as %s",
+					len(obj.Elements), t)
+			}
+			return v, setElements(v, obj.Elements)
+		}
+	case *object.Hash:
+		switch t.Kind() {
+		case reflect.Map:
+			v = reflect.MakeMapWithSize(t, obj.Len())
+			for _, pair := range obj.Pairs() {
+				key, err := objectToValue(pair.Key, t.Key())
+				if err != nil {
+					return v, fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+				}
+				value, err := objectToValue(pair.Value, t.Elem())
+				if err != nil {
+					return v, fmt.Errorf("key %s: %w", pair.Key.Inspect(), err)
+				}
+				v.SetMapIndex(key, value)
+			}
+			return v, nil
+		case reflect.Struct:
+			return v, setFields(v, obj)
+		}
+	case *object.Function, *object.Builtin:
+		if t.Kind() == reflect.Func {
+			return makeFunc(obj, t), nil
+		}
+	}
+	if t.Kind() == reflect.Ptr {
+		elem, err := objectToValue(obj, t.Elem())
+		if err != nil {
+			return v, err
+		}
+		v = reflect.New(t.Elem())
+		v.Elem().Set(elem)
+		return v, nil
+	}
+	return v, fmt.Errorf("cannot use %s as %s", obj.Type(), t)
+}
+
+// convertTo returns x as a value of type t, which x must be assignable to.
+func convertTo(x interface{}, t reflect.Type) reflect.Value {
+	v := reflect.New(t).Elem()
+	if x != nil {
+		v.Set(reflect.ValueOf(x))
+	}
+	return v
+}
+
+func setElements(v reflect.Value, elements []object.Object) error {
+	for i, element := range elements {
+		value, err := objectToValue(element, v.Type().Elem())
+		if err != nil {
+			return fmt.Errorf("element %d: %w", i, err)
+		}
+		v.Index(i).Set(value)
+	}
+	return nil
+}
+
+func setFields(v reflect.Value, hash *object.Hash) error {
+	fields := make(map[string][]int)
+	for _, field := range structFields(v.Type()) {
+		fields[field.name] = field.index
+	}
+	for _, pair := range hash.Pairs() {
+		key, ok := pair.Key.(*object.String)
+		if !ok {
+			return fmt.Errorf("cannot use %s key as field name of %s",
+				pair.Key.Type(), v.Type())
+		}
+		index, ok := fields[key.Value]
+		if !ok {
+			return fmt.Errorf("unknown field %s in %s", key.Value, v.Type())
+		}
+		value, err := objectToValue(pair.Value, v.Type().FieldByIndex(index).Type)
+		if err != nil {
+			return fmt.Errorf("field %s: %w", key.Value, err)
+		}
+		v.FieldByIndex(index).Set(value)
+	}
+	return nil
+}
+
+// toNative returns the Go value an interface{} argument receives for obj.
+func toNative(obj object.Object) interface{} {
+	switch obj := obj.(type) {
+	case *object.Null:
+		return nil
+	case *object.Boolean:
+		return obj.Value
+	case *object.Integer:
+		return obj.Value
+	case *object.BigInt:
+		return new(big.Int).Set(obj.Value)
+	case *object.Float:
+		return obj.Value
+	case *object.String:
+		return obj.Value
+	case *object.Array:
+		elements := make([]interface{}, len(obj.Elements))
+		for i, element := range obj.Elements {
+			elements[i] = toNative(element)
+		}
+		return elements
+	case *object.Hash:
+		pairs := make(map[interface{}]interface{}, obj.Len())
+		for _, pair := range obj.Pairs() {
+			pairs[toNative(pair.Key)] = toNative(pair.Value)
+		}
+		return pairs
+	default:
+		return obj
+	}
+}
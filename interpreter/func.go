@@ -0,0 +1,183 @@
+package interpreter
+
+import (
+	"fmt"
+	"monkey/evaluator"
+	"monkey/object"
+	"reflect"
+)
+
+// funcToBuiltin wraps the Go function fn in a builtin. name is the name
+// programs call it by, for error messages.
+func funcToBuiltin(name string, fn reflect.Value) *object.Builtin {
+	t := fn.Type()
+	return &object.Builtin{Fn: func(_ object.Runtime, args ...object.Object) (result object.Object) {
+		if err := checkArgumentCount(t, len(args)); err != nil {
+			return err
+		}
+		in := make([]reflect.Value, len(args))
+		for i, arg := range args {
+			value, err := fromObject(arg, parameterType(t, i))
+			if err != nil {
+				return evaluator.NewError("argument %d to `%s`: %s", i+1, name, err)
+			}
+			in[i] = value
+		}
+
+		defer func() {
+			if r := recover(); r != nil {
+				if failure, ok := r.(callbackFailure); ok {
+					result = failure.err
+					return
+				}
+				result = evaluator.NewError("panic in `%s`: %v", name, r)
+			}
+		}()
+		return resultsToObject(name, t, fn.Call(in))
+	}}
+}
+
+func checkArgumentCount(t reflect.Type, got int) *object.Error {
+	if t.IsVariadic() {
+		if want := t.NumIn() - 1; got < want {
+			return evaluator.NewError("wrong number of arguments. got=%d, want at least %d",
+				got, want)
+		}
+		return nil
+	}
+	if want := t.NumIn(); got != want {
+		return evaluator.NewError("wrong number of arguments. got=%d, want=%d",
+			got, want)
+	}
+	return nil
+}
+
+// parameterType returns the type of the i-th argument of a call to a
+// function of type t.
+func parameterType(t reflect.Type, i int) reflect.Type {
+	if last := t.NumIn() - 1; t.IsVariadic() && i >= last {
+		return t.In(last).Elem()
+	}
+	return t.In(i)
+}
+
+func resultsToObject(name string, t reflect.Type, results []reflect.Value) object.Object {
+	if n := len(results); n > 0 && t.Out(n-1) == errorType {
+		if err := results[n-1]; !err.IsNil() {
+			if errObj, ok := err.Interface().(*object.Error); ok {
+				return errObj
+			}
+			return evaluator.NewError("%s", err.Interface().(error).Error())
+		}
+		results = results[:n-1]
+	}
+
+	objects := make([]object.Object, len(results))
+	for i, result := range results {
+		obj, err := valueToObject(name, result)
+		if err != nil {
+			return evaluator.NewError("result of `%s`: %s", name, err)
+		}
+		objects[i] = obj
+	}
+	switch len(objects) {
+	case 0:
+		return evaluator.Null
+	case 1:
+		return objects[0]
+	default:
+		return &object.Array{Elements: objects}
+	}
+}
+
+// callbackFailure carries the error of a function a program passed to Go
+// code, out of a Go function type that cannot return it.
+type callbackFailure struct {
+	err *object.Error
+}
+
+// makeFunc returns a Go function of type t that calls the function fn.
+func makeFunc(fn object.Object, t reflect.Type) reflect.Value {
+	return reflect.MakeFunc(t, func(in []reflect.Value) []reflect.Value {
+		if t.IsVariadic() {
+			last := in[len(in)-1]
+			in = in[:len(in)-1]
+			for i := 0; i < last.Len(); i++ {
+				in = append(in, last.Index(i))
+			}
+		}
+		args := make([]object.Object, len(in))
+		for i, value := range in {
+			arg, err := valueToObject("", value)
+			if err != nil {
+				return failCallback(t, evaluator.NewError("argument %d to callback: %s", i+1, err))
+			}
+			args[i] = arg
+		}
+
+		result := evaluator.ApplyFunction(fn, args)
+		if result == nil {
+			result = evaluator.Null
+		}
+		if err, ok := result.(*object.Error); ok {
+			return failCallback(t, err)
+		}
+		out, err := callbackResults(t, result)
+		if err != nil {
+			return failCallback(t, evaluator.NewError("result of callback: %s", err))
+		}
+		return out
+	})
+}
+
+// callbackResults converts the result of a callback to the results of a Go
+// function of type t. Several results are returned as an array.
+func callbackResults(t reflect.Type, result object.Object) ([]reflect.Value, error) {
+	n := t.NumOut()
+	returnsError := n > 0 && t.Out(n-1) == errorType
+	if returnsError {
+		n--
+	}
+
+	var results []object.Object
+	switch n {
+	case 0:
+	case 1:
+		results = []object.Object{result}
+	default:
+		array, ok := result.(*object.Array)
+		if !ok || len(array.Elements) != n {
+			return nil, fmt.Errorf("want an array of %d results, got %s", n, result.Inspect())
+		}
+		results = array.Elements
+	}
+
+	out := make([]reflect.Value, 0, t.NumOut())
+	for i, result := range results {
+		value, err := fromObject(result, t.Out(i))
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, value)
+	}
+	if returnsError {
+		out = append(out, reflect.Zero(errorType))
+	}
+	return out, nil
+}
+
+// failCallback makes a Go function of type t fail with err: it returns err
+// if t has a final error result and panics with a callbackFailure, which
+// the enclosing builtin recovers, otherwise.
+func failCallback(t reflect.Type, err *object.Error) []reflect.Value {
+	n := t.NumOut()
+	if n == 0 || t.Out(n-1) != errorType {
+		panic(callbackFailure{err: err})
+	}
+	out := make([]reflect.Value, n)
+	for i := 0; i < n-1; i++ {
+		out[i] = reflect.Zero(t.Out(i))
+	}
+	out[n-1] = convertTo(err, errorType)
+	return out
+}
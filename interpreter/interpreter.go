@@ -0,0 +1,120 @@
+// Package interpreter embeds Monkey in Go programs. An Interpreter runs
+// programs with the tree-walking evaluator, and Define exposes Go values and
+// functions to them without hand-written builtins:
+//
+//	in := interpreter.New()
+//	in.Define("greet", func(name string) string { return "hello " + name })
+//	result, err := in.Eval(`greet("monkey")`)
+package interpreter
+
+import (
+	"context"
+	"fmt"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"monkey/token"
+	"strings"
+)
+
+// Interpreter runs Monkey programs on behalf of a Go program. The values
+// defined on it, and the top-level bindings of every program it has run,
+// are visible to the programs it runs next.
+type Interpreter struct {
+	// Limits bounds the resources of each program the interpreter runs.
+	// The zero value leaves them unlimited, which is only safe for trusted
+	// programs.
+	Limits evaluator.Limits
+	// Overflow selects what integer arithmetic does with results that do
+	// not fit in 64 bits. The zero value promotes them to big integers.
+	Overflow object.Overflow
+	// IO is where builtins such as puts and input print to and read from.
+	// If it is nil, they use the standard input and output of the process.
+	IO *object.IO
+
+	env *object.Environment
+}
+
+// New returns an interpreter with no definitions beyond the builtins.
+func New() *Interpreter {
+	return &Interpreter{env: object.NewEnvironment()}
+}
+
+// Define binds name to value converted with ToObject, replacing any earlier
+// binding of name. Go functions become builtins that convert their
+// arguments and results, see ToObject for the conversion rules.
+func (in *Interpreter) Define(name string, value interface{}) error {
+	if !isIdentifier(name) {
+		return fmt.Errorf("cannot define %q: not an identifier", name)
+	}
+	obj, err := toObject(name, value)
+	if err != nil {
+		return fmt.Errorf("cannot define %s: %w", name, err)
+	}
+	in.env.Set(name, obj)
+	return nil
+}
+
+// Get returns the value bound to name by Define or by a program.
+func (in *Interpreter) Get(name string) (object.Object, bool) {
+	return in.env.Get(name)
+}
+
+// Eval runs source and returns the value of its last statement. It fails
+// with a *SyntaxError if source cannot be parsed and with the *object.Error
+// the program stopped with otherwise.
+func (in *Interpreter) Eval(source string) (object.Object, error) {
+	return in.EvalFileContext(context.Background(), "", source)
+}
+
+// EvalFile is like Eval, but reports positions in filename.
+func (in *Interpreter) EvalFile(filename, source string) (object.Object, error) {
+	return in.EvalFileContext(context.Background(), filename, source)
+}
+
+// EvalContext is like Eval, but stops the program when ctx is done. The
+// error it stops with then has the kind object.LimitExceeded, like the
+// error of a program that exceeds the Limits.
+func (in *Interpreter) EvalContext(ctx context.Context, source string) (object.Object, error) {
+	return in.EvalFileContext(ctx, "", source)
+}
+
+// EvalFileContext is like EvalContext, but reports positions in filename.
+func (in *Interpreter) EvalFileContext(ctx context.Context, filename, source string) (object.Object, error) {
+	p := parser.New(lexer.NewFile(filename, source))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		return nil, &SyntaxError{Source: source, Diagnostics: p.Diagnostics()}
+	}
+
+	in.env.SetOverflow(in.Overflow)
+	in.env.SetIO(in.IO)
+	evaluated := evaluator.EvalContext(ctx, program, in.env, in.Limits)
+	if err, ok := evaluated.(*object.Error); ok {
+		return nil, err
+	}
+	if evaluated == nil {
+		return evaluator.Null, nil
+	}
+	return evaluated, nil
+}
+
+// SyntaxError is returned for a program that cannot be parsed.
+type SyntaxError struct {
+	Source      string
+	Diagnostics []*parser.Diagnostic
+}
+
+func (e *SyntaxError) Error() string {
+	messages := make([]string, len(e.Diagnostics))
+	for i, d := range e.Diagnostics {
+		messages[i] = d.String()
+	}
+	return strings.Join(messages, "\n")
+}
+
+func isIdentifier(name string) bool {
+	tok := lexer.New(name).NextToken()
+	return tok.Type == token.IDENT && tok.Literal == name
+}
@@ -0,0 +1,321 @@
+package interpreter
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"monkey/object"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type point struct {
+	X, Y   int
+	Label  string `monkey:"label"`
+	secret int
+	Hidden bool `monkey:"-"`
+}
+
+func (p point) Sum() int { return p.X + p.Y }
+
+type counter struct{ n int }
+
+func (c *counter) Incr() int {
+	c.n++
+	return c.n
+}
+
+func TestDefine(t *testing.T) {
+	in := New()
+	shared := []int{1, 2}
+	definitions := map[string]interface{}{
+		"answer": 42,
+		"pi":     3.5,
+		"name":   "monkey",
+		"yes":    true,
+		"none":   nil,
+		"list":   []string{"a", "b"},
+		"scores": map[string]int{"ann": 3},
+		"origin": point{X: 1, Y: 2, Label: "o", secret: 7},
+		"ctr":    &counter{},
+		"add":    func(a, b int) int { return a + b },
+		"half":   func(x float64) float64 { return x / 2 },
+		"join":   func(sep string, parts ...string) string { return strings.Join(parts, sep) },
+		"divmod": func(a, b int) (int, int) { return a / b, a % b },
+		"noop":   func() {},
+		"parse": func(s string) (int, error) {
+			if s == "" {
+				return 0, errors.New("empty input")
+			}
+			return len(s), nil
+		},
+		"boom":    func() int { panic("kaboom") },
+		"apply":   func(f func(int) int, x int) int { return f(x) },
+		"attempt": func(f func() (int, error)) string { _, err := f(); return fmt.Sprint(err) },
+		"sum": func(xs []int) int {
+			total := 0
+			for _, x := range xs {
+				total += x
+			}
+			return total
+		},
+		"norm":  func(p point) int { return p.X*p.X + p.Y*p.Y },
+		"kind":  func(v interface{}) string { return fmt.Sprintf("%T", v) },
+		"ident": func(obj object.Object) object.Object { return obj },
+		"small": func(b int8) int8 { return b },
+		"huge":  uint64(1 << 63),
+		"fact": func(n int64) *big.Int {
+			return new(big.Int).MulRange(1, n)
+		},
+		"digits": func(x *big.Int) int { return len(x.String()) },
+		"byte":   func(b uint8) uint8 { return b },
+		"twice":  map[string][]int{"a": shared, "b": shared},
+	}
+	for name, value := range definitions {
+		if err := in.Define(name, value); err != nil {
+			t.Fatalf("Define(%q) failed: %s", name, err)
+		}
+	}
+
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"answer", "42"},
+		{"pi", "3.5"},
+		{"name", "monkey"},
+		{"yes", "true"},
+		{"none", "null"},
+		{"list", "[a, b]"},
+		{`scores["ann"]`, "3"},
+		{`origin["X"] + origin["Y"]`, "3"},
+		{`origin["label"]`, "o"},
+		{`origin["secret"]`, "null"},
+		{`origin["Hidden"]`, "null"},
+		{`origin["Sum"]()`, "3"},
+		{`ctr["Incr"](); ctr["Incr"]()`, "2"},
+		{"add(1, 2)", "3"},
+		{"half(3)", "1.5"},
+		{`join("-", "a", "b", "c")`, "a-b-c"},
+		{`join(",")`, ""},
+		{"divmod(7, 2)", "[3, 1]"},
+		{"noop()", "null"},
+		{`parse("abc")`, "3"},
+		{`parse("")`, "ERROR: 1:1: empty input"},
+		{"boom()", "ERROR: 1:1: panic in `boom`: kaboom"},
+		{"apply(fn(x) { x * 10 }, 4)", "40"},
+		{"apply(add, 4)", "ERROR: 1:1: wrong number of arguments. got=1, want=2"},
+		{"apply(fn(x) { x + true }, 4)", "ERROR: 1:17: type mismatch: INTEGER + BOOLEAN\n    at <anonymous> (1:17)\n    at <main>"},
+		{"attempt(fn() { 1 / true })", "1:18: type mismatch: INTEGER / BOOLEAN"},
+		{"attempt(fn() { 1 })", "<nil>"},
+		{"sum([1, 2, 3])", "6"},
+		{`norm({"X": 3, "Y": 4})`, "25"},
+		{`norm({"Z": 3})`, "ERROR: 1:1: argument 1 to `norm`: unknown field Z in interpreter.point"},
+		{`kind(1) + kind(1.5) + kind("s") + kind([1]) + kind({1: 2}) + kind(none)`,
+			"int64float64string[]interface {}map[interface {}]interface {}<nil>"},
+		{"ident([1, 2])", "[1, 2]"},
+		{"add(1)", "ERROR: 1:1: wrong number of arguments. got=1, want=2"},
+		{"join()", "ERROR: 1:1: wrong number of arguments. got=0, want at least 1"},
+		{`add(1, "2")`, "ERROR: 1:1: argument 2 to `add`: cannot use STRING as int"},
+		{"small(300)", "ERROR: 1:1: argument 1 to `small`: 300 overflows int8"},
+		{"huge", "9223372036854775808"},
+		{"huge - 1", "9223372036854775807"},
+		{"fact(25)", "15511210043330985984000000"},
+		{"fact(3)", "6"},
+		{"digits(fact(25)) + digits(7)", "27"},
+		{"half(huge)", "4.611686018427388e+18"},
+		{"small(huge)", "ERROR: 1:1: argument 1 to `small`: 9223372036854775808 overflows int8"},
+		{"byte(huge)", "ERROR: 1:1: argument 1 to `byte`: 9223372036854775808 overflows uint8"},
+		{"kind(huge)", "*big.Int"},
+		{"twice", "{a: [1, 2], b: [1, 2]}"},
+	}
+
+	for _, tt := range tests {
+		result, err := in.Eval(tt.input)
+		var got string
+		if err != nil {
+			got = err.(*object.Error).Inspect()
+		} else {
+			got = result.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestDefineErrors(t *testing.T) {
+	in := New()
+	type node struct{ Next *node }
+	loop := &node{}
+	loop.Next = loop
+	slice := []interface{}{nil}
+	slice[0] = slice
+	hash := map[string]interface{}{}
+	hash["self"] = map[string]interface{}{"parent": hash}
+
+	tests := []struct {
+		name     string
+		value    interface{}
+		expected string
+	}{
+		{"let", 1, `cannot define "let": not an identifier`},
+		{"a b", 1, `cannot define "a b": not an identifier`},
+		{"c", complex(1, 2), "cannot define c: cannot convert complex128 to a Monkey value"},
+		{"loop", loop, "cannot define loop: field Next: cannot convert *interpreter.node: it refers to itself"},
+		{"slice", slice, "cannot define slice: element 0: cannot convert []interface {}: it refers to itself"},
+		{"hash", hash, "cannot define hash: key self: key parent: cannot convert map[string]interface {}: it refers to itself"},
+	}
+	for _, tt := range tests {
+		err := in.Define(tt.name, tt.value)
+		if err == nil || err.Error() != tt.expected {
+			t.Errorf("Define(%q) wrong error. want=%q, got=%v", tt.name, tt.expected, err)
+		}
+	}
+}
+
+func TestEvalKeepsBindings(t *testing.T) {
+	in := New()
+	if _, err := in.Eval("let x = 20;"); err != nil {
+		t.Fatal(err)
+	}
+	result, err := in.Eval("x + 1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Inspect() != "21" {
+		t.Errorf("wrong result. got=%s", result.Inspect())
+	}
+	if x, ok := in.Get("x"); !ok || x.Inspect() != "20" {
+		t.Errorf("Get(x) wrong. got=%v, %t", x, ok)
+	}
+}
+
+func TestEvalSyntaxError(t *testing.T) {
+	_, err := New().EvalFile("bad.monkey", "let = 1;")
+	syntaxErr, ok := err.(*SyntaxError)
+	if !ok {
+		t.Fatalf("err is not *SyntaxError. got=%T (%v)", err, err)
+	}
+	if !strings.HasPrefix(syntaxErr.Error(), "bad.monkey:1:5: error[P0001]") {
+		t.Errorf("wrong message. got=%q", syntaxErr.Error())
+	}
+}
+
+func TestFromObject(t *testing.T) {
+	in := New()
+	result, err := in.Eval(`{"X": 1, "Y": 2, "label": "p"}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var p point
+	if err := FromObject(result, &p); err != nil {
+		t.Fatal(err)
+	}
+	if p != (point{X: 1, Y: 2, Label: "p"}) {
+		t.Errorf("wrong point. got=%+v", p)
+	}
+
+	result, err = in.Eval(`[1, 2.5, "x", [true]]`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []interface{}
+	if err := FromObject(result, &values); err != nil {
+		t.Fatal(err)
+	}
+	expected := []interface{}{int64(1), 2.5, "x", []interface{}{true}}
+	if !reflect.DeepEqual(values, expected) {
+		t.Errorf("wrong values. want=%#v, got=%#v", expected, values)
+	}
+
+	var n int
+	if err := FromObject(result, &n); err == nil || err.Error() != "cannot use ARRAY as int" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+	if err := FromObject(result, n); err == nil {
+		t.Errorf("expected an error for a non-pointer target")
+	}
+}
+
+func TestLimits(t *testing.T) {
+	in := New()
+	in.Limits.MaxSteps = 10000
+	in.Define("apply", func(f func() int) int { return f() })
+
+	_, err := in.Eval("apply(fn() { while (true) {} })")
+	errObj, ok := err.(*object.Error)
+	if !ok || errObj.Kind != object.LimitExceeded {
+		t.Fatalf("callback was not limited. got=%v", err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	_, err = in.EvalContext(ctx, "1")
+	if err == nil || err.Error() != "1:1: execution stopped: context canceled" {
+		t.Errorf("wrong error. got=%v", err)
+	}
+
+	result, err := in.Eval("apply(fn() { 1 })")
+	if err != nil || result.Inspect() != "1" {
+		t.Errorf("wrong result after limit errors. got=%v, %v", result, err)
+	}
+	// A value that contains itself must not take the host down.
+	var out bytes.Buffer
+	in.IO = object.NewIO(strings.NewReader(""), &out)
+	in.Define("kind", func(v interface{}) string { return fmt.Sprintf("%T", v) })
+	result, err = in.Eval("let a = [1]; a[0] = a; puts(a); a")
+	if err != nil || result.Inspect() != "[[...]]" || out.String() != "[[...]]\n" {
+		t.Errorf("wrong result for a cyclic array. got=%v, %v, output %q", result, err, out.String())
+	}
+	_, err = in.Eval(`let h = {}; h["h"] = h; kind(h)`)
+	if err == nil || err.Error() != "1:25: argument 1 to `kind`: cannot convert HASH: it contains itself" {
+		t.Errorf("wrong error for a cyclic hash. got=%v", err)
+	}
+}
+
+func TestOverflow(t *testing.T) {
+	in := New()
+	if _, err := in.Eval("let max = 9223372036854775807;"); err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		overflow object.Overflow
+		expected string
+	}{
+		{object.OverflowWrap, "-9223372036854775808"},
+		{object.OverflowDefault, "9223372036854775808"},
+		{object.OverflowChecked, "ERROR: 1:5: integer overflow: 9223372036854775807 + 1"},
+		{object.OverflowPromote, "9223372036854775808"},
+	}
+	for _, tt := range tests {
+		in.Overflow = tt.overflow
+		result, err := in.Eval("max + 1")
+		var got string
+		if err != nil {
+			got = err.(*object.Error).Inspect()
+		} else {
+			got = result.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.overflow, tt.expected, got)
+		}
+	}
+}
+
+func TestIO(t *testing.T) {
+	var out bytes.Buffer
+	in := New()
+	in.IO = object.NewIO(strings.NewReader("monkey\n"), &out)
+	in.Define("greet", func(name string) string { return "hello " + name })
+
+	if _, err := in.Eval(`printf("%s!\n", greet(input("name? ")))`); err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "name? hello monkey!\n" {
+		t.Errorf("wrong output. got=%q", got)
+	}
+}
@@ -1,7 +1,22 @@
+// Package lexer splits Monkey source code, which must be UTF-8 encoded, into
+// tokens.
+//
+// An identifier starts with a Unicode letter or an underscore, followed by
+// any number of Unicode letters, underscores and Unicode decimal digits, as
+// in Go: x, _tmp, größe, 名前 and x2 are identifiers. Keywords are reserved.
+//
+// Line comments start with // and block comments are enclosed in /* and */,
+// which do not nest. Both are skipped, except for doc comments: a line
+// comment starting with exactly three slashes is returned as a DOC_COMMENT
+// token, for the parser to attach to the declaration that follows it.
 package lexer
 
 import (
 	"monkey/token"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
 )
 
 const (
@@ -10,32 +25,55 @@ const (
 
 type Lexer struct {
 	input        string
+	filename     string
 	position     int  // current position in input
-	ch           byte // current char under examination
+	ch           rune // current char under examination
 	readPosition int  // current reading position (next char position)
+	line         int  // line of the current char
+	column       int  // column of the current char, counting bytes
 }
 
+// readChar decodes the next char. Bytes that are not valid UTF-8 are read
+// one at a time as utf8.RuneError.
 func (l *Lexer) readChar() {
-	if l.readPosition >= len(l.input) {
+	if l.ch == '\n' {
+		l.line += 1
+		l.column = 0
+	}
+	l.column += l.readPosition - l.position
+	l.position = l.readPosition
+	if l.position >= len(l.input) {
 		l.ch = EOF
-	} else {
-		l.ch = l.input[l.readPosition]
+		l.readPosition = l.position + 1
+		return
 	}
-	l.advancePosition()
+	r, width := utf8.DecodeRuneInString(l.input[l.position:])
+	l.ch = r
+	l.readPosition = l.position + width
 }
 
-func (l *Lexer) advancePosition() {
-	l.position = l.readPosition
-	l.readPosition += 1
+func New(input string) *Lexer {
+	return NewFile("", input)
 }
 
-func New(input string) *Lexer {
-	l := &Lexer{input: input}
+// NewFile creates a lexer whose token positions report the given filename.
+func NewFile(filename, input string) *Lexer {
+	l := &Lexer{input: input, filename: filename, line: 1, column: 1}
 	l.readChar()
 	return l
 }
 
-func newToken(tokenType token.TokenType, ch byte) token.Token {
+// pos returns the position of the current char.
+func (l *Lexer) pos() token.Position {
+	return token.Position{
+		Filename: l.filename,
+		Offset:   l.position,
+		Line:     l.line,
+		Column:   l.column,
+	}
+}
+
+func newToken(tokenType token.TokenType, ch rune) token.Token {
 	return token.Token{Type: tokenType, Literal: string(ch)}
 }
 
@@ -43,26 +81,112 @@ func newTokenWithString(tokenType token.TokenType, literal string) token.Token {
 	return token.Token{Type: tokenType, Literal: literal}
 }
 
-func (l *Lexer) readString() string {
-	position := l.position + 1
-	for {
-		l.readChar()
-		if l.ch == '"' || l.ch == 0 {
-			break
+// readString reads a string literal and returns a STRING token with the
+// escape sequences in it replaced by the chars they stand for: \n, \t,
+// \r, \", \\ and \u{X}, where X is the hexadecimal code point of a
+// Unicode char. If the literal contains an invalid escape sequence, it
+// returns an ILLEGAL token for the first one instead, positioned on it.
+func (l *Lexer) readString() token.Token {
+	var out strings.Builder
+	var illegal *token.Token
+	l.readChar()
+	for l.ch != '"' && l.ch != EOF {
+		if l.ch != '\\' {
+			out.WriteString(l.input[l.position:l.readPosition])
+			l.readChar()
+			continue
 		}
+		start := l.pos()
+		s, ok := l.readEscape()
+		if !ok && illegal == nil {
+			end := l.pos()
+			end.Offset = l.readPosition
+			end.Column += l.readPosition - l.position
+			illegal = &token.Token{
+				Type:    token.ILLEGAL,
+				Literal: l.input[start.Offset:end.Offset],
+				Pos:     start,
+				End:     end,
+			}
+		}
+		out.WriteString(s)
+		l.readChar()
 	}
-	return l.input[position:l.position]
+	if illegal != nil {
+		return *illegal
+	}
+	return newTokenWithString(token.STRING, out.String())
 }
 
-func (l *Lexer) NextToken() token.Token {
-	var tok token.Token
+// readEscape reads the escape sequence starting at the current backslash
+// and leaves the lexer on its last char. It reports false if the sequence
+// is invalid, reading no further than the end of the string.
+func (l *Lexer) readEscape() (string, bool) {
+	if l.peekChar() == EOF {
+		return "", false
+	}
+	l.readChar()
+	switch l.ch {
+	case 'n':
+		return "\n", true
+	case 't':
+		return "\t", true
+	case 'r':
+		return "\r", true
+	case '"':
+		return `"`, true
+	case '\\':
+		return `\`, true
+	case 'u':
+		return l.readUnicodeEscape()
+	default:
+		return "", false
+	}
+}
+
+// readUnicodeEscape reads the {X} part of a \u{X} escape sequence, with
+// the lexer on the u.
+func (l *Lexer) readUnicodeEscape() (string, bool) {
+	if l.peekChar() != '{' {
+		return "", false
+	}
+	l.readChar()
+	start := l.readPosition
+	for isHexDigit(l.peekChar()) {
+		l.readChar()
+	}
+	digits := l.input[start:l.readPosition]
+	if l.peekChar() != '}' {
+		return "", false
+	}
+	l.readChar()
+	code, err := strconv.ParseUint(digits, 16, 32)
+	if err != nil || len(digits) > 6 || !utf8.ValidRune(rune(code)) {
+		return "", false
+	}
+	return string(rune(code)), true
+}
 
+func (l *Lexer) NextToken() token.Token {
 	l.skipWhitespace()
 
+	pos := l.pos()
+	tok := l.scanToken()
+	if !tok.Pos.IsValid() {
+		tok.Pos = pos
+		tok.End = l.pos()
+	}
+	return tok
+}
+
+// scanToken reads the token starting at the current char and leaves the
+// lexer on the char right after it.
+func (l *Lexer) scanToken() token.Token {
+	var tok token.Token
+
 	switch l.ch {
 	case '"':
-		tok.Type = token.STRING
-		tok.Literal = l.readString()
+		tok = l.readString()
 	case '=':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -72,9 +196,9 @@ func (l *Lexer) NextToken() token.Token {
 			tok = newToken(token.ASSIGN, l.ch)
 		}
 	case '+':
-		tok = newToken(token.PLUS, l.ch)
+		tok = l.readCompound(token.PLUS, token.PLUS_ASSIGN)
 	case '-':
-		tok = newToken(token.MINUS, l.ch)
+		tok = l.readCompound(token.MINUS, token.MINUS_ASSIGN)
 	case '!':
 		if l.peekChar() == '=' {
 			ch := l.ch
@@ -83,10 +207,22 @@ func (l *Lexer) NextToken() token.Token {
 		} else {
 			tok = newToken(token.BANG, l.ch)
 		}
+	case '&':
+		tok = l.readDouble(token.AND)
+	case '|':
+		tok = l.readDouble(token.OR)
 	case '/':
-		tok = newToken(token.SLASH, l.ch)
+		switch l.peekChar() {
+		case '/':
+			return l.readDocComment()
+		case '*':
+			return l.readUnterminatedComment()
+		}
+		tok = l.readCompound(token.SLASH, token.SLASH_ASSIGN)
 	case '*':
-		tok = newToken(token.ASTERISK, l.ch)
+		tok = l.readCompound(token.ASTERISK, token.ASTERISK_ASSIGN)
+	case '%':
+		tok = l.readCompound(token.PERCENT, token.PERCENT_ASSIGN)
 	case ',':
 		tok = newToken(token.COMMA, l.ch)
 	case ';':
@@ -110,16 +246,16 @@ func (l *Lexer) NextToken() token.Token {
 	case ':':
 		tok = newToken(token.COLON, l.ch)
 	case EOF:
-		tok = newTokenWithString(token.EOF, "")
+		return newTokenWithString(token.EOF, "")
 	default:
 		if isLetter(l.ch) {
 			identifier := l.readIdentifier()
 			tokenType := token.LookupIdent(identifier)
 			return newTokenWithString(tokenType, identifier)
 		} else if isDigit(l.ch) {
-			return newTokenWithString(token.INT, l.readNumber())
+			return newTokenWithString(l.readNumber())
 		} else {
-			tok = newToken(token.ILLEGAL, l.ch)
+			tok = newTokenWithString(token.ILLEGAL, l.input[l.position:l.readPosition])
 		}
 	}
 
@@ -127,40 +263,170 @@ func (l *Lexer) NextToken() token.Token {
 	return tok
 }
 
+// readCompound reads an operator that is compound with assignment when it
+// is followed by =, such as + and +=.
+func (l *Lexer) readCompound(tokenType, assignType token.TokenType) token.Token {
+	if l.peekChar() != '=' {
+		return newToken(tokenType, l.ch)
+	}
+	ch := l.ch
+	l.readChar()
+	return newTokenWithString(assignType, string(ch)+string(l.ch))
+}
+
+// readDouble reads an operator made of the current char written twice,
+// such as &&. A single char is illegal.
+func (l *Lexer) readDouble(tokenType token.TokenType) token.Token {
+	if l.peekChar() != l.ch {
+		return newToken(token.ILLEGAL, l.ch)
+	}
+	ch := l.ch
+	l.readChar()
+	return newTokenWithString(tokenType, string(ch)+string(l.ch))
+}
+
 func (l *Lexer) readIdentifier() string {
 	position := l.position
-	for isLetter(l.ch) {
+	for isLetter(l.ch) || unicode.IsDigit(l.ch) {
 		l.readChar()
 	}
 	return l.input[position:l.position]
 }
 
-func (l *Lexer) readNumber() string {
+// readNumber reads an integer or a float literal. A float has a fraction,
+// an exponent or both, as in 3.14, 1e-9 or 2.5E3.
+func (l *Lexer) readNumber() (token.TokenType, string) {
 	position := l.position
+	tokenType := token.TokenType(token.INT)
+	l.readDigits()
+	if l.ch == '.' && isDigit(l.peekChar()) {
+		tokenType = token.FLOAT
+		l.readChar()
+		l.readDigits()
+	}
+	if (l.ch == 'e' || l.ch == 'E') && l.isExponentStart() {
+		tokenType = token.FLOAT
+		l.readChar()
+		if l.ch == '+' || l.ch == '-' {
+			l.readChar()
+		}
+		l.readDigits()
+	}
+	return tokenType, l.input[position:l.position]
+}
+
+func (l *Lexer) readDigits() {
 	for isDigit(l.ch) {
 		l.readChar()
 	}
-	return l.input[position:l.position]
 }
 
+// isExponentStart reports whether the 'e' under examination is followed by
+// an exponent, so that 2e5 is a float but 2else is not.
+func (l *Lexer) isExponentStart() bool {
+	next := l.peekChar()
+	if next == '+' || next == '-' {
+		next = l.peekCharN(2)
+	}
+	return isDigit(next)
+}
+
+// skipWhitespace skips whitespace and comments, stopping at doc comments
+// and at block comments that are not terminated.
 func (l *Lexer) skipWhitespace() {
-	for l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r' {
+	for {
+		switch {
+		case l.ch == ' ' || l.ch == '\t' || l.ch == '\n' || l.ch == '\r':
+			l.readChar()
+		case l.ch == '/' && l.peekChar() == '/' && !l.isDocComment():
+			l.skipLine()
+		case l.ch == '/' && l.peekChar() == '*' && l.skipBlockComment():
+		default:
+			return
+		}
+	}
+}
+
+// isDocComment reports whether the line comment under examination starts
+// with exactly three slashes.
+func (l *Lexer) isDocComment() bool {
+	return l.peekCharN(2) == '/' && l.peekCharN(3) != '/'
+}
+
+// skipLine leaves the lexer on the newline that ends the current line, or
+// at the end of input.
+func (l *Lexer) skipLine() {
+	for l.ch != '\n' && l.ch != EOF {
+		l.readChar()
+	}
+}
+
+// skipBlockComment skips the block comment starting at the current char.
+// It reports false, skipping nothing, if the comment is not terminated.
+func (l *Lexer) skipBlockComment() bool {
+	if !strings.Contains(l.input[l.readPosition+1:], "*/") {
+		return false
+	}
+	l.readChar()
+	l.readChar()
+	for !(l.ch == '*' && l.peekChar() == '/') {
+		l.readChar()
+	}
+	l.readChar()
+	l.readChar()
+	return true
+}
+
+// readDocComment reads a doc comment. Its literal is the text after the
+// slashes, without the one space that usually follows them.
+func (l *Lexer) readDocComment() token.Token {
+	start := l.position + len("///")
+	l.skipLine()
+	text := strings.TrimSuffix(l.input[start:l.position], "\r")
+	return newTokenWithString(token.DOC_COMMENT, strings.TrimPrefix(text, " "))
+}
+
+// readUnterminatedComment returns an illegal token for the opening /* of a
+// block comment that is never closed and skips the rest of the input.
+func (l *Lexer) readUnterminatedComment() token.Token {
+	start := l.pos()
+	end := start
+	end.Offset += len("/*")
+	end.Column += len("/*")
+	for l.ch != EOF {
 		l.readChar()
 	}
+	return token.Token{Type: token.ILLEGAL, Literal: "/*", Pos: start, End: end}
 }
 
-func isLetter(ch byte) bool {
-	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_'
+func isLetter(ch rune) bool {
+	return 'a' <= ch && ch <= 'z' || 'A' <= ch && ch <= 'Z' || ch == '_' ||
+		ch >= utf8.RuneSelf && unicode.IsLetter(ch)
 }
 
-func isDigit(ch byte) bool {
+// isDigit reports whether ch is an ASCII digit, which numbers consist of.
+func isDigit(ch rune) bool {
 	return '0' <= ch && ch <= '9'
 }
 
-func (l *Lexer) peekChar() byte {
-	if l.readPosition >= len(l.input) {
+func isHexDigit(ch rune) bool {
+	return isDigit(ch) || 'a' <= ch && ch <= 'f' || 'A' <= ch && ch <= 'F'
+}
+
+func (l *Lexer) peekChar() rune {
+	return l.peekCharN(1)
+}
+
+// peekCharN returns the char n positions after the current one.
+func (l *Lexer) peekCharN(n int) rune {
+	offset := l.readPosition
+	for ; n > 1 && offset < len(l.input); n-- {
+		_, width := utf8.DecodeRuneInString(l.input[offset:])
+		offset += width
+	}
+	if offset >= len(l.input) {
 		return EOF
-	} else {
-		return l.input[l.readPosition]
 	}
+	r, _ := utf8.DecodeRuneInString(l.input[offset:])
+	return r
 }
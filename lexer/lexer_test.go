@@ -55,7 +55,7 @@ let add = fn(x, y) {
 };
 
 let result = add(five, ten);
-!-/*5;
+!-/ *5;
 5 < 10 > 5;
 
 if (5 < 10) {
@@ -166,3 +166,222 @@ if (5 < 10) {
 	}
 	doTest(t, input, tests)
 }
+
+func TestTokenPositions(t *testing.T) {
+	input := `let x = 5;
+  "ab" == x;
+`
+	tests := []struct {
+		expectedLiteral string
+		expectedPos     token.Position
+		expectedEnd     token.Position
+	}{
+		{"let", token.Position{Filename: "test.monkey", Offset: 0, Line: 1, Column: 1}, token.Position{Filename: "test.monkey", Offset: 3, Line: 1, Column: 4}},
+		{"x", token.Position{Filename: "test.monkey", Offset: 4, Line: 1, Column: 5}, token.Position{Filename: "test.monkey", Offset: 5, Line: 1, Column: 6}},
+		{"=", token.Position{Filename: "test.monkey", Offset: 6, Line: 1, Column: 7}, token.Position{Filename: "test.monkey", Offset: 7, Line: 1, Column: 8}},
+		{"5", token.Position{Filename: "test.monkey", Offset: 8, Line: 1, Column: 9}, token.Position{Filename: "test.monkey", Offset: 9, Line: 1, Column: 10}},
+		{";", token.Position{Filename: "test.monkey", Offset: 9, Line: 1, Column: 10}, token.Position{Filename: "test.monkey", Offset: 10, Line: 1, Column: 11}},
+		{"ab", token.Position{Filename: "test.monkey", Offset: 13, Line: 2, Column: 3}, token.Position{Filename: "test.monkey", Offset: 17, Line: 2, Column: 7}},
+		{"==", token.Position{Filename: "test.monkey", Offset: 18, Line: 2, Column: 8}, token.Position{Filename: "test.monkey", Offset: 20, Line: 2, Column: 10}},
+		{"x", token.Position{Filename: "test.monkey", Offset: 21, Line: 2, Column: 11}, token.Position{Filename: "test.monkey", Offset: 22, Line: 2, Column: 12}},
+		{";", token.Position{Filename: "test.monkey", Offset: 22, Line: 2, Column: 12}, token.Position{Filename: "test.monkey", Offset: 23, Line: 2, Column: 13}},
+		{"", token.Position{Filename: "test.monkey", Offset: 24, Line: 3, Column: 1}, token.Position{Filename: "test.monkey", Offset: 24, Line: 3, Column: 1}},
+	}
+
+	l := NewFile("test.monkey", input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - literal wrong. expected=%q, got=%q", i, tt.expectedLiteral, tok.Literal)
+		}
+		if tok.Pos != tt.expectedPos {
+			t.Errorf("tests[%d] - pos wrong. expected=%+v, got=%+v", i, tt.expectedPos, tok.Pos)
+		}
+		if tok.End != tt.expectedEnd {
+			t.Errorf("tests[%d] - end wrong. expected=%+v, got=%+v", i, tt.expectedEnd, tok.End)
+		}
+	}
+}
+
+func TestNumbers(t *testing.T) {
+	input := `5 3.14 1e-9 2.5E+3 7e 1.foo 2else`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.INT, "5"},
+		{token.FLOAT, "3.14"},
+		{token.FLOAT, "1e-9"},
+		{token.FLOAT, "2.5E+3"},
+		{token.INT, "7"},
+		{token.IDENT, "e"},
+		{token.INT, "1"},
+		{token.ILLEGAL, "."},
+		{token.IDENT, "foo"},
+		{token.INT, "2"},
+		{token.ELSE, "else"},
+		{token.EOF, ""},
+	}
+
+	doTest(t, input, tests)
+}
+
+func TestLogicalOperators(t *testing.T) {
+	input := `a && b || c & |`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "a"},
+		{token.AND, "&&"},
+		{token.IDENT, "b"},
+		{token.OR, "||"},
+		{token.IDENT, "c"},
+		{token.ILLEGAL, "&"},
+		{token.ILLEGAL, "|"},
+		{token.EOF, ""},
+	}
+
+	doTest(t, input, tests)
+}
+
+func TestAssignmentOperators(t *testing.T) {
+	input := `x = 1; x += 2 -= 3 *= 4 /= 5 == 6 % 7 %= 8`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "1"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.PLUS_ASSIGN, "+="},
+		{token.INT, "2"},
+		{token.MINUS_ASSIGN, "-="},
+		{token.INT, "3"},
+		{token.ASTERISK_ASSIGN, "*="},
+		{token.INT, "4"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "5"},
+		{token.EQ, "=="},
+		{token.INT, "6"},
+		{token.PERCENT, "%"},
+		{token.INT, "7"},
+		{token.PERCENT_ASSIGN, "%="},
+		{token.INT, "8"},
+		{token.EOF, ""},
+	}
+
+	doTest(t, input, tests)
+}
+
+func TestStringEscapes(t *testing.T) {
+	tests := []struct {
+		input           string
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedColumns [2]int
+	}{
+		{`"plain"`, token.STRING, "plain", [2]int{1, 8}},
+		{`"a\nb\tc\rd"`, token.STRING, "a\nb\tc\rd", [2]int{1, 13}},
+		{`"\"quoted\" \\"`, token.STRING, `"quoted" \`, [2]int{1, 16}},
+		{`"\u{41}\u{e9}\u{1F600}"`, token.STRING, "Aé😀", [2]int{1, 24}},
+		{`"bad \q escape"`, token.ILLEGAL, `\q`, [2]int{6, 8}},
+		{`"\u{110000}"`, token.ILLEGAL, `\u{110000}`, [2]int{2, 12}},
+		{`"\u{}"`, token.ILLEGAL, `\u{}`, [2]int{2, 6}},
+		{`"\u41"`, token.ILLEGAL, `\u`, [2]int{2, 4}},
+		{`"\u{41"`, token.ILLEGAL, `\u{41`, [2]int{2, 7}},
+	}
+	for _, tt := range tests {
+		l := New(tt.input + " x")
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Errorf("%s: wrong token. want=%s %q, got=%s %q",
+				tt.input, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Column != tt.expectedColumns[0] || tok.End.Column != tt.expectedColumns[1] {
+			t.Errorf("%s: wrong columns. want=%v, got=[%d %d]",
+				tt.input, tt.expectedColumns, tok.Pos.Column, tok.End.Column)
+		}
+		// The rest of the string is skipped after an invalid escape.
+		if next := l.NextToken(); next.Type != token.IDENT || next.Literal != "x" {
+			t.Errorf("%s: wrong token after the string. got=%s %q", tt.input, next.Type, next.Literal)
+		}
+	}
+}
+
+func TestUnicode(t *testing.T) {
+	input := "let größe = \"😀\" + 名前;\n_x2 ٣ § é"
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+		expectedLine    int
+		expectedColumns [2]int
+	}{
+		{token.LET, "let", 1, [2]int{1, 4}},
+		{token.IDENT, "größe", 1, [2]int{5, 12}},
+		{token.ASSIGN, "=", 1, [2]int{13, 14}},
+		{token.STRING, "😀", 1, [2]int{15, 21}},
+		{token.PLUS, "+", 1, [2]int{22, 23}},
+		{token.IDENT, "名前", 1, [2]int{24, 30}},
+		{token.SEMICOLON, ";", 1, [2]int{30, 31}},
+		{token.IDENT, "_x2", 2, [2]int{1, 4}},
+		// Digits other than ASCII ones only continue identifiers.
+		{token.ILLEGAL, "٣", 2, [2]int{5, 7}},
+		{token.ILLEGAL, "§", 2, [2]int{8, 10}},
+		{token.IDENT, "é", 2, [2]int{11, 13}},
+		{token.EOF, "", 2, [2]int{13, 13}},
+	}
+
+	l := New(input)
+	for i, tt := range tests {
+		tok := l.NextToken()
+		if tok.Type != tt.expectedType || tok.Literal != tt.expectedLiteral {
+			t.Fatalf("tests[%d] - wrong token. want=%s %q, got=%s %q",
+				i, tt.expectedType, tt.expectedLiteral, tok.Type, tok.Literal)
+		}
+		if tok.Pos.Line != tt.expectedLine || tok.Pos.Column != tt.expectedColumns[0] ||
+			tok.End.Column != tt.expectedColumns[1] {
+			t.Errorf("tests[%d] - wrong position. want=%d:%v, got=%d:[%d %d]",
+				i, tt.expectedLine, tt.expectedColumns, tok.Pos.Line, tok.Pos.Column, tok.End.Column)
+		}
+	}
+}
+
+func TestComments(t *testing.T) {
+	input := `// a line comment
+let x = 10 / 2; // trailing
+/* a block
+   comment */ x /**/ /= 5;
+/// Doc comment.
+///no space
+//// not a doc comment
+/* unterminated`
+
+	tests := []struct {
+		expectedType    token.TokenType
+		expectedLiteral string
+	}{
+		{token.LET, "let"},
+		{token.IDENT, "x"},
+		{token.ASSIGN, "="},
+		{token.INT, "10"},
+		{token.SLASH, "/"},
+		{token.INT, "2"},
+		{token.SEMICOLON, ";"},
+		{token.IDENT, "x"},
+		{token.SLASH_ASSIGN, "/="},
+		{token.INT, "5"},
+		{token.SEMICOLON, ";"},
+		{token.DOC_COMMENT, "Doc comment."},
+		{token.DOC_COMMENT, "no space"},
+		{token.ILLEGAL, "/*"},
+		{token.EOF, ""},
+	}
+
+	doTest(t, input, tests)
+}
@@ -1,19 +1,232 @@
 package main
 
 import (
+	"flag"
 	"fmt"
+	"io"
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
 	"monkey/repl"
+	"monkey/vm"
 	"os"
 	"os/user"
 )
 
+// Exit codes of the monkey command.
+const (
+	exitOK           = 0
+	exitRuntimeError = 1  // the program stopped with an uncaught error
+	exitSyntaxError  = 2  // the program could not be parsed
+	exitUsage        = 64 // the command line was invalid
+	exitIOError      = 74 // the program could not be read
+)
+
+const usage = `usage:
+  monkey                          start the interactive REPL
+  monkey run file.monkey [args]   run a script file
+  monkey -e 'expr' [args]         evaluate expr and print its value
+  monkey - [args]                 run a program read from stdin
+
+A program is also read from stdin when stdin is not a terminal.
+Script arguments are available to the program as the array args.
+
+flags:
+`
+
 func main() {
+	os.Exit(run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr))
+}
+
+func run(arguments []string, stdin io.Reader, stdout, stderr io.Writer) int {
+	flags := flag.NewFlagSet("monkey", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	flags.Usage = func() {
+		fmt.Fprint(stderr, usage)
+		flags.PrintDefaults()
+	}
+	expr := flags.String("e", "", "evaluate `expr` and print its value")
+	engine := flags.String("engine", "eval", "run programs with `backend` eval (tree-walking) or vm (bytecode)")
+	overflowMode := flags.String("overflow", "promote", "handle integer results beyond 64 bits with `mode` promote (to big integers), checked (an error) or wrap")
+	if err := flags.Parse(arguments); err != nil {
+		if err == flag.ErrHelp {
+			return exitOK
+		}
+		return exitUsage
+	}
+	rest := flags.Args()
+
+	execute, ok := engines[*engine]
+	if !ok {
+		fmt.Fprintf(stderr, "monkey: unknown engine %q\n", *engine)
+		flags.Usage()
+		return exitUsage
+	}
+	overflow, ok := overflowModes[*overflowMode]
+	if !ok {
+		fmt.Fprintf(stderr, "monkey: unknown overflow mode %q\n", *overflowMode)
+		flags.Usage()
+		return exitUsage
+	}
+	stdio := object.NewIO(stdin, stdout)
+	runSource := func(filename, source string, args []string, printResult bool) int {
+		return runProgram(execute, filename, source, args, stdio, overflow, stderr, printResult)
+	}
+
+	switch {
+	case isFlagSet(flags, "e"):
+		return runSource("-e", *expr, rest, true)
+	case len(rest) > 0 && rest[0] == "run":
+		if len(rest) < 2 {
+			fmt.Fprintln(stderr, "monkey run: missing script file")
+			flags.Usage()
+			return exitUsage
+		}
+		source, err := os.ReadFile(rest[1])
+		if err != nil {
+			fmt.Fprintf(stderr, "monkey: %s\n", err)
+			return exitIOError
+		}
+		return runSource(rest[1], string(source), rest[2:], false)
+	case len(rest) > 0 && rest[0] == "-", len(rest) == 0 && !isTerminal(stdin):
+		if len(rest) > 0 {
+			rest = rest[1:]
+		}
+		source, err := io.ReadAll(stdin)
+		if err != nil {
+			fmt.Fprintf(stderr, "monkey: reading stdin: %s\n", err)
+			return exitIOError
+		}
+		return runSource("<stdin>", string(source), rest, false)
+	case len(rest) == 0:
+		if *engine != "eval" {
+			fmt.Fprintf(stderr, "monkey: the REPL runs on the eval engine only; -engine=%s needs a program\n", *engine)
+			flags.Usage()
+			return exitUsage
+		}
+		greet(stdout)
+		repl.Start(stdin, stdout, overflow)
+		return exitOK
+	default:
+		fmt.Fprintf(stderr, "monkey: unknown command %q\n", rest[0])
+		flags.Usage()
+		return exitUsage
+	}
+}
+
+// An engine executes a parsed program with args bound to the script
+// arguments, stdio as its IO and overflow as its overflow mode, returning
+// its result or the runtime error it stopped with.
+type engine func(program *ast.Program, args *object.Array, stdio *object.IO, overflow object.Overflow) (object.Object, *object.Error)
+
+var engines = map[string]engine{
+	"eval": evalProgram,
+	"vm":   runBytecode,
+}
+
+var overflowModes = map[string]object.Overflow{
+	"promote": object.OverflowPromote,
+	"checked": object.OverflowChecked,
+	"wrap":    object.OverflowWrap,
+}
+
+// runProgram parses and executes source, reporting syntax and runtime
+// errors to stderr. filename is only used in error messages.
+func runProgram(execute engine, filename, source string, args []string, stdio *object.IO, overflow object.Overflow, stderr io.Writer, printResult bool) int {
+	p := parser.New(lexer.NewFile(filename, source))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		parser.RenderDiagnostics(stderr, source, p.Diagnostics())
+		return exitSyntaxError
+	}
+
+	result, err := execute(program, scriptArgs(args), stdio, overflow)
+	if err != nil {
+		fmt.Fprintln(stderr, err.Inspect())
+		return exitRuntimeError
+	}
+	if printResult && result != nil && result != evaluator.Null {
+		fmt.Fprintln(stdio.Out, result.Inspect())
+	}
+	return exitOK
+}
+
+func evalProgram(program *ast.Program, args *object.Array, stdio *object.IO, overflow object.Overflow) (object.Object, *object.Error) {
+	env := object.NewEnvironment()
+	env.SetIO(stdio)
+	env.SetOverflow(overflow)
+	// Allow calls to nest as deeply as on the VM.
+	env.SetMaxDepth(vm.MaxFrames)
+	env.Set("args", args)
+	evaluated := evaluator.Eval(program, env)
+	if err, ok := evaluated.(*object.Error); ok {
+		return nil, err
+	}
+	return evaluated, nil
+}
+
+func runBytecode(program *ast.Program, args *object.Array, stdio *object.IO, overflow object.Overflow) (object.Object, *object.Error) {
+	comp := compiler.New()
+	argsSymbol := comp.SymbolTable().Define("args")
+	if err := comp.Compile(program); err != nil {
+		return nil, evaluator.NewError("%s", err)
+	}
+
+	globals := make([]object.Object, vm.GlobalsSize)
+	globals[argsSymbol.Index] = args
+	machine := vm.NewWithGlobalsStore(comp.Bytecode(), globals)
+	machine.IO = stdio
+	machine.Overflow = overflow
+	if err := machine.Run(); err != nil {
+		if rtErr, ok := err.(*object.Error); ok {
+			return nil, rtErr
+		}
+		return nil, evaluator.NewError("%s", err)
+	}
+	return machine.LastPoppedStackElem(), nil
+}
+
+func scriptArgs(args []string) *object.Array {
+	elements := make([]object.Object, len(args))
+	for i, arg := range args {
+		elements[i] = &object.String{Value: arg}
+	}
+	return &object.Array{Elements: elements}
+}
+
+func isFlagSet(flags *flag.FlagSet, name string) bool {
+	set := false
+	flags.Visit(func(f *flag.Flag) {
+		if f.Name == name {
+			set = true
+		}
+	})
+	return set
+}
+
+// isTerminal reports whether r is an interactive terminal rather than a
+// pipe or a file.
+func isTerminal(r io.Reader) bool {
+	f, ok := r.(*os.File)
+	if !ok {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+func greet(out io.Writer) {
 	user, err := user.Current()
 	if err != nil {
 		panic(err)
 	}
-	fmt.Printf("Hello %s! This is the Monkey programming language!\n",
+	fmt.Fprintf(out, "Hello %s! This is the Monkey programming language!\n",
 		user.Username)
-	fmt.Printf("Feel free to type in commands\n")
-	repl.Start(os.Stdin, os.Stdout)
+	fmt.Fprintf(out, "Feel free to type in commands\n")
 }
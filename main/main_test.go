@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRun(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "script.monkey")
+	err := os.WriteFile(script, []byte("let x = args[0];\nx + 1;\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		args           []string
+		stdin          string
+		expectedCode   int
+		expectedStdout string
+		expectedStderr string
+	}{
+		{[]string{"-e", "1 + 2"}, "", exitOK, "3\n", ""},
+		{[]string{"-e", "args", "a", "b"}, "", exitOK, "[a, b]\n", ""},
+		{[]string{"-e", "if (false) { 1 }"}, "", exitOK, "", ""},
+		{[]string{"-e", "let = 1;"}, "", exitSyntaxError, "", "-e:1:5: error[P0001]"},
+		{[]string{"-e", "1 + true"}, "", exitRuntimeError, "", "ERROR: -e:1:3: type mismatch: INTEGER + BOOLEAN"},
+		{[]string{"run", script, "1"}, "", exitRuntimeError, "", "script.monkey:2:3: type mismatch: STRING + INTEGER"},
+		{[]string{"run", filepath.Join(dir, "missing.monkey")}, "", exitIOError, "", "no such file"},
+		{[]string{"run"}, "", exitUsage, "", "missing script file"},
+		{[]string{"-"}, "let a = 1; a + b", exitRuntimeError, "", "<stdin>:1:16: identifier not found: b"},
+		{[]string{}, "let a = 1; a;", exitOK, "", ""},
+		{[]string{"unknown"}, "", exitUsage, "", `unknown command "unknown"`},
+		{[]string{"-engine=vm", "-e", "let f = fn(x) { x * 2 }; f(21)"}, "", exitOK, "42\n", ""},
+		{[]string{"-engine=vm", "-e", "args", "a"}, "", exitOK, "[a]\n", ""},
+		{[]string{"-engine=vm", "run", script, "1"}, "", exitRuntimeError, "", "script.monkey:2:3: type mismatch: STRING + INTEGER"},
+		{[]string{"-engine=vm", "-"}, "let a = 1; a + b", exitRuntimeError, "", "<stdin>:1:16: identifier not found: b"},
+		{[]string{"-engine=jit", "-e", "1"}, "", exitUsage, "", `unknown engine "jit"`},
+		{[]string{"-e", "9223372036854775807 + 1"}, "", exitOK, "9223372036854775808\n", ""},
+		{[]string{"-overflow=wrap", "-e", "9223372036854775807 + 1"}, "", exitOK, "-9223372036854775808\n", ""},
+		{[]string{"-engine=vm", "-overflow=wrap", "-e", "9223372036854775807 + 1"}, "", exitOK, "-9223372036854775808\n", ""},
+		{[]string{"-engine=vm", "-overflow=checked", "-e", "9223372036854775807 + 1"}, "", exitRuntimeError, "", "integer overflow"},
+		{[]string{"-overflow=saturate", "-e", "1"}, "", exitUsage, "", `unknown overflow mode "saturate"`},
+		{[]string{"-e", `puts("hi", 1); print("a", "b"); printf("!%d\n", 2)`}, "", exitOK, "hi\n1\na b!2\n", ""},
+		{[]string{"-e", `let name = input("name? "); "hello " + name`}, "ann\nbob\n", exitOK, "name? hello ann\n", ""},
+		{[]string{"-engine=vm", "-e", `map([input(), input(), input()], fn(x) { puts(x) }); 0`}, "a\nb", exitOK, "a\nb\nnull\n0\n", ""},
+		{[]string{"-e", "let f = fn() { 1 + true }; f()"}, "", exitRuntimeError, "", "1:18: type mismatch: INTEGER + BOOLEAN\n    at f (-e:1:18)\n    at <main> (-e:1:28)\n"},
+		{[]string{"-engine=vm", "-e", "let f = fn() { 1 + true }; f()"}, "", exitRuntimeError, "", "1:18: type mismatch: INTEGER + BOOLEAN\n    at f (-e:1:18)\n    at <main> (-e:1:28)\n"},
+	}
+
+	for _, tt := range tests {
+		var stdout, stderr bytes.Buffer
+		code := run(tt.args, strings.NewReader(tt.stdin), &stdout, &stderr)
+		if code != tt.expectedCode {
+			t.Errorf("%v: exit code wrong. want=%d, got=%d (stderr=%q)",
+				tt.args, tt.expectedCode, code, stderr.String())
+		}
+		if stdout.String() != tt.expectedStdout {
+			t.Errorf("%v: stdout wrong. want=%q, got=%q",
+				tt.args, tt.expectedStdout, stdout.String())
+		}
+		if !strings.Contains(stderr.String(), tt.expectedStderr) {
+			t.Errorf("%v: stderr does not contain %q. got=%q",
+				tt.args, tt.expectedStderr, stderr.String())
+		}
+	}
+}
+
+func TestRunREPLEngine(t *testing.T) {
+	// The REPL starts when stdin is a terminal, and /dev/null is a character
+	// device as well.
+	stdin, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer stdin.Close()
+
+	var stdout, stderr bytes.Buffer
+	code := run([]string{"-engine=vm"}, stdin, &stdout, &stderr)
+	if code != exitUsage {
+		t.Errorf("exit code wrong. want=%d, got=%d", exitUsage, code)
+	}
+	if stdout.Len() != 0 {
+		t.Errorf("stdout not empty. got=%q", stdout.String())
+	}
+	want := "the REPL runs on the eval engine only; -engine=vm needs a program"
+	if !strings.Contains(stderr.String(), want) {
+		t.Errorf("stderr does not contain %q. got=%q", want, stderr.String())
+	}
+}
@@ -1,13 +1,176 @@
 package object
 
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
 func NewEnvironment() *Environment {
 	s := make(map[string]Object)
-	return &Environment{store: s, outer: nil}
+	return &Environment{store: s, consts: map[string]bool{}, outer: nil}
 }
 
 type Environment struct {
-	store map[string]Object
-	outer *Environment
+	store  map[string]Object
+	consts map[string]bool // the names in store bound by const
+	outer  *Environment
+	meter  Meter // nil unless set with SetMeter
+
+	overflow Overflow // unset unless set with SetOverflow
+	io       *IO      // nil unless set with SetIO
+
+	callDepth int // the number of calls active, in the environment of a call
+	maxDepth  int // 0 unless set with SetMaxDepth
+
+	block bool // whether e only holds the names bound for a block
+}
+
+// A Meter accounts for the resources a running program uses, and stops the
+// program by returning an error when they run out. The evaluator reports to
+// the meter of the environment it evaluates in.
+type Meter interface {
+	// Step is called before each evaluation step.
+	Step() *Error
+	// Call is called before a function call, and Return after it if Call
+	// succeeded.
+	Call() *Error
+	Return()
+	// Allocate is called for each value created, with its size.
+	Allocate(size int64) *Error
+}
+
+// SetMeter makes m the meter of e and of the environments enclosed by it,
+// unless they have their own. A nil m removes the meter of e.
+func (e *Environment) SetMeter(m Meter) {
+	e.meter = m
+}
+
+// Meter returns the meter that applies to e, or nil if there is none.
+func (e *Environment) Meter() Meter {
+	for env := e; env != nil; env = env.outer {
+		if env.meter != nil {
+			return env.meter
+		}
+	}
+	return nil
+}
+
+// Overflow selects what integer arithmetic does with a result that does not
+// fit in 64 bits.
+type Overflow int
+
+const (
+	// OverflowDefault leaves the mode unset: an environment inherits the
+	// mode of its outer one, and arithmetic without a mode promotes.
+	OverflowDefault Overflow = iota
+	// OverflowWrap wraps the result around, as Go does.
+	OverflowWrap
+	// OverflowChecked fails with an error.
+	OverflowChecked
+	// OverflowPromote returns the exact result as a BigInt.
+	OverflowPromote
+)
+
+func (o Overflow) String() string {
+	switch o {
+	case OverflowDefault:
+		return "default"
+	case OverflowWrap:
+		return "wrap"
+	case OverflowChecked:
+		return "checked"
+	case OverflowPromote:
+		return "promote"
+	default:
+		return fmt.Sprintf("Overflow(%d)", int(o))
+	}
+}
+
+// SetOverflow makes o the overflow mode of e and of the environments
+// enclosed by it, unless they have their own. OverflowDefault removes the
+// mode of e.
+func (e *Environment) SetOverflow(o Overflow) {
+	e.overflow = o
+}
+
+// Overflow returns the overflow mode that applies to e. It is
+// OverflowPromote unless another mode was set.
+func (e *Environment) Overflow() Overflow {
+	for env := e; env != nil; env = env.outer {
+		if env.overflow != OverflowDefault {
+			return env.overflow
+		}
+	}
+	return OverflowPromote
+}
+
+// SetCallDepth records that e is the environment of a function call made
+// while depth-1 other calls were active.
+func (e *Environment) SetCallDepth(depth int) {
+	e.callDepth = depth
+}
+
+// CallDepth returns the number of function calls active in e: the depth
+// of the innermost call enclosing it, or 0 outside of functions.
+func (e *Environment) CallDepth() int {
+	for env := e; env != nil; env = env.outer {
+		if env.callDepth != 0 {
+			return env.callDepth
+		}
+	}
+	return 0
+}
+
+// SetMaxDepth bounds how deeply function calls made in e and in the
+// environments enclosed by it may nest, counting the main program as one
+// call.
+func (e *Environment) SetMaxDepth(depth int) {
+	e.maxDepth = depth
+}
+
+// MaxDepth returns the bound on the depth of calls that applies to e, or 0
+// if none was set.
+func (e *Environment) MaxDepth() int {
+	for env := e; env != nil; env = env.outer {
+		if env.maxDepth != 0 {
+			return env.maxDepth
+		}
+	}
+	return 0
+}
+
+// IO is the input and output of a program, which the builtins that print
+// and read use.
+type IO struct {
+	Out io.Writer
+	In  *bufio.Reader
+}
+
+// NewIO returns an IO that reads from in and writes to out. The IO buffers
+// in, so in should not be read from elsewhere while the IO is in use.
+func NewIO(in io.Reader, out io.Writer) *IO {
+	r, ok := in.(*bufio.Reader)
+	if !ok {
+		r = bufio.NewReader(in)
+	}
+	return &IO{Out: out, In: r}
+}
+
+// SetIO makes io the input and output of e and of the environments
+// enclosed by it, unless they have their own. A nil io removes the IO of e.
+func (e *Environment) SetIO(io *IO) {
+	e.io = io
+}
+
+// IO returns the IO that applies to e, or nil if there is none.
+func (e *Environment) IO() *IO {
+	for env := e; env != nil; env = env.outer {
+		if env.io != nil {
+			return env.io
+		}
+	}
+	return nil
 }
 
 func (e *Environment) Get(name string) (Object, bool) {
@@ -23,8 +186,70 @@ func (e *Environment) Set(name string, val Object) Object {
 	return val
 }
 
+// Declare binds name in e as let does, or as const does if constant is set.
+// A constant cannot be declared again: Declare reports false and leaves e
+// unchanged if name is already a constant in e. In an environment made by
+// NewBlockEnvironment, only the name bound for the block is declared in e,
+// the others in its outer environment.
+func (e *Environment) Declare(name string, val Object, constant bool) bool {
+	if _, ok := e.store[name]; e.block && !ok {
+		return e.outer.Declare(name, val, constant)
+	}
+	if e.consts[name] {
+		return false
+	}
+	e.store[name] = val
+	if constant {
+		e.consts[name] = true
+	}
+	return true
+}
+
+// IsConst reports whether name refers to a constant, looking it up like Get.
+func (e *Environment) IsConst(name string) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			return env.consts[name]
+		}
+	}
+	return false
+}
+
+// Assign rebinds name in the innermost environment that declares it. It
+// reports false if name is not declared at all. Assigning to a constant is
+// up to the caller to prevent, see IsConst.
+func (e *Environment) Assign(name string, val Object) bool {
+	for env := e; env != nil; env = env.outer {
+		if _, ok := env.store[name]; ok {
+			env.store[name] = val
+			return true
+		}
+	}
+	return false
+}
+
 func NewEnclosedEnvironment(outer *Environment) *Environment {
 	env := NewEnvironment()
 	env.outer = outer
 	return env
 }
+
+// NewBlockEnvironment returns an environment that binds name to val for a
+// single block, such as the parameter of a catch, and otherwise is outer:
+// the block declares its other names in outer, as blocks do.
+func NewBlockEnvironment(outer *Environment, name string, val Object) *Environment {
+	env := NewEnclosedEnvironment(outer)
+	env.store[name] = val
+	env.block = true
+	return env
+}
+
+// Names returns the names bound directly in e, not in its outer
+// environments, in no particular order.
+func (e *Environment) Names() []string {
+	names := make([]string, 0, len(e.store))
+	for name := range e.store {
+		names = append(names, name)
+	}
+	return names
+}
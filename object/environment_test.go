@@ -0,0 +1,26 @@
+package object
+
+import "testing"
+
+func TestOverflow(t *testing.T) {
+	outer := NewEnvironment()
+	inner := NewEnclosedEnvironment(outer)
+	if got := inner.Overflow(); got != OverflowPromote {
+		t.Errorf("wrong default mode. want=%s, got=%s", OverflowPromote, got)
+	}
+
+	outer.SetOverflow(OverflowChecked)
+	if got := inner.Overflow(); got != OverflowChecked {
+		t.Errorf("mode not inherited. want=%s, got=%s", OverflowChecked, got)
+	}
+
+	inner.SetOverflow(OverflowWrap)
+	if got := inner.Overflow(); got != OverflowWrap {
+		t.Errorf("mode not overridden. want=%s, got=%s", OverflowWrap, got)
+	}
+
+	inner.SetOverflow(OverflowDefault)
+	if got := inner.Overflow(); got != OverflowChecked {
+		t.Errorf("mode not removed. want=%s, got=%s", OverflowChecked, got)
+	}
+}
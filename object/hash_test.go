@@ -0,0 +1,95 @@
+package object
+
+import (
+	"math"
+	"math/big"
+	"testing"
+)
+
+func TestHashCollisions(t *testing.T) {
+	// Force every key into one bucket, as colliding HashKeys would.
+	collision := HashKey{Type: StringObj, Value: 42}
+	h := &Hash{}
+	for _, s := range []string{"a", "b", "c", "d"} {
+		h.set(collision, &String{Value: s}, &String{Value: s + s})
+	}
+	h.set(collision, &String{Value: "b"}, &String{Value: "B"})
+
+	if h.Len() != 4 {
+		t.Fatalf("wrong length. want=4, got=%d", h.Len())
+	}
+	if got := h.Inspect(); got != "{a: aa, b: B, c: cc, d: dd}" {
+		t.Errorf("wrong hash. got=%s", got)
+	}
+	for _, s := range []string{"a", "c", "d"} {
+		pair, ok := h.get(collision, &String{Value: s})
+		if !ok || pair.Value.Inspect() != s+s {
+			t.Errorf("get(%q) wrong. got=%v, %t", s, pair.Value, ok)
+		}
+	}
+	if _, ok := h.get(collision, &String{Value: "e"}); ok {
+		t.Errorf("get(%q) found a missing key", "e")
+	}
+
+	if !h.delete(collision, &String{Value: "a"}) {
+		t.Fatalf("delete(%q) found no key", "a")
+	}
+	if h.delete(collision, &String{Value: "a"}) {
+		t.Errorf("delete(%q) found a deleted key", "a")
+	}
+	h.set(collision, &String{Value: "a"}, &String{Value: "A"})
+	if got := h.Inspect(); got != "{b: B, c: cc, d: dd, a: A}" {
+		t.Errorf("wrong hash after delete. got=%s", got)
+	}
+	for _, s := range []string{"b", "c", "d", "a"} {
+		if _, ok := h.get(collision, &String{Value: s}); !ok {
+			t.Errorf("get(%q) lost a key after delete", s)
+		}
+	}
+}
+
+func TestHashKeys(t *testing.T) {
+	h := NewHash(0)
+	keys := []Hashable{
+		&String{Value: "1"},
+		&Integer{Value: 1},
+		&Float{Value: 1.5},
+		&BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 64)},
+		&BigInt{Value: new(big.Int).Lsh(big.NewInt(-1), 64)},
+		&Boolean{Value: true},
+		&Float{Value: math.NaN()},
+	}
+	for i, key := range keys {
+		h.Set(key, &Integer{Value: int64(i)})
+	}
+	if h.Len() != len(keys) {
+		t.Fatalf("wrong length. want=%d, got=%d", len(keys), h.Len())
+	}
+
+	tests := []struct {
+		key      Hashable
+		expected int64
+	}{
+		{&String{Value: "1"}, 0},
+		{&Integer{Value: 1}, 1},
+		{&Float{Value: 1.5}, 2},
+		{&Float{Value: 1}, 1},
+		{&Float{Value: 1 << 64}, 3},
+		{&Float{Value: -(1 << 64)}, 4},
+		{&BigInt{Value: new(big.Int).Lsh(big.NewInt(1), 64)}, 3},
+		{&BigInt{Value: new(big.Int).Lsh(big.NewInt(-1), 64)}, 4},
+		{&Boolean{Value: true}, 5},
+		{&Float{Value: math.NaN()}, 6},
+	}
+	for _, tt := range tests {
+		pair, ok := h.Get(tt.key)
+		if !ok {
+			t.Errorf("Get(%s) found no key", tt.key.Inspect())
+			continue
+		}
+		if pair.Value.(*Integer).Value != tt.expected {
+			t.Errorf("Get(%s) wrong value. want=%d, got=%s",
+				tt.key.Inspect(), tt.expected, pair.Value.Inspect())
+		}
+	}
+}
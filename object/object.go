@@ -3,8 +3,13 @@ package object
 import (
 	"bytes"
 	"fmt"
-	"hash/fnv"
+	"hash/maphash"
+	"math"
+	"math/big"
 	"monkey/ast"
+	"monkey/code"
+	"monkey/token"
+	"strconv"
 	"strings"
 )
 
@@ -27,7 +32,37 @@ func (ReturnValue) Type() ObjectType {
 	return ReturnValueObj
 }
 
-type BuiltinFunction func(args ...Object) Object
+// Break and Continue signal a break or continue statement to the enclosing
+// loop, the way ReturnValue signals a return to the enclosing function.
+type Break struct{}
+
+func (Break) Inspect() string  { return "break" }
+func (Break) Type() ObjectType { return BreakObj }
+
+type Continue struct{}
+
+func (Continue) Inspect() string  { return "continue" }
+func (Continue) Type() ObjectType { return ContinueObj }
+
+// Runtime is the backend running a program, as the builtins it calls see
+// it.
+type Runtime interface {
+	// Call calls fn, a function or builtin of the program, with args. It
+	// returns the result of the call, or the *Error the call failed with.
+	Call(fn Object, args []Object) Object
+	// IO returns the input and output of the program.
+	IO() *IO
+	// Allocate charges size, counted as for the allocation limit of the
+	// program, before the builtin creates a value of that size, and
+	// returns the error to return instead if the program may not. A
+	// builtin that calls Allocate accounts for all of the value it
+	// returns.
+	Allocate(size int64) *Error
+}
+
+// BuiltinFunction implements a builtin. rt is the backend that calls it,
+// through which it can call the functions passed to it.
+type BuiltinFunction func(rt Runtime, args ...Object) Object
 type Builtin struct {
 	Fn BuiltinFunction
 }
@@ -37,14 +72,21 @@ func (Builtin) Inspect() string  { return "builtin function" }
 
 const (
 	IntegerObj     = "INTEGER"
+	BigIntObj      = "BIGINT"
+	FloatObj       = "FLOAT"
 	BooleanObj     = "BOOLEAN"
 	NullObj        = "NULL_OBJ"
 	ReturnValueObj = "RETURN_VALUE"
+	BreakObj       = "BREAK"
+	ContinueObj    = "CONTINUE"
 	ErrorObj       = "ERROR"
 	FunctionObj    = "FUNCTION"
 	StringObj      = "STRING"
 	BuiltinObj     = "BUILTIN"
 	HashObj        = "HASH"
+	ArrayObj       = "ARRAY"
+
+	CompiledFunctionObj = "COMPILED_FUNCTION"
 )
 
 type String struct {
@@ -59,6 +101,7 @@ func (s *String) Inspect() string {
 }
 
 type Function struct {
+	Name       string // the name the function was bound to by let, if any
 	Parameters []*ast.Identifier
 	Body       *ast.BlockStatement
 	Env        *Environment
@@ -66,29 +109,156 @@ type Function struct {
 
 func (f *Function) Type() ObjectType { return FunctionObj }
 func (f *Function) Inspect() string {
+	return inspectFunction(f.Parameters, f.Body)
+}
+
+func inspectFunction(parameters []*ast.Identifier, body *ast.BlockStatement) string {
 	var out bytes.Buffer
 	params := []string{}
-	for _, p := range f.Parameters {
+	for _, p := range parameters {
 		params = append(params, p.String())
 	}
 	out.WriteString("fn")
 	out.WriteString("(")
 	out.WriteString(strings.Join(params, ", "))
 	out.WriteString(") {\n")
-	out.WriteString(f.Body.String())
+	out.WriteString(body.String())
 	out.WriteString("\n}")
 	return out.String()
 }
 
+// CompiledFunction is a function literal compiled to bytecode.
+type CompiledFunction struct {
+	Instructions  code.Instructions
+	NumLocals     int
+	NumParameters int
+	// Positions maps the offsets of instructions that may fail to the
+	// source position they were compiled from.
+	Positions map[int]token.Position
+	// Identifiers maps the offsets of instructions reading a variable to
+	// its name, for reporting variables that have no value yet.
+	Identifiers map[int]string
+	// Literal is the function literal the function was compiled from.
+	Literal *ast.FunctionLiteral
+}
+
+func (cf *CompiledFunction) Type() ObjectType { return CompiledFunctionObj }
+func (cf *CompiledFunction) Inspect() string {
+	if cf.Literal != nil {
+		return inspectFunction(cf.Literal.Parameters, cf.Literal.Body)
+	}
+	return fmt.Sprintf("CompiledFunction[%p]", cf)
+}
+
+// Closure is a compiled function together with the free variables it
+// captured when it was created. It is the VM's counterpart of Function and
+// has the same type, so that programs see no difference between backends.
+type Closure struct {
+	Fn   *CompiledFunction
+	Free []Object
+}
+
+func (c *Closure) Type() ObjectType { return FunctionObj }
+func (c *Closure) Inspect() string  { return c.Fn.Inspect() }
+
 type Error struct {
 	Message string
+	Pos     token.Position // where the error occurred, if known
+	Kind    ErrorKind      // empty for ordinary runtime errors
+	Value   Object         // the value thrown by throw, if any
+	// Trace lists the function calls the error propagated out of, from
+	// the innermost one outwards.
+	Trace []Frame
+}
+
+// A Frame is a function call on the call stack of an error.
+type Frame struct {
+	Function string         // the name of the function, or <anonymous>
+	Call     token.Position // where the function was called, if known
+}
+
+// AddFrame records that the error propagated out of a call of the function
+// named function at call. An empty name stands for an anonymous function.
+func (e *Error) AddFrame(function string, call token.Position) {
+	if function == "" {
+		function = "<anonymous>"
+	}
+	e.Trace = append(e.Trace, Frame{Function: function, Call: call})
 }
 
+// ErrorKind classifies errors, so that hosts and programs can tell apart
+// the errors they expect from the ones they do not.
+type ErrorKind string
+
+const (
+	// RuntimeError is the kind programs see for an error with an empty
+	// kind, i.e. an ordinary runtime error.
+	RuntimeError ErrorKind = "RuntimeError"
+	// Thrown is the kind of errors raised by throw with a value that names
+	// no kind of its own.
+	Thrown ErrorKind = "Error"
+	// LimitExceeded is the kind of errors that stop a program because it
+	// ran out of a resource it was allowed, or because it was canceled.
+	// Programs cannot catch them.
+	LimitExceeded ErrorKind = "LimitExceeded"
+)
+
 func (e *Error) Type() ObjectType {
 	return ErrorObj
 }
+
+// Inspect formats the error followed by a traceback if it propagated out of
+// any function, listing each function with the position it had reached and
+// ending with the top level of the program:
+//
+//	ERROR: 2:9: type mismatch: INTEGER + BOOLEAN
+//	    at add (2:9)
+//	    at <main> (4:1)
+//
+// Runs of the same line, as left by recursion, are shown once with a count.
 func (e *Error) Inspect() string {
-	return "ERROR: " + e.Message
+	var out bytes.Buffer
+	out.WriteString("ERROR: " + e.Error())
+	if len(e.Trace) == 0 {
+		return out.String()
+	}
+
+	lines := make([]string, 0, len(e.Trace)+1)
+	pos := e.Pos
+	for _, frame := range e.Trace {
+		lines = append(lines, traceLine(frame.Function, pos))
+		pos = frame.Call
+	}
+	lines = append(lines, traceLine("<main>", pos))
+
+	for i := 0; i < len(lines); {
+		run := 1
+		for i+run < len(lines) && lines[i+run] == lines[i] {
+			run++
+		}
+		out.WriteString("\n    " + lines[i])
+		if run > 1 {
+			fmt.Fprintf(&out, "\n    ... repeated %d more times", run-1)
+		}
+		i += run
+	}
+	return out.String()
+}
+
+func traceLine(function string, pos token.Position) string {
+	if !pos.IsValid() {
+		return "at " + function
+	}
+	return "at " + function + " (" + pos.String() + ")"
+}
+
+// Error makes runtime errors usable as Go errors, e.g. when returned by the
+// bytecode VM.
+func (e *Error) Error() string {
+	if e.Pos.IsValid() {
+		return e.Pos.String() + ": " + e.Message
+	}
+	return e.Message
 }
 
 type Integer struct {
@@ -98,6 +268,31 @@ type Integer struct {
 func (i *Integer) Inspect() string  { return fmt.Sprintf("%d", i.Value) }
 func (i *Integer) Type() ObjectType { return IntegerObj }
 
+// BigInt is an integer outside the range of Integer. Integers in that range
+// are always represented as Integers, and Value must not be modified once
+// the BigInt is created.
+type BigInt struct {
+	Value *big.Int
+}
+
+func (b *BigInt) Inspect() string  { return b.Value.String() }
+func (b *BigInt) Type() ObjectType { return BigIntObj }
+
+type Float struct {
+	Value float64
+}
+
+// Inspect formats the float with as few digits as needed to read it back,
+// keeping a fraction so that it cannot be mistaken for an integer.
+func (f *Float) Inspect() string {
+	s := strconv.FormatFloat(f.Value, 'g', -1, 64)
+	if !strings.ContainsAny(s, ".eIN") {
+		s += ".0"
+	}
+	return s
+}
+func (f *Float) Type() ObjectType { return FloatObj }
+
 type Boolean struct {
 	Value bool
 }
@@ -110,12 +305,71 @@ type Null struct{}
 func (n *Null) Type() ObjectType { return NullObj }
 func (n *Null) Inspect() string  { return "null" }
 
+type Array struct {
+	Elements []Object
+}
+
+func (a *Array) Type() ObjectType { return ArrayObj }
+func (a *Array) Inspect() string {
+	return a.inspect(map[Object]bool{})
+}
+
+// inspect is Inspect for an array inside the arrays and hashes in seen,
+// which are being printed already: an array that contains itself prints
+// as [...] where it repeats.
+func (a *Array) inspect(seen map[Object]bool) string {
+	if seen[a] {
+		return "[...]"
+	}
+	seen[a] = true
+	defer delete(seen, a)
+
+	var out bytes.Buffer
+	elements := []string{}
+	for _, e := range a.Elements {
+		elements = append(elements, inspect(e, seen))
+	}
+	out.WriteString("[")
+	out.WriteString(strings.Join(elements, ", "))
+	out.WriteString("]")
+	return out.String()
+}
+
+// inspect returns obj.Inspect(), for obj inside the arrays and hashes in
+// seen.
+func inspect(obj Object, seen map[Object]bool) string {
+	switch obj := obj.(type) {
+	case *Array:
+		return obj.inspect(seen)
+	case *Hash:
+		return obj.inspect(seen)
+	default:
+		return obj.Inspect()
+	}
+}
+
 type HashPair struct {
 	Key   Object
 	Value Object
 }
+
+// Hash maps keys to values. It keeps its pairs in the order their keys were
+// first set, and finds them by HashKey in constant time. Keys whose
+// HashKeys collide share a bucket and are told apart by their values. The
+// zero Hash is empty and ready to use.
 type Hash struct {
-	Pairs map[HashKey]HashPair
+	pairs []HashPair
+	keys  []HashKey // keys[i] is the HashKey of pairs[i].Key
+	index map[HashKey][]int
+}
+
+// NewHash returns an empty hash with room for size pairs.
+func NewHash(size int) *Hash {
+	return &Hash{
+		pairs: make([]HashPair, 0, size),
+		keys:  make([]HashKey, 0, size),
+		index: make(map[HashKey][]int, size),
+	}
 }
 
 func (h *Hash) Type() ObjectType {
@@ -123,11 +377,23 @@ func (h *Hash) Type() ObjectType {
 }
 
 func (h *Hash) Inspect() string {
+	return h.inspect(map[Object]bool{})
+}
+
+// inspect is Inspect for a hash inside the arrays and hashes in seen, see
+// (*Array).inspect.
+func (h *Hash) inspect(seen map[Object]bool) string {
+	if seen[h] {
+		return "{...}"
+	}
+	seen[h] = true
+	defer delete(seen, h)
+
 	var out bytes.Buffer
 	pairs := []string{}
-	for _, pair := range h.Pairs {
+	for _, pair := range h.pairs {
 		pairs = append(pairs, fmt.Sprintf("%s: %s",
-			pair.Key.Inspect(), pair.Value.Inspect()))
+			pair.Key.Inspect(), inspect(pair.Value, seen)))
 	}
 	out.WriteString("{")
 	out.WriteString(strings.Join(pairs, ", "))
@@ -135,7 +401,139 @@ func (h *Hash) Inspect() string {
 	return out.String()
 }
 
+// Len returns the number of pairs in h.
+func (h *Hash) Len() int {
+	return len(h.pairs)
+}
+
+// Pairs returns the pairs of h in insertion order. The slice belongs to h
+// and must not be modified.
+func (h *Hash) Pairs() []HashPair {
+	return h.pairs
+}
+
+// Get returns the pair of h with key, if there is one.
+func (h *Hash) Get(key Hashable) (HashPair, bool) {
+	return h.get(key.HashKey(), key)
+}
+
+func (h *Hash) get(hashKey HashKey, key Object) (HashPair, bool) {
+	i := h.find(hashKey, key)
+	if i < 0 {
+		return HashPair{}, false
+	}
+	return h.pairs[i], true
+}
+
+// Set maps key to value. A key that is already in h keeps its place in the
+// order of the pairs.
+func (h *Hash) Set(key Hashable, value Object) {
+	h.set(key.HashKey(), key, value)
+}
+
+func (h *Hash) set(hashKey HashKey, key, value Object) {
+	if i := h.find(hashKey, key); i >= 0 {
+		h.pairs[i].Value = value
+		return
+	}
+	if h.index == nil {
+		h.index = make(map[HashKey][]int)
+	}
+	h.index[hashKey] = append(h.index[hashKey], len(h.pairs))
+	h.pairs = append(h.pairs, HashPair{Key: key, Value: value})
+	h.keys = append(h.keys, hashKey)
+}
+
+// Delete removes the pair with key from h and reports whether there was
+// one. It takes time linear in the number of pairs that follow it.
+func (h *Hash) Delete(key Hashable) bool {
+	return h.delete(key.HashKey(), key)
+}
+
+func (h *Hash) delete(hashKey HashKey, key Object) bool {
+	i := h.find(hashKey, key)
+	if i < 0 {
+		return false
+	}
+	h.unindex(hashKey, i)
+	h.pairs = append(h.pairs[:i], h.pairs[i+1:]...)
+	h.keys = append(h.keys[:i], h.keys[i+1:]...)
+	for j := i; j < len(h.pairs); j++ {
+		bucket := h.index[h.keys[j]]
+		for k := range bucket {
+			if bucket[k] == j+1 {
+				bucket[k] = j
+				break
+			}
+		}
+	}
+	return true
+}
+
+// find returns the position of the pair with key in h.pairs, or -1.
+func (h *Hash) find(hashKey HashKey, key Object) int {
+	for _, i := range h.index[hashKey] {
+		if sameKey(h.pairs[i].Key, key) {
+			return i
+		}
+	}
+	return -1
+}
+
+// unindex removes position i from the bucket of hashKey.
+func (h *Hash) unindex(hashKey HashKey, i int) {
+	bucket := h.index[hashKey]
+	if len(bucket) == 1 {
+		delete(h.index, hashKey)
+		return
+	}
+	rest := make([]int, 0, len(bucket)-1)
+	for _, j := range bucket {
+		if j != i {
+			rest = append(rest, j)
+		}
+	}
+	h.index[hashKey] = rest
+}
+
+// sameKey reports whether a and b are the same key of a hash.
+func sameKey(a, b Object) bool {
+	// An integral float is the same key as the integer it equals.
+	if f, ok := a.(*Float); ok {
+		if i, ok := f.integer(); ok {
+			a = i
+		}
+	}
+	if f, ok := b.(*Float); ok {
+		if i, ok := f.integer(); ok {
+			b = i
+		}
+	}
+	switch a := a.(type) {
+	case *String:
+		b, ok := b.(*String)
+		return ok && a.Value == b.Value
+	case *Integer:
+		b, ok := b.(*Integer)
+		return ok && a.Value == b.Value
+	case *Boolean:
+		b, ok := b.(*Boolean)
+		return ok && a.Value == b.Value
+	case *BigInt:
+		b, ok := b.(*BigInt)
+		return ok && a.Value.Cmp(b.Value) == 0
+	case *Float:
+		// Compare the keys rather than the values, so that NaN finds itself.
+		b, ok := b.(*Float)
+		return ok && a.HashKey() == b.HashKey()
+	default:
+		return false
+	}
+}
+
+// Hashable is implemented by the objects that can be keys of a Hash.
 type Hashable interface {
+	Object
 	HashKey() HashKey
 }
 
@@ -158,8 +556,46 @@ func (i *Integer) HashKey() HashKey {
 	return HashKey{Type: i.Type(), Value: uint64(i.Value)}
 }
 
+// hashSeed randomizes the HashKeys of strings and big integers for each
+// run of the program, so that inputs cannot be crafted to collide.
+var hashSeed = maphash.MakeSeed()
+
+func (b *BigInt) HashKey() HashKey {
+	var h maphash.Hash
+	h.SetSeed(hashSeed)
+	if b.Value.Sign() < 0 {
+		h.WriteByte('-')
+	}
+	h.Write(b.Value.Bytes())
+	return HashKey{Type: b.Type(), Value: h.Sum64()}
+}
+
+// HashKey returns the key of the integer an integral float equals, so that
+// 1.0 finds the key 1, and a key made from its bits otherwise.
+func (f *Float) HashKey() HashKey {
+	if i, ok := f.integer(); ok {
+		return i.HashKey()
+	}
+	return HashKey{Type: f.Type(), Value: math.Float64bits(f.Value)}
+}
+
+// integer returns the INTEGER or BIGINT with the value of f, and reports
+// false if f is not integral.
+func (f *Float) integer() (Hashable, bool) {
+	v := f.Value
+	if math.IsInf(v, 0) || v != math.Trunc(v) {
+		return nil, false
+	}
+	if v >= math.MinInt64 && v < -math.MinInt64 {
+		return &Integer{Value: int64(v)}, true
+	}
+	z, _ := big.NewFloat(v).Int(nil)
+	return &BigInt{Value: z}, true
+}
+
 func (s *String) HashKey() HashKey {
-	h := fnv.New64a()
-	h.Write([]byte(s.Value))
+	var h maphash.Hash
+	h.SetSeed(hashSeed)
+	h.WriteString(s.Value)
 	return HashKey{Type: s.Type(), Value: h.Sum64()}
 }
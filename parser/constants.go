@@ -0,0 +1,153 @@
+package parser
+
+import (
+	"fmt"
+	"monkey/ast"
+)
+
+// constScope holds the names declared so far in a function body, or in the
+// program, mapped to whether they are certainly constants.
+type constScope map[string]bool
+
+// checkConstants reports the assignments and redeclarations of constants
+// that can be found without running the program. Like Eval, blocks share
+// the scope of the enclosing function. A const inside a block, loop or if
+// may not run, so only the ones directly in a function body or the program
+// are known to be constant; the others are left to the runtime check.
+func (p *Parser) checkConstants(program *ast.Program) {
+	c := &constChecker{p: p, scopes: []constScope{{}}}
+	c.statements(program.Statements, true)
+}
+
+type constChecker struct {
+	p      *Parser
+	scopes []constScope
+}
+
+func (c *constChecker) statements(stmts []ast.Statement, certain bool) {
+	for _, stmt := range stmts {
+		c.statement(stmt, certain)
+	}
+}
+
+func (c *constChecker) statement(stmt ast.Statement, certain bool) {
+	switch stmt := stmt.(type) {
+	case *ast.LetStatement:
+		c.expression(stmt.Value)
+		if c.current()[stmt.Name.Value] {
+			c.report(stmt.Name, "cannot redeclare constant %s", stmt.Name.Value)
+		}
+		c.current()[stmt.Name.Value] = stmt.IsConst() && certain
+	case *ast.ReturnStatement:
+		c.expression(stmt.ReturnValue)
+	case *ast.ThrowStatement:
+		c.expression(stmt.Value)
+	case *ast.ExpressionStatement:
+		c.expression(stmt.Expression)
+	case *ast.BlockStatement:
+		c.block(stmt)
+	case *ast.WhileStatement:
+		c.expression(stmt.Condition)
+		c.block(stmt.Body)
+	case *ast.ForStatement:
+		c.expression(stmt.Iterable)
+		if c.current()[stmt.Variable.Value] {
+			c.report(stmt.Variable, "cannot redeclare constant %s", stmt.Variable.Value)
+		}
+		c.current()[stmt.Variable.Value] = false
+		c.block(stmt.Body)
+	}
+}
+
+func (c *constChecker) block(block *ast.BlockStatement) {
+	if block != nil {
+		c.statements(block.Statements, false)
+	}
+}
+
+func (c *constChecker) expression(exp ast.Expression) {
+	switch exp := exp.(type) {
+	case *ast.AssignExpression:
+		if ident, ok := exp.Target.(*ast.Identifier); ok && c.isConst(ident.Value) {
+			c.report(ident, "cannot assign to constant %s", ident.Value)
+		}
+		c.expression(exp.Target)
+		c.expression(exp.Value)
+	case *ast.PrefixOperator:
+		c.expression(exp.Right)
+	case *ast.InfixExpression:
+		c.expression(exp.Left)
+		c.expression(exp.Right)
+	case *ast.IfExpression:
+		c.expression(exp.Condition)
+		c.block(exp.Consequence)
+		c.block(exp.Alternative)
+	case *ast.TryExpression:
+		c.block(exp.Block)
+		if exp.Parameter != nil {
+			// The parameter shadows other names in the catch block only.
+			c.scopes = append(c.scopes, constScope{exp.Parameter.Value: false})
+			c.block(exp.Catch)
+			c.scopes = c.scopes[:len(c.scopes)-1]
+		} else {
+			c.block(exp.Catch)
+		}
+		c.block(exp.Finally)
+	case *ast.FunctionLiteral:
+		scope := constScope{}
+		for _, param := range exp.Parameters {
+			scope[param.Value] = false
+		}
+		c.scopes = append(c.scopes, scope)
+		if exp.Body != nil {
+			c.statements(exp.Body.Statements, true)
+		}
+		c.scopes = c.scopes[:len(c.scopes)-1]
+	case *ast.CallExpression:
+		c.expression(exp.Function)
+		for _, arg := range exp.Arguments {
+			c.expression(arg)
+		}
+	case *ast.ArrayLiteral:
+		for _, el := range exp.Elements {
+			c.expression(el)
+		}
+	case *ast.HashLiteral:
+		for _, pair := range exp.Pairs {
+			c.expression(pair.Key)
+			c.expression(pair.Value)
+		}
+	case *ast.IndexExpression:
+		c.expression(exp.Left)
+		c.expression(exp.Index)
+	case *ast.SliceExpression:
+		c.expression(exp.Left)
+		c.expression(exp.Low)
+		c.expression(exp.High)
+	}
+}
+
+func (c *constChecker) current() constScope {
+	return c.scopes[len(c.scopes)-1]
+}
+
+// isConst reports whether name refers to a declaration known to be const.
+func (c *constChecker) isConst(name string) bool {
+	for i := len(c.scopes) - 1; i >= 0; i-- {
+		if constant, ok := c.scopes[i][name]; ok {
+			return constant
+		}
+	}
+	return false
+}
+
+func (c *constChecker) report(ident *ast.Identifier, format string, a ...interface{}) {
+	c.p.diagnostics = append(c.p.diagnostics, &Diagnostic{
+		Code:       CodeConstantRebound,
+		Message:    fmt.Sprintf(format, a...),
+		Pos:        ident.Pos(),
+		End:        ident.End(),
+		Actual:     ident.Token,
+		Suggestion: "declare it with let instead of const to allow changing it",
+	})
+}
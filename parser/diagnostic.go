@@ -0,0 +1,163 @@
+package parser
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"monkey/token"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type Severity int
+
+const (
+	SeverityError Severity = iota
+	SeverityWarning
+)
+
+func (s Severity) String() string {
+	switch s {
+	case SeverityError:
+		return "error"
+	case SeverityWarning:
+		return "warning"
+	default:
+		return "Severity(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// Diagnostic codes are stable identifiers for each kind of problem, so that
+// tools can filter or document them independently of the message wording.
+const (
+	CodeUnexpectedToken     = "P0001" // a specific token was required
+	CodeExpectedExpr        = "P0002" // the token cannot start an expression
+	CodeInvalidInteger      = "P0003" // an integer literal is malformed
+	CodeIllegalCharacter    = "P0004" // the lexer could not recognise a character
+	CodeUnterminatedBlock   = "P0005" // end of input before a closing delimiter
+	CodeInvalidFloat        = "P0006" // a float literal is out of range
+	CodeBranchOutsideLoop   = "P0007" // break or continue is not inside a loop
+	CodeInvalidAssignment   = "P0008" // the left side of = cannot be assigned to
+	CodeConstantRebound     = "P0009" // a constant is assigned to or declared again
+	CodeInvalidEscape       = "P0010" // a string contains an unknown escape sequence
+	CodeUnterminatedComment = "P0011" // end of input inside a block comment
+)
+
+// Diagnostic describes a problem found in the source, covering the span
+// from Pos up to (but not including) End.
+type Diagnostic struct {
+	Severity Severity
+	Code     string
+	Message  string
+	Pos      token.Position
+	End      token.Position
+
+	// Expected lists the token types that would have been accepted, if the
+	// diagnostic is about an unexpected token. Actual is the token found.
+	Expected []token.TokenType
+	Actual   token.Token
+
+	// Suggestion is a short human readable hint on how to fix the problem,
+	// empty if there is none.
+	Suggestion string
+}
+
+// String formats the diagnostic on a single line as
+// file:line:column: severity[code]: message.
+func (d *Diagnostic) String() string {
+	return fmt.Sprintf("%s: %s[%s]: %s", d.Pos, d.Severity, d.Code, d.Message)
+}
+
+func (d *Diagnostic) Error() string { return d.String() }
+
+// Render formats the diagnostic together with the source line it refers to,
+// underlining the offending span with carets:
+//
+//	test.monkey:1:5: error[P0001]: expected next token to be IDENT, got = instead
+//	  |
+//	1 | let = 5;
+//	  |     ^
+//	  = help: add a name here, e.g. x
+func (d *Diagnostic) Render(source string) string {
+	var out bytes.Buffer
+	out.WriteString(d.String())
+	out.WriteString("\n")
+
+	line, ok := sourceLine(source, d.Pos.Line)
+	if ok {
+		number := strconv.Itoa(d.Pos.Line)
+		gutter := strings.Repeat(" ", len(number))
+		out.WriteString(gutter + " |\n")
+		out.WriteString(number + " | " + line + "\n")
+		out.WriteString(gutter + " | " + underline(line, d.Pos, d.End) + "\n")
+		if d.Suggestion != "" {
+			out.WriteString(gutter + " = help: " + d.Suggestion + "\n")
+		}
+	} else if d.Suggestion != "" {
+		out.WriteString("  = help: " + d.Suggestion + "\n")
+	}
+	return out.String()
+}
+
+// RenderDiagnostics writes every diagnostic rendered against source to w.
+func RenderDiagnostics(w io.Writer, source string, diagnostics []*Diagnostic) {
+	for _, d := range diagnostics {
+		io.WriteString(w, d.Render(source))
+	}
+}
+
+func sourceLine(source string, line int) (string, bool) {
+	if line < 1 {
+		return "", false
+	}
+	lines := strings.Split(source, "\n")
+	if line > len(lines) {
+		return "", false
+	}
+	return strings.TrimRight(lines[line-1], "\r"), true
+}
+
+// underline returns the caret marker for the part of the span that lies on
+// line. Tabs before the span are kept so the carets line up with the source.
+// Columns count bytes, but the marker has one character for each character
+// of the line, so multi-byte characters take one caret.
+func underline(line string, pos, end token.Position) string {
+	start := clampColumn(line, pos.Column)
+	stop := start
+	if end.Line == pos.Line {
+		stop = clampColumn(line, end.Column)
+	} else if end.Line > pos.Line {
+		stop = len(line)
+	}
+	if stop < start {
+		stop = start
+	}
+	width := utf8.RuneCountInString(line[start:stop])
+	if width < 1 {
+		width = 1
+	}
+
+	var out bytes.Buffer
+	for _, ch := range line[:start] {
+		if ch == '\t' {
+			out.WriteByte('\t')
+		} else {
+			out.WriteByte(' ')
+		}
+	}
+	out.WriteString(strings.Repeat("^", width))
+	return out.String()
+}
+
+// clampColumn returns the byte offset in line of column, kept within line.
+func clampColumn(line string, column int) int {
+	offset := column - 1
+	if offset < 0 {
+		return 0
+	}
+	if offset > len(line) {
+		return len(line)
+	}
+	return offset
+}
@@ -0,0 +1,107 @@
+package parser
+
+import (
+	"monkey/lexer"
+	"monkey/token"
+	"testing"
+)
+
+func TestDiagnostics(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedCode       string
+		expectedPos        string
+		expectedExpected   []token.TokenType
+		expectedActual     token.TokenType
+		expectedSuggestion string
+	}{
+		{"let = 5;", CodeUnexpectedToken, "1:5", []token.TokenType{token.IDENT}, token.ASSIGN, "add a name here, e.g. x"},
+		{"let x 5;", CodeUnexpectedToken, "1:7", []token.TokenType{token.ASSIGN}, token.INT, `insert "="`},
+		{"add(1, 2", CodeUnterminatedBlock, "1:9", []token.TokenType{token.RPAREN}, token.EOF, `insert ")"`},
+		{"let x = 09;", CodeInvalidInteger, "1:9", nil, token.INT, ""},
+		{"let x = @;", CodeIllegalCharacter, "1:9", nil, token.ILLEGAL, "remove the character"},
+		{"fn(x) { x", CodeUnterminatedBlock, "1:7", []token.TokenType{token.RBRACE}, token.EOF, `insert "}"`},
+		{`let s = "a\qb";`, CodeInvalidEscape, "1:11", nil, token.ILLEGAL, `use \n, \t, \r, \", \\ or \u{hex}, or write \\ for a backslash`},
+		{"let x = 1; /* x = 2;", CodeUnterminatedComment, "1:12", nil, token.ILLEGAL, `close the comment with "*/"`},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) == 0 {
+			t.Errorf("no diagnostics for %q", tt.input)
+			continue
+		}
+		d := diagnostics[0]
+		if d.Severity != SeverityError {
+			t.Errorf("%q: severity wrong. got=%s", tt.input, d.Severity)
+		}
+		if d.Code != tt.expectedCode {
+			t.Errorf("%q: code wrong. expected=%s, got=%s", tt.input, tt.expectedCode, d.Code)
+		}
+		if d.Pos.String() != tt.expectedPos {
+			t.Errorf("%q: pos wrong. expected=%s, got=%s", tt.input, tt.expectedPos, d.Pos)
+		}
+		if len(d.Expected) != len(tt.expectedExpected) {
+			t.Errorf("%q: expected tokens wrong. expected=%v, got=%v", tt.input, tt.expectedExpected, d.Expected)
+		} else {
+			for i := range d.Expected {
+				if d.Expected[i] != tt.expectedExpected[i] {
+					t.Errorf("%q: expected tokens wrong. expected=%v, got=%v", tt.input, tt.expectedExpected, d.Expected)
+				}
+			}
+		}
+		if d.Actual.Type != tt.expectedActual {
+			t.Errorf("%q: actual token wrong. expected=%s, got=%s", tt.input, tt.expectedActual, d.Actual.Type)
+		}
+		if d.Suggestion != tt.expectedSuggestion {
+			t.Errorf("%q: suggestion wrong. expected=%q, got=%q", tt.input, tt.expectedSuggestion, d.Suggestion)
+		}
+	}
+}
+
+func TestDiagnosticRender(t *testing.T) {
+	input := "let a = 1;\n\tlet b 2;"
+	p := New(lexer.NewFile("test.monkey", input))
+	p.ParseProgram()
+	if len(p.Diagnostics()) == 0 {
+		t.Fatalf("expected diagnostics")
+	}
+
+	expected := "test.monkey:2:8: error[P0001]: expected next token to be =, got INT instead\n" +
+		"  |\n" +
+		"2 | \tlet b 2;\n" +
+		"  | \t      ^\n" +
+		"  = help: insert \"=\"\n"
+	if got := p.Diagnostics()[0].Render(input); got != expected {
+		t.Errorf("Render() wrong.\nexpected=%q\ngot=     %q", expected, got)
+	}
+}
+
+func TestDiagnosticRenderMultiByte(t *testing.T) {
+	input := `let größe = "ä\q"; ö + §;`
+	p := New(lexer.NewFile("test.monkey", input))
+	p.ParseProgram()
+
+	expected := []string{
+		"test.monkey:1:18: error[P0010]: invalid escape sequence \\q in string\n" +
+			"  |\n" +
+			"1 | let größe = \"ä\\q\"; ö + §;\n" +
+			"  |               ^^\n" +
+			"  = help: use \\n, \\t, \\r, \\\", \\\\ or \\u{hex}, or write \\\\ for a backslash\n",
+		"test.monkey:1:28: error[P0004]: illegal character \"§\"\n" +
+			"  |\n" +
+			"1 | let größe = \"ä\\q\"; ö + §;\n" +
+			"  |                        ^\n" +
+			"  = help: remove the character\n",
+	}
+	diagnostics := p.Diagnostics()
+	if len(diagnostics) != len(expected) {
+		t.Fatalf("wrong number of diagnostics. want=%d, got=%d", len(expected), len(diagnostics))
+	}
+	for i, d := range diagnostics {
+		if got := d.Render(input); got != expected[i] {
+			t.Errorf("Render() wrong.\nexpected=%q\ngot=     %q", expected[i], got)
+		}
+	}
+}
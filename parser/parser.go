@@ -2,10 +2,13 @@ package parser
 
 import (
 	"fmt"
+	"math"
+	"math/big"
 	"monkey/ast"
 	"monkey/lexer"
 	"monkey/token"
 	"strconv"
+	"strings"
 )
 
 type (
@@ -14,11 +17,24 @@ type (
 )
 
 type Parser struct {
-	l      *lexer.Lexer
-	errors []string
+	l           *lexer.Lexer
+	diagnostics []*Diagnostic
+
+	// panicking is set from the first syntax error in a statement until the
+	// parser has synchronized, and suppresses the follow-up errors.
+	panicking bool
+	// blockDepth is the number of enclosing blocks being parsed.
+	blockDepth int
+	// loopDepth is the number of loops enclosing the current statement
+	// within the innermost function.
+	loopDepth int
 
 	curToken  token.Token
 	peekToken token.Token
+	// curDoc and peekDoc are the doc comments right before curToken and
+	// peekToken, one line each.
+	curDoc  []string
+	peekDoc []string
 
 	prefixParseFns map[token.TokenType]prefixParseFn
 	infixParseFns  map[token.TokenType]infixParseFn
@@ -33,22 +49,25 @@ func (p *Parser) registerInfix(token token.TokenType, fn infixParseFn) {
 }
 
 func New(lexer *lexer.Lexer) *Parser {
-	p := &Parser{l: lexer, errors: []string{}}
+	p := &Parser{l: lexer, diagnostics: []*Diagnostic{}}
 
 	// Prepare expression parsing
 	// prefix parse functions
 	p.prefixParseFns = make(map[token.TokenType]prefixParseFn)
 	p.registerPrefix(token.IDENT, p.parseIdentifier)
 	p.registerPrefix(token.INT, p.parseIntegerLiteral)
+	p.registerPrefix(token.FLOAT, p.parseFloatLiteral)
 	p.registerPrefix(token.TRUE, p.parseBoolean)
 	p.registerPrefix(token.FALSE, p.parseBoolean)
 	p.registerPrefix(token.MINUS, p.parsePrefix)
 	p.registerPrefix(token.BANG, p.parsePrefix)
 	p.registerPrefix(token.LPAREN, p.parseGroupedExpression)
 	p.registerPrefix(token.IF, p.parseIfExpression)
+	p.registerPrefix(token.TRY, p.parseTryExpression)
 	p.registerPrefix(token.FUNCTION, p.parseFunctionLiteral)
 	p.registerPrefix(token.STRING, p.parseStringLiteral)
 	p.registerPrefix(token.LBRACE, p.parseHashLiteral)
+	p.registerPrefix(token.LBRACKET, p.parseArrayLiteral)
 
 	// prefix operators: -(MINUS),!(BANG)
 
@@ -63,7 +82,16 @@ func New(lexer *lexer.Lexer) *Parser {
 	p.registerInfix(token.GT, p.parseInfix)
 	p.registerInfix(token.EQ, p.parseInfix)
 	p.registerInfix(token.NOT_EQ, p.parseInfix)
+	p.registerInfix(token.AND, p.parseInfix)
+	p.registerInfix(token.ASSIGN, p.parseAssign)
+	p.registerInfix(token.PLUS_ASSIGN, p.parseAssign)
+	p.registerInfix(token.MINUS_ASSIGN, p.parseAssign)
+	p.registerInfix(token.ASTERISK_ASSIGN, p.parseAssign)
+	p.registerInfix(token.SLASH_ASSIGN, p.parseAssign)
+	p.registerInfix(token.PERCENT_ASSIGN, p.parseAssign)
+	p.registerInfix(token.OR, p.parseInfix)
 	p.registerInfix(token.SLASH, p.parseInfix)
+	p.registerInfix(token.PERCENT, p.parseInfix)
 	p.registerInfix(token.LPAREN, p.parseCallExpression)
 	p.registerInfix(token.LBRACKET, p.parseIndexExpression)
 
@@ -76,70 +104,106 @@ func New(lexer *lexer.Lexer) *Parser {
 
 func (p *Parser) parseIndexExpression(left ast.Expression) ast.Expression {
 	exp := &ast.IndexExpression{Token: p.curToken, Left: left}
+	if !p.peekTokenIs(token.COLON) {
+		p.nextToken()
+		exp.Index = p.parseExpression(LOWEST)
+	}
+	if p.peekTokenIs(token.COLON) {
+		return p.parseSliceExpression(exp.Token, left, exp.Index)
+	}
+	if !p.expectPeek(token.RBRACKET) {
+		return p.badExpression(exp.Token)
+	}
+	exp.Rbracket = p.curToken
+	return exp
+}
+
+// parseSliceExpression parses the rest of left[low:high] from the colon.
+func (p *Parser) parseSliceExpression(tok token.Token, left, low ast.Expression) ast.Expression {
+	exp := &ast.SliceExpression{Token: tok, Left: left, Low: low}
 	p.nextToken()
-	exp.Index = p.parseExpression(LOWEST)
+	if !p.peekTokenIs(token.RBRACKET) {
+		p.nextToken()
+		exp.High = p.parseExpression(LOWEST)
+	}
 	if !p.expectPeek(token.RBRACKET) {
-		return nil
+		return p.badExpression(exp.Token)
 	}
+	exp.Rbracket = p.curToken
 	return exp
 }
 
 func (p *Parser) parseHashLiteral() ast.Expression {
 	hash := &ast.HashLiteral{Token: p.curToken}
-	hash.Pairs = make(map[ast.Expression]ast.Expression)
 	for !p.peekTokenIs(token.RBRACE) {
 		p.nextToken()
 		key := p.parseExpression(LOWEST)
 		if !p.expectPeek(token.COLON) {
-			return nil
+			return p.badExpression(hash.Token)
 		}
 		p.nextToken()
 		value := p.parseExpression(LOWEST)
-		hash.Pairs[key] = value
+		hash.Pairs = append(hash.Pairs, ast.HashLiteralPair{Key: key, Value: value})
 		if !p.peekTokenIs(token.RBRACE) && !p.expectPeek(token.COMMA) {
-			return nil
+			return p.badExpression(hash.Token)
 		}
 	}
 	if !p.expectPeek(token.RBRACE) {
-		return nil
+		return p.badExpression(hash.Token)
 	}
+	hash.Rbrace = p.curToken
 	return hash
 }
 
+func (p *Parser) parseArrayLiteral() ast.Expression {
+	array := &ast.ArrayLiteral{Token: p.curToken}
+	array.Elements = p.parseExpressionList(token.RBRACKET)
+	if p.curTokenIs(token.RBRACKET) {
+		array.Rbracket = p.curToken
+	}
+	return array
+}
+
 func (p *Parser) parseStringLiteral() ast.Expression {
 	return &ast.StringLiteral{Token: p.curToken, Value: p.curToken.Literal}
 }
 
 func (p *Parser) parseCallExpression(function ast.Expression) ast.Expression {
 	exp := &ast.CallExpression{Token: p.curToken, Function: function}
-	exp.Arguments = p.parseCallArguments()
+	exp.Arguments = p.parseExpressionList(token.RPAREN)
+	if p.curTokenIs(token.RPAREN) {
+		exp.Rparen = p.curToken
+	}
 	return exp
 }
 
-func (p *Parser) parseCallArguments() []ast.Expression {
-	args := []ast.Expression{}
-	if p.peekTokenIs(token.RPAREN) {
+// parseExpressionList parses comma separated expressions up to the end token,
+// which is shared by call arguments and array literals.
+func (p *Parser) parseExpressionList(end token.TokenType) []ast.Expression {
+	list := []ast.Expression{}
+	if p.peekTokenIs(end) {
 		p.nextToken()
-		return args
+		return list
 	}
 	p.nextToken()
-	args = append(args, p.parseExpression(LOWEST))
+	list = append(list, p.parseExpression(LOWEST))
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
 		p.nextToken()
-		args = append(args, p.parseExpression(LOWEST))
+		list = append(list, p.parseExpression(LOWEST))
 	}
-	if !p.expectPeek(token.RPAREN) {
+	if !p.expectPeek(end) {
 		return nil
 	}
-	return args
+	return list
 }
 
 func (p *Parser) parseGroupedExpression() ast.Expression {
+	lparen := p.curToken
 	p.nextToken()
 	exp := p.parseExpression(LOWEST)
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(lparen)
 	}
 	return exp
 }
@@ -163,6 +227,28 @@ func (p *Parser) parseInfix(left ast.Expression) ast.Expression {
 	return io
 }
 
+// parseAssign parses an assignment. Assignment is right associative, so
+// a = b = c assigns c to b and then to a.
+func (p *Parser) parseAssign(target ast.Expression) ast.Expression {
+	exp := &ast.AssignExpression{Token: p.curToken, Operator: p.curToken.Literal, Target: target}
+	switch target.(type) {
+	case *ast.Identifier, *ast.IndexExpression:
+	default:
+		p.report(&Diagnostic{
+			Code:       CodeInvalidAssignment,
+			Message:    fmt.Sprintf("cannot assign to %s", target.String()),
+			Pos:        target.Pos(),
+			End:        target.End(),
+			Actual:     p.curToken,
+			Suggestion: "only variables and index expressions like a[i] can be assigned to",
+		})
+		return p.badExpression(p.curToken)
+	}
+	p.nextToken()
+	exp.Value = p.parseExpression(ASSIGN - 1)
+	return exp
+}
+
 func (p *Parser) parseIdentifier() ast.Expression {
 	return &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 }
@@ -172,9 +258,37 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 
 	value, err := strconv.ParseInt(p.curToken.Literal, 0, 64)
 	if err != nil {
-		msg := fmt.Sprintf("could not parse %q as integer", p.curToken.Literal)
-		p.errors = append(p.errors, msg)
-		return nil
+		if value, ok := new(big.Int).SetString(p.curToken.Literal, 0); ok {
+			return &ast.BigIntLiteral{Token: p.curToken, Value: value}
+		}
+		p.report(&Diagnostic{
+			Code:    CodeInvalidInteger,
+			Message: fmt.Sprintf("could not parse %q as integer", p.curToken.Literal),
+			Pos:     p.curToken.Pos,
+			End:     p.curToken.End,
+			Actual:  p.curToken,
+		})
+		return p.badExpression(lit.Token)
+	}
+	lit.Value = value
+
+	return lit
+}
+
+func (p *Parser) parseFloatLiteral() ast.Expression {
+	lit := &ast.FloatLiteral{Token: p.curToken}
+
+	value, err := strconv.ParseFloat(p.curToken.Literal, 64)
+	if err != nil {
+		p.report(&Diagnostic{
+			Code:       CodeInvalidFloat,
+			Message:    fmt.Sprintf("could not parse %q as float", p.curToken.Literal),
+			Pos:        p.curToken.Pos,
+			End:        p.curToken.End,
+			Actual:     p.curToken,
+			Suggestion: fmt.Sprintf("floats must lie between %g and %g", -math.MaxFloat64, math.MaxFloat64),
+		})
+		return p.badExpression(lit.Token)
 	}
 	lit.Value = value
 
@@ -184,39 +298,119 @@ func (p *Parser) parseIntegerLiteral() ast.Expression {
 func (p *Parser) parseIfExpression() ast.Expression {
 	expression := &ast.IfExpression{Token: p.curToken}
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(expression.Token)
 	}
 	p.nextToken()
 	expression.Condition = p.parseExpression(LOWEST)
 	if !p.expectPeek(token.RPAREN) {
-		return nil
+		return p.badExpression(expression.Token)
+	}
+	expression.Consequence = p.parseBranch()
+
+	if p.peekTokenIs(token.ELSE) {
+		p.nextToken()
+		expression.Alternative = p.parseBranch()
 	}
+	return expression
+}
+
+// parseTryExpression parses try { ... } followed by catch (e) { ... },
+// finally { ... } or both. The parameter of catch may be left out.
+func (p *Parser) parseTryExpression() ast.Expression {
+	expression := &ast.TryExpression{Token: p.curToken}
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(expression.Token)
 	}
-	expression.Consequence = p.parseBlockStatement()
+	expression.Block = p.parseBlockStatement()
 
-	if p.peekTokenIs(token.ELSE) {
+	if p.peekTokenIs(token.CATCH) {
+		p.nextToken()
+		if p.peekTokenIs(token.LPAREN) {
+			p.nextToken()
+			if !p.expectPeek(token.IDENT) {
+				return p.badExpression(expression.Token)
+			}
+			expression.Parameter = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+			if !p.expectPeek(token.RPAREN) {
+				return p.badExpression(expression.Token)
+			}
+		}
+		if !p.expectPeek(token.LBRACE) {
+			return p.badExpression(expression.Token)
+		}
+		expression.Catch = p.parseBlockStatement()
+	}
+	if p.peekTokenIs(token.FINALLY) {
 		p.nextToken()
 		if !p.expectPeek(token.LBRACE) {
-			return nil
+			return p.badExpression(expression.Token)
 		}
-		expression.Alternative = p.parseBlockStatement()
+		expression.Finally = p.parseBlockStatement()
+	}
+
+	if expression.Catch == nil && expression.Finally == nil {
+		p.report(&Diagnostic{
+			Code: CodeUnexpectedToken,
+			Message: fmt.Sprintf("expected next token to be %s or %s, got %s instead",
+				token.CATCH, token.FINALLY, p.peekToken.Type),
+			Pos:        p.peekToken.Pos,
+			End:        p.peekToken.End,
+			Expected:   []token.TokenType{token.CATCH, token.FINALLY},
+			Actual:     p.peekToken,
+			Suggestion: "add a catch or finally block after the try block",
+		})
+		return p.badExpression(expression.Token)
 	}
 	return expression
 }
 
+// parseBranch parses the body of an if or else. Like C, a body is either a
+// braced block or a single statement, e.g. `if (x) return y;` or `else if`.
+func (p *Parser) parseBranch() *ast.BlockStatement {
+	if p.peekTokenIs(token.LBRACE) {
+		p.nextToken()
+		return p.parseBlockStatement()
+	}
+	p.nextToken()
+	block := &ast.BlockStatement{Token: p.curToken}
+	block.Statements = []ast.Statement{}
+	if stmt := p.parseStatement(); stmt != nil {
+		block.Statements = append(block.Statements, stmt)
+	}
+	return block
+}
+
 func (p *Parser) parseBlockStatement() *ast.BlockStatement {
 	block := &ast.BlockStatement{Token: p.curToken}
 	block.Statements = []ast.Statement{}
+	p.blockDepth++
+	defer func() { p.blockDepth-- }()
 	p.nextToken()
 	for !p.curTokenIs(token.RBRACE) && !p.curTokenIs(token.EOF) {
+		start := p.curToken
 		stmt := p.parseStatement()
+		if p.panicking {
+			block.Statements = append(block.Statements, p.recoverStatement(start, stmt))
+			continue
+		}
 		if stmt != nil {
 			block.Statements = append(block.Statements, stmt)
 		}
 		p.nextToken()
 	}
+	if p.curTokenIs(token.RBRACE) {
+		block.Rbrace = p.curToken
+	} else {
+		p.report(&Diagnostic{
+			Code:       CodeUnterminatedBlock,
+			Message:    "expected } to close the block, got EOF instead",
+			Pos:        block.Token.Pos,
+			End:        block.Token.End,
+			Expected:   []token.TokenType{token.RBRACE},
+			Actual:     p.curToken,
+			Suggestion: `insert "}"`,
+		})
+	}
 	return block
 }
 
@@ -224,23 +418,124 @@ func (p *Parser) parseBoolean() ast.Expression {
 	return &ast.Boolean{Token: p.curToken, Value: p.curTokenIs(token.TRUE)}
 }
 
+// Errors returns the messages of all error diagnostics, each prefixed with
+// the source position it refers to.
 func (p *Parser) Errors() []string {
-	return p.errors
+	errors := []string{}
+	for _, d := range p.diagnostics {
+		if d.Severity == SeverityError {
+			errors = append(errors, d.Pos.String()+": "+d.Message)
+		}
+	}
+	return errors
+}
+
+// Diagnostics returns everything reported while parsing, in source order.
+func (p *Parser) Diagnostics() []*Diagnostic {
+	return p.diagnostics
+}
+
+// report records d unless the parser is still recovering from an earlier
+// error in the same statement, in which case d is most likely a consequence
+// of that error rather than a problem of its own.
+func (p *Parser) report(d *Diagnostic) {
+	if p.panicking {
+		return
+	}
+	if d.Severity == SeverityError {
+		p.panicking = true
+	}
+	p.diagnostics = append(p.diagnostics, d)
 }
 
 func (p *Parser) peekError(t token.TokenType) {
-	msg := fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type)
-	p.errors = append(p.errors, msg)
+	code := CodeUnexpectedToken
+	if p.peekTokenIs(token.EOF) {
+		code = CodeUnterminatedBlock
+	}
+	p.report(&Diagnostic{
+		Code:       code,
+		Message:    fmt.Sprintf("expected next token to be %s, got %s instead", t, p.peekToken.Type),
+		Pos:        p.peekToken.Pos,
+		End:        p.peekToken.End,
+		Expected:   []token.TokenType{t},
+		Actual:     p.peekToken,
+		Suggestion: suggestToken(t),
+	})
 }
 
 func (p *Parser) curError(t ...token.TokenType) {
-	msg := fmt.Sprintf("expected current token to be one of %s, got %s instead", t, p.curToken.Type)
-	p.errors = append(p.errors, msg)
+	p.report(&Diagnostic{
+		Code:     CodeUnexpectedToken,
+		Message:  fmt.Sprintf("expected current token to be one of %s, got %s instead", t, p.curToken.Type),
+		Pos:      p.curToken.Pos,
+		End:      p.curToken.End,
+		Expected: t,
+		Actual:   p.curToken,
+	})
+}
+
+func (p *Parser) noPrefixParseFnError() {
+	d := &Diagnostic{
+		Code:    CodeExpectedExpr,
+		Message: fmt.Sprintf("no prefix parse function for %s found", p.curToken.Type),
+		Pos:     p.curToken.Pos,
+		End:     p.curToken.End,
+		Actual:  p.curToken,
+	}
+	switch p.curToken.Type {
+	case token.ILLEGAL:
+		if p.curToken.Literal == "/*" {
+			d.Code = CodeUnterminatedComment
+			d.Message = "block comment is not terminated"
+			d.Suggestion = `close the comment with "*/"`
+			break
+		}
+		if strings.HasPrefix(p.curToken.Literal, `\`) {
+			d.Code = CodeInvalidEscape
+			d.Message = fmt.Sprintf("invalid escape sequence %s in string", p.curToken.Literal)
+			d.Suggestion = `use \n, \t, \r, \", \\ or \u{hex}, or write \\ for a backslash`
+			break
+		}
+		d.Code = CodeIllegalCharacter
+		d.Message = fmt.Sprintf("illegal character %q", p.curToken.Literal)
+		d.Suggestion = "remove the character"
+	case token.EOF:
+		d.Message = "unexpected end of input, expected an expression"
+	}
+	p.report(d)
+}
+
+// suggestToken describes how to supply a missing token of type t. For
+// punctuation the token type is its literal, so inserting it is the fix.
+func suggestToken(t token.TokenType) string {
+	switch t {
+	case token.IDENT:
+		return "add a name here, e.g. x"
+	case token.EOF, token.ILLEGAL, token.INT, token.STRING:
+		return ""
+	}
+	if len(t) <= 2 {
+		return fmt.Sprintf("insert %q", string(t))
+	}
+	return ""
 }
 
 func (p *Parser) nextToken() {
-	p.curToken = p.peekToken
-	p.peekToken = p.l.NextToken()
+	p.curToken, p.curDoc = p.peekToken, p.peekDoc
+	p.peekToken, p.peekDoc = p.readToken()
+}
+
+// readToken returns the next token from the lexer with the doc comments
+// that precede it.
+func (p *Parser) readToken() (token.Token, []string) {
+	var doc []string
+	tok := p.l.NextToken()
+	for tok.Type == token.DOC_COMMENT {
+		doc = append(doc, tok.Literal)
+		tok = p.l.NextToken()
+	}
+	return tok, doc
 }
 
 // ParseProgram build a data structure (ast, abstract syntax tree) from the lexer output.
@@ -248,44 +543,124 @@ func (p *Parser) ParseProgram() *ast.Program {
 	program := &ast.Program{}
 	program.Statements = []ast.Statement{}
 	for p.curToken.Type != token.EOF {
+		start := p.curToken
 		stmt := p.parseStatement()
+		if p.panicking {
+			program.Statements = append(program.Statements, p.recoverStatement(start, stmt))
+			continue
+		}
 		if stmt != nil {
 			program.Statements = append(program.Statements, stmt)
 		}
 		p.nextToken() // skip semicolon
 	}
+	if len(p.diagnostics) == 0 {
+		p.checkConstants(program)
+	}
 	return program
 }
 
+// recoverStatement is called after a syntax error in the statement that
+// begins with start. It synchronizes the parser and returns what could be
+// parsed of the statement, or an ast.BadStatement covering the skipped
+// source. The current token is left on the first token of the next
+// statement, or on the '}' closing the enclosing block.
+func (p *Parser) recoverStatement(start token.Token, stmt ast.Statement) ast.Statement {
+	end := p.synchronize(start)
+	if stmt == nil {
+		return &ast.BadStatement{Token: start, From: start.Pos, To: end}
+	}
+	return stmt
+}
+
+// synchronize skips tokens until parsing can safely resume: after a ';', in
+// front of a '}' that closes the enclosing block, or in front of a keyword
+// that starts a statement, such as 'let' or 'return'. Nested blocks are
+// skipped as a whole. It returns the end position of the last skipped token.
+func (p *Parser) synchronize(start token.Token) token.Position {
+	defer func() { p.panicking = false }()
+
+	end := start.End
+	depth := 0
+	for !p.curTokenIs(token.EOF) {
+		if depth == 0 && p.curToken.Pos.Offset > start.Pos.Offset {
+			switch p.curToken.Type {
+			case token.LET, token.CONST, token.RETURN, token.THROW, token.FUNCTION, token.WHILE, token.FOR:
+				return end
+			case token.RBRACE:
+				if p.blockDepth > 0 {
+					return end
+				}
+			}
+		}
+		switch p.curToken.Type {
+		case token.LBRACE:
+			depth++
+		case token.RBRACE:
+			if depth > 0 {
+				depth--
+			}
+		case token.SEMICOLON:
+			if depth == 0 {
+				end = p.curToken.End
+				p.nextToken()
+				return end
+			}
+		}
+		end = p.curToken.End
+		p.nextToken()
+	}
+	return end
+}
+
+// badExpression marks the source from start up to the current token as an
+// expression that could not be parsed.
+func (p *Parser) badExpression(start token.Token) ast.Expression {
+	return &ast.BadExpression{Token: start, From: start.Pos, To: p.curToken.End}
+}
+
 func (p *Parser) parseStatement() ast.Statement {
 	switch p.curToken.Type {
-	case token.LET:
+	case token.LET, token.CONST:
 		return p.parseLetStatement()
 	case token.RETURN:
 		return p.parseReturnStatement()
+	case token.THROW:
+		return p.parseThrowStatement()
+	case token.WHILE:
+		return p.parseWhileStatement()
+	case token.FOR:
+		return p.parseForStatement()
+	case token.BREAK, token.CONTINUE:
+		return p.parseBranchStatement()
+	case token.LBRACE:
+		if p.isHashLiteralStart() {
+			return p.parseExpressionStatement()
+		}
+		return p.parseBlockStatement()
 	default:
 		return p.parseExpressionStatement()
 	}
 }
 
 func (p *Parser) parseLetStatement() ast.Statement {
-	stmt := &ast.LetStatement{Token: p.curToken}
+	stmt := &ast.LetStatement{Token: p.curToken, Doc: strings.Join(p.curDoc, "\n")}
 
 	if !p.expectPeek(token.IDENT) {
-		// error by returning nil
-		p.peekError(token.IDENT)
 		return nil
 	}
 
 	stmt.Name = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 
 	if !p.expectPeek(token.ASSIGN) {
-		p.peekError(token.ASSIGN)
 		return nil
 	}
 
 	p.nextToken()
 	stmt.Value = p.parseExpression(LOWEST)
+	if fl, ok := stmt.Value.(*ast.FunctionLiteral); ok {
+		fl.Name = stmt.Name.Value
+	}
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -294,6 +669,22 @@ func (p *Parser) parseLetStatement() ast.Statement {
 	return stmt
 }
 
+// isHashLiteralStart reports whether the current '{' opens a hash literal
+// rather than a block. A hash is either empty or has a key followed by ':'.
+// The key may be any expression, so it is parsed ahead and the parser is
+// put back as it was, discarding any errors found on the way.
+func (p *Parser) isHashLiteralStart() bool {
+	if p.peekTokenIs(token.RBRACE) {
+		return true
+	}
+	saved, lexer := *p, *p.l
+	p.nextToken()
+	p.parseExpression(LOWEST)
+	isHash := p.peekTokenIs(token.COLON)
+	*p, *p.l = saved, lexer
+	return isHash
+}
+
 func (p *Parser) curTokenIs(tokenType token.TokenType) bool {
 	return p.curToken.Type == tokenType
 }
@@ -307,6 +698,7 @@ func (p *Parser) expectPeek(tokenType token.TokenType) bool {
 		p.nextToken()
 		return true
 	} else {
+		p.peekError(tokenType)
 		return false
 	}
 }
@@ -322,10 +714,83 @@ func (p *Parser) parseReturnStatement() ast.Statement {
 	return stmt
 }
 
+func (p *Parser) parseThrowStatement() ast.Statement {
+	stmt := &ast.ThrowStatement{Token: p.curToken}
+	p.nextToken()
+	stmt.Value = p.parseExpression(LOWEST)
+
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
+func (p *Parser) parseWhileStatement() ast.Statement {
+	stmt := &ast.WhileStatement{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Condition = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+	return stmt
+}
+
+func (p *Parser) parseForStatement() ast.Statement {
+	stmt := &ast.ForStatement{Token: p.curToken}
+	if !p.expectPeek(token.LPAREN) || !p.expectPeek(token.IDENT) {
+		return nil
+	}
+	stmt.Variable = &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
+	if !p.expectPeek(token.IN) {
+		return nil
+	}
+	p.nextToken()
+	stmt.Iterable = p.parseExpression(LOWEST)
+	if !p.expectPeek(token.RPAREN) {
+		return nil
+	}
+	stmt.Body = p.parseLoopBody()
+	return stmt
+}
+
+// parseLoopBody parses the body of a loop and the semicolon that may follow
+// it, as it may follow any other statement.
+func (p *Parser) parseLoopBody() *ast.BlockStatement {
+	p.loopDepth++
+	body := p.parseBranch()
+	p.loopDepth--
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return body
+}
+
+func (p *Parser) parseBranchStatement() ast.Statement {
+	stmt := &ast.BranchStatement{Token: p.curToken}
+	if p.loopDepth == 0 {
+		p.report(&Diagnostic{
+			Code:    CodeBranchOutsideLoop,
+			Message: fmt.Sprintf("%s outside of a loop", p.curToken.Literal),
+			Pos:     p.curToken.Pos,
+			End:     p.curToken.End,
+			Actual:  p.curToken,
+		})
+	}
+	if p.peekTokenIs(token.SEMICOLON) {
+		p.nextToken()
+	}
+	return stmt
+}
+
 func (p *Parser) parseExpression(precedence int) ast.Expression {
 	prefix := p.prefixParseFns[p.curToken.Type]
 	if prefix == nil {
-		return nil
+		p.noPrefixParseFnError()
+		return p.badExpression(p.curToken)
 	}
 	leftExp := prefix()
 
@@ -344,6 +809,9 @@ func (p *Parser) parseExpression(precedence int) ast.Expression {
 const (
 	_ int = iota
 	LOWEST
+	ASSIGN
+	LOGICAL_OR
+	LOGICAL_AND
 	EQUALS
 	LESSGREATER
 	SUM
@@ -354,20 +822,30 @@ const (
 )
 
 var precedences = map[token.TokenType]int{
-	token.EQ:       EQUALS,
-	token.NOT_EQ:   EQUALS,
-	token.LT:       LESSGREATER,
-	token.GT:       LESSGREATER,
-	token.PLUS:     SUM,
-	token.MINUS:    SUM,
-	token.SLASH:    PRODUCT,
-	token.ASTERISK: PRODUCT,
-	token.LPAREN:   CALL,
-	token.LBRACKET: INDEX,
+	token.ASSIGN:          ASSIGN,
+	token.PLUS_ASSIGN:     ASSIGN,
+	token.MINUS_ASSIGN:    ASSIGN,
+	token.ASTERISK_ASSIGN: ASSIGN,
+	token.SLASH_ASSIGN:    ASSIGN,
+	token.PERCENT_ASSIGN:  ASSIGN,
+	token.OR:              LOGICAL_OR,
+	token.AND:             LOGICAL_AND,
+	token.EQ:              EQUALS,
+	token.NOT_EQ:          EQUALS,
+	token.LT:              LESSGREATER,
+	token.GT:              LESSGREATER,
+	token.PLUS:            SUM,
+	token.MINUS:           SUM,
+	token.SLASH:           PRODUCT,
+	token.ASTERISK:        PRODUCT,
+	token.PERCENT:         PRODUCT,
+	token.LPAREN:          CALL,
+	token.LBRACKET:        INDEX,
 }
 
 func (p *Parser) parseExpressionStatement() ast.Statement {
-	stmt := &ast.ExpressionStatement{Token: p.curToken, Expression: p.parseExpression(LOWEST)}
+	stmt := &ast.ExpressionStatement{Token: p.curToken}
+	stmt.Expression = p.parseExpression(LOWEST)
 
 	if p.peekTokenIs(token.SEMICOLON) {
 		p.nextToken()
@@ -392,13 +870,17 @@ func (p *Parser) curPrecedence() int {
 func (p *Parser) parseFunctionLiteral() ast.Expression {
 	lit := &ast.FunctionLiteral{Token: p.curToken}
 	if !p.expectPeek(token.LPAREN) {
-		return nil
+		return p.badExpression(lit.Token)
 	}
 	lit.Parameters = p.parseFunctionParameters()
 	if !p.expectPeek(token.LBRACE) {
-		return nil
+		return p.badExpression(lit.Token)
 	}
+	// A break in the function body cannot leave a loop around the function.
+	loopDepth := p.loopDepth
+	p.loopDepth = 0
 	lit.Body = p.parseBlockStatement()
+	p.loopDepth = loopDepth
 	return lit
 }
 
@@ -408,12 +890,16 @@ func (p *Parser) parseFunctionParameters() []*ast.Identifier {
 		p.nextToken()
 		return identifiers
 	}
-	p.nextToken()
+	if !p.expectPeek(token.IDENT) {
+		return nil
+	}
 	ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 	identifiers = append(identifiers, ident)
 	for p.peekTokenIs(token.COMMA) {
 		p.nextToken()
-		p.nextToken()
+		if !p.expectPeek(token.IDENT) {
+			return nil
+		}
 		ident := &ast.Identifier{Token: p.curToken, Value: p.curToken.Literal}
 		identifiers = append(identifiers, ident)
 	}
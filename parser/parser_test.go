@@ -52,6 +52,50 @@ let foobar = 838383;
 	}
 }
 
+func TestDocComments(t *testing.T) {
+	input := `
+/// Adds two numbers.
+///
+/// Both must be integers.
+let add = fn(a, b) {
+	/// The sum.
+	const sum = a + b; // not documented
+	sum
+};
+// A plain comment.
+let plain = 1;
+/// Not attached to a let.
+plain;
+/* a block */ let last = 2;
+`
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+	checkParseErrors(t, p)
+
+	tests := []struct {
+		index       int
+		expectedDoc string
+	}{
+		{0, "Adds two numbers.\n\nBoth must be integers."},
+		{1, ""},
+		{3, ""},
+	}
+	for _, tt := range tests {
+		stmt, ok := program.Statements[tt.index].(*ast.LetStatement)
+		if !ok {
+			t.Fatalf("statement %d is not *ast.LetStatement. got=%T", tt.index, program.Statements[tt.index])
+		}
+		if stmt.Doc != tt.expectedDoc {
+			t.Errorf("%s: wrong doc. want=%q, got=%q", stmt.Name.Value, tt.expectedDoc, stmt.Doc)
+		}
+	}
+
+	body := program.Statements[0].(*ast.LetStatement).Value.(*ast.FunctionLiteral).Body
+	if sum := body.Statements[0].(*ast.LetStatement); sum.Doc != "The sum." {
+		t.Errorf("sum: wrong doc. want=%q, got=%q", "The sum.", sum.Doc)
+	}
+}
+
 func checkParseErrors(t *testing.T, p *Parser) {
 	errors := p.Errors()
 	if len(errors) == 0 {
@@ -159,7 +203,7 @@ func TestParser_ParseInfixOperator(t *testing.T) {
 	program := p.ParseProgram()
 	checkParseErrors(t, p)
 
-	answers := []string{"(3 + 4);", "((-3) + 4);"}
+	answers := []string{"(3 + 4)", "((-3) + 4)"}
 
 	for i, stmt := range program.Statements {
 		println(i, stmt.String())
@@ -257,6 +301,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"-a * b",
 			"((-a) * b)",
 		},
+		{
+			"a || b && c == d",
+			"(a || (b && (c == d)))",
+		},
+		{
+			"a && b || !c",
+			"((a && b) || (!c))",
+		},
 		{
 			"!-a",
 			"(!(-a))",
@@ -281,6 +333,22 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"a + b / c",
 			"(a + (b / c))",
 		},
+		{
+			"a % b * c - d",
+			"(((a % b) * c) - d)",
+		},
+		{
+			"-99999999999999999999 + 1",
+			"((-99999999999999999999) + 1)",
+		},
+		{
+			"a[1:2] + b[:n - 1][i:]",
+			"((a[1:2]) + ((b[:(n - 1)])[i:]))",
+		},
+		{
+			"s[:]",
+			"(s[:])",
+		},
 		{
 			"a + b * c + d / e - f",
 			"(((a + (b * c)) + (d / e)) - f)",
@@ -325,6 +393,14 @@ func TestOperatorPrecedenceParsing(t *testing.T) {
 			"!(true == true)",
 			"(!(true == true))",
 		},
+		{
+			"a * [1, 2, 3, 4][b * c] * d",
+			"((a * ([1, 2, 3, 4][(b * c)])) * d)",
+		},
+		{
+			"add(a * b[2], b[1], 2 * [1, 2][1])",
+			"add((a * (b[2])), (b[1]), (2 * ([1, 2][1])))",
+		},
 	}
 	for _, tt := range tests {
 		l := lexer.New(tt.input)
@@ -488,3 +564,412 @@ func TestStringLiteralExpression(t *testing.T) {
 		t.Errorf("literal.Value not %q. got=%q", "hello world", literal.Value)
 	}
 }
+
+func TestFloatLiteralExpression(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected float64
+	}{
+		{"3.14", 3.14},
+		{"1e-9", 1e-9},
+		{"2.5E3", 2500},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+
+		stmt := program.Statements[0].(*ast.ExpressionStatement)
+		literal, ok := stmt.Expression.(*ast.FloatLiteral)
+		if !ok {
+			t.Fatalf("exp not *ast.FloatLiteral. got=%T", stmt.Expression)
+		}
+		if literal.Value != tt.expected {
+			t.Errorf("literal.Value not %g. got=%g", tt.expected, literal.Value)
+		}
+		if literal.String() != tt.input {
+			t.Errorf("literal.String() not %q. got=%q", tt.input, literal.String())
+		}
+	}
+
+	p := New(lexer.New("1e999"))
+	p.ParseProgram()
+	if len(p.Diagnostics()) != 1 || p.Diagnostics()[0].Code != CodeInvalidFloat {
+		t.Errorf("expected an invalid float diagnostic. got=%v", p.Errors())
+	}
+}
+
+func TestLoopStatements(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"while (x < 10) { x; }", "while(x < 10) x"},
+		{"while (true) break;", "whiletrue break;"},
+		{"for (x in [1, 2]) { continue; x }", "for(x in [1, 2]) continue;x"},
+		{"for (c in s) for (d in t) break", "for(c in s) for(d in t) break;"},
+		{"while (x) { x; };", "whilex x"},
+		{"for (x in a) { x };", "for(x in a) x"},
+		{"let f = fn() { for (i in a) { i }; 9 };", "let f = fn() for(i in a) i9;"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("%q: expected 1 statement. got=%d", tt.input, len(program.Statements))
+		}
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestBranchOutsideLoop(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"break;", "1:1: error[P0007]: break outside of a loop"},
+		{"if (x) { continue }", "1:10: error[P0007]: continue outside of a loop"},
+		{"while (x) { fn() { break } }", "1:20: error[P0007]: break outside of a loop"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 || diagnostics[0].String() != tt.expected {
+			t.Errorf("%q: want=%q, got=%v", tt.input, tt.expected, p.Errors())
+		}
+	}
+}
+
+func TestTryExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"try { f() } catch (e) { e }", "try f() catch (e) e"},
+		{"try { f() } catch { 1 }", "try f() catch 1"},
+		{"try { f() } finally { g() }", "try f() finally g()"},
+		{"let x = try { f() } catch (e) { 0 } finally { g() };", "let x = try f() catch (e) 0 finally g();"},
+		{`throw "boom";`, "throw boom;"},
+		{`throw {"message": m}`, "throw {message:m};"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	p := New(lexer.New("try { f() } g()"))
+	p.ParseProgram()
+	diagnostics := p.Diagnostics()
+	expected := "1:13: error[P0001]: expected next token to be CATCH or FINALLY, got IDENT instead"
+	if len(diagnostics) == 0 || diagnostics[0].String() != expected {
+		t.Errorf("want=%q, got=%v", expected, p.Errors())
+	}
+}
+
+func TestAssignExpressions(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{"x = 5", "(x = 5)"},
+		{"x += 1 * 2", "(x += (1 * 2))"},
+		{"a = b = c", "(a = (b = c))"},
+		{"a[i + 1] -= x || y", "((a[(i + 1)]) -= (x || y))"},
+		{`h["k"] /= 2`, `((h[k]) /= 2)`},
+		{"f(x = 1)", "f((x = 1))"},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		program := p.ParseProgram()
+		checkParseErrors(t, p)
+		if got := program.String(); got != tt.expected {
+			t.Errorf("%q: want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+
+	for _, input := range []string{"1 = 2", "f() = 1", "a + b = c"} {
+		p := New(lexer.New(input))
+		p.ParseProgram()
+		diagnostics := p.Diagnostics()
+		if len(diagnostics) != 1 || diagnostics[0].Code != CodeInvalidAssignment {
+			t.Errorf("%q: expected an invalid assignment diagnostic. got=%v", input, p.Errors())
+		}
+	}
+}
+
+func TestConstantChecks(t *testing.T) {
+	tests := []struct {
+		input    string
+		expected []string
+	}{
+		{"const x = 1; x", nil},
+		{"const x = 1; x = 2;", []string{"1:14: error[P0009]: cannot assign to constant x"}},
+		{"const x = 1; x += 2;", []string{"1:14: error[P0009]: cannot assign to constant x"}},
+		{"const x = 1; let x = 2;", []string{"1:18: error[P0009]: cannot redeclare constant x"}},
+		{"const x = 1; const x = 2;", []string{"1:20: error[P0009]: cannot redeclare constant x"}},
+		{"const x = 1; for (x in []) {}", []string{"1:19: error[P0009]: cannot redeclare constant x"}},
+		{"const x = 1; try { f() } catch (e) { x = 2 }", []string{"1:38: error[P0009]: cannot assign to constant x"}},
+		{"const x = 1; let f = fn() { x = 2 };", []string{"1:29: error[P0009]: cannot assign to constant x"}},
+		{"const x = 1; while (true) { if (x) { x = 2 } }", []string{"1:38: error[P0009]: cannot assign to constant x"}},
+		// Shadowing in a function and declarations that may not run are
+		// left to the runtime.
+		{"const x = 1; let f = fn(x) { x = 2 };", nil},
+		{"const e = 1; try { f() } catch (e) { e = 2 }", nil},
+		{"const x = 1; let f = fn() { let x = 2; x = 3 };", nil},
+		{"if (c) { const x = 1 } x = 2;", nil},
+		{"let f = fn() { x = 2 }; const x = 1;", nil},
+	}
+	for _, tt := range tests {
+		p := New(lexer.New(tt.input))
+		p.ParseProgram()
+		errors := p.Errors()
+		if len(errors) != len(tt.expected) {
+			t.Errorf("%q: want %d errors, got=%v", tt.input, len(tt.expected), errors)
+			continue
+		}
+		for i, want := range tt.expected {
+			if p.Diagnostics()[i].String() != want {
+				t.Errorf("%q: want=%q, got=%q", tt.input, want, p.Diagnostics()[i].String())
+			}
+		}
+	}
+}
+
+func TestParsingArrayLiterals(t *testing.T) {
+	input := "[1, 2 * 2, 3 + 3]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.ExpressionStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.ExpressionStatement. got=%T",
+			program.Statements[0])
+	}
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("exp not ast.ArrayLiteral. got=%T", stmt.Expression)
+	}
+	if len(array.Elements) != 3 {
+		t.Fatalf("len(array.Elements) not 3. got=%d", len(array.Elements))
+	}
+	testIntegerLiteral(t, array.Elements[0], 1)
+	testInfixExpression(t, array.Elements[1], 2, "*", 2)
+	testInfixExpression(t, array.Elements[2], 3, "+", 3)
+}
+
+func TestParsingEmptyArrayLiteral(t *testing.T) {
+	input := "[]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	array, ok := stmt.Expression.(*ast.ArrayLiteral)
+	if !ok {
+		t.Fatalf("exp not ast.ArrayLiteral. got=%T", stmt.Expression)
+	}
+	if len(array.Elements) != 0 {
+		t.Errorf("len(array.Elements) not 0. got=%d", len(array.Elements))
+	}
+}
+
+func TestParsingIndexExpressions(t *testing.T) {
+	input := "myArray[1 + 1]"
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt := program.Statements[0].(*ast.ExpressionStatement)
+	indexExp, ok := stmt.Expression.(*ast.IndexExpression)
+	if !ok {
+		t.Fatalf("exp not *ast.IndexExpression. got=%T", stmt.Expression)
+	}
+	if !testIdentifier(t, indexExp.Left, "myArray") {
+		return
+	}
+	if !testInfixExpression(t, indexExp.Index, 1, "+", 1) {
+		return
+	}
+}
+
+func TestBlockAndHashStatements(t *testing.T) {
+	tests := []struct {
+		input        string
+		expectedType string
+	}{
+		{"{ return 10; }", "*ast.BlockStatement"},
+		{"{ x }", "*ast.BlockStatement"},
+		{"{}", "*ast.ExpressionStatement"},
+		{`{"one": 1}`, "*ast.ExpressionStatement"},
+		{`{-1: "a"}[-1]`, "*ast.ExpressionStatement"},
+		{`{"a" + "b": 1}`, "*ast.ExpressionStatement"},
+		{`{f(): 1}`, "*ast.ExpressionStatement"},
+		{`{[1, 2][0]: 1, x: 2}`, "*ast.ExpressionStatement"},
+		{"{ x + 1 }", "*ast.BlockStatement"},
+		{"{ f(); g() }", "*ast.BlockStatement"},
+		{"{ let x = 1; x }", "*ast.BlockStatement"},
+	}
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		checkParserErrors(t, p)
+		if len(program.Statements) != 1 {
+			t.Fatalf("program.Statements does not contain 1 statement. got=%d",
+				len(program.Statements))
+		}
+		if got := fmt.Sprintf("%T", program.Statements[0]); got != tt.expectedType {
+			t.Errorf("statement type wrong for %q. want=%s, got=%s",
+				tt.input, tt.expectedType, got)
+		}
+	}
+}
+
+func TestIfExpressionWithoutBraces(t *testing.T) {
+	input := `if (x < y) x; else y;`
+	testIfExpression(t, input)
+}
+
+func TestNodePositions(t *testing.T) {
+	input := `let add = fn(a, b) {
+  a + b
+};
+add(1, [2][0]);`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	tests := []struct {
+		node          ast.Node
+		expectedStart string
+		expectedEnd   string
+	}{
+		{program, "1:1", "4:15"},
+		{program.Statements[0], "1:1", "3:2"},
+		{program.Statements[0].(*ast.LetStatement).Value, "1:11", "3:2"},
+		{program.Statements[1], "4:1", "4:15"},
+		{program.Statements[1].(*ast.ExpressionStatement).Expression.(*ast.CallExpression).Arguments[1], "4:8", "4:14"},
+	}
+	for i, tt := range tests {
+		if got := tt.node.Pos().String(); got != tt.expectedStart {
+			t.Errorf("tests[%d] - Pos() wrong. expected=%s, got=%s", i, tt.expectedStart, got)
+		}
+		if got := tt.node.End().String(); got != tt.expectedEnd {
+			t.Errorf("tests[%d] - End() wrong. expected=%s, got=%s", i, tt.expectedEnd, got)
+		}
+	}
+}
+
+func TestErrorRecovery(t *testing.T) {
+	tests := []struct {
+		input              string
+		expectedErrors     int
+		expectedStatements []string
+	}{
+		{
+			"let = 5; let x 5; let y = 10;",
+			2,
+			[]string{"<bad statement>", "<bad statement>", "let y = 10;"},
+		},
+		{
+			"let x = ; let y = 2;",
+			1,
+			[]string{"let x = <bad expression>;", "let y = 2;"},
+		},
+		{
+			"let f = fn(a b) { a; b }; let y = 1;",
+			1,
+			[]string{"let f = <bad expression>;", "let y = 1;"},
+		},
+		{
+			"let f = fn(a) { let = 1; return a }; f(1);",
+			1,
+			[]string{"let f = fn(a) <bad statement>return a;;", "f(1)"},
+		},
+		{
+			"1 + (2 * 3; let y = 1; }",
+			2,
+			[]string{"(1 + <bad expression>)", "let y = 1;", "<bad expression>"},
+		},
+		{
+			"if (x { y } return 1;",
+			1,
+			[]string{"<bad expression>", "return 1;"},
+		},
+	}
+
+	for _, tt := range tests {
+		l := lexer.New(tt.input)
+		p := New(l)
+		program := p.ParseProgram()
+		if len(p.Errors()) != tt.expectedErrors {
+			t.Errorf("%q: wrong number of errors. want=%d, got=%d (%v)",
+				tt.input, tt.expectedErrors, len(p.Errors()), p.Errors())
+		}
+		if len(program.Statements) != len(tt.expectedStatements) {
+			t.Errorf("%q: wrong number of statements. want=%d, got=%d",
+				tt.input, len(tt.expectedStatements), len(program.Statements))
+			continue
+		}
+		for i, stmt := range program.Statements {
+			if stmt.String() != tt.expectedStatements[i] {
+				t.Errorf("%q: statement %d wrong. want=%q, got=%q",
+					tt.input, i, tt.expectedStatements[i], stmt.String())
+			}
+		}
+	}
+}
+
+func TestBadStatementPositions(t *testing.T) {
+	input := "let = 5; let y = 1;"
+	p := New(lexer.New(input))
+	program := p.ParseProgram()
+
+	bad, ok := program.Statements[0].(*ast.BadStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.BadStatement. got=%T", program.Statements[0])
+	}
+	if bad.Pos().String() != "1:1" || bad.End().String() != "1:9" {
+		t.Errorf("bad statement span wrong. got=%s-%s", bad.Pos(), bad.End())
+	}
+}
+
+func TestFunctionLiteralWithName(t *testing.T) {
+	input := `let myFunction = fn() { };`
+
+	l := lexer.New(input)
+	p := New(l)
+	program := p.ParseProgram()
+	checkParserErrors(t, p)
+
+	stmt, ok := program.Statements[0].(*ast.LetStatement)
+	if !ok {
+		t.Fatalf("program.Statements[0] is not ast.LetStatement. got=%T",
+			program.Statements[0])
+	}
+	function, ok := stmt.Value.(*ast.FunctionLiteral)
+	if !ok {
+		t.Fatalf("stmt.Value is not ast.FunctionLiteral. got=%T", stmt.Value)
+	}
+	if function.Name != "myFunction" {
+		t.Fatalf("function literal name wrong. want 'myFunction', got=%q",
+			function.Name)
+	}
+}
@@ -8,31 +8,181 @@ import (
 	"monkey/lexer"
 	"monkey/object"
 	"monkey/parser"
+	"monkey/token"
+	"os"
+	"sort"
+	"strings"
 )
 
-const PROMPT = ">> "
+const (
+	PROMPT              = ">> "
+	CONTINUATION_PROMPT = ".. "
+)
+
+const help = `Enter a program to evaluate it. Input continues on the next line while
+brackets are left open; an empty line ends it early.
+
+Commands:
+  :env           list the bindings of the session
+  :reset         forget all bindings
+  :load file     run a script file in the session
+  :ast expr      print the parsed form of expr
+  :tokens expr   print the tokens of expr
+  :help          show this help
+`
+
+// session is the state of a REPL between inputs.
+type session struct {
+	out      io.Writer
+	env      *object.Environment
+	stdio    *object.IO // shared by the REPL and the programs it runs
+	overflow object.Overflow
+}
 
-func Start(in io.Reader, out io.Writer) {
-	scanner := bufio.NewScanner(in)
-	env := object.NewEnvironment()
+// Start runs a REPL session reading from in and writing to out, with
+// integer overflow handled as overflow says.
+func Start(in io.Reader, out io.Writer, overflow object.Overflow) {
+	s := &session{out: out, stdio: object.NewIO(in, out), overflow: overflow}
+	s.reset()
 	for {
-		fmt.Printf(PROMPT)
-		scanned := scanner.Scan()
-		if !scanned {
+		input, ok := readInput(s.stdio.In, out)
+		if !ok {
 			return
 		}
-		line := scanner.Text()
-		l := lexer.New(line)
-		p := parser.New(l)
-		program := p.ParseProgram()
-		if len(p.Errors()) != 0 {
-			//printParserErrors(out, p.Errors())
+		if strings.HasPrefix(strings.TrimSpace(input), ":") {
+			s.command(strings.TrimSpace(input))
 			continue
 		}
-		evaluated := evaluator.Eval(program, env)
-		if evaluated != nil {
-			io.WriteString(out, evaluated.Inspect())
-			io.WriteString(out, "\n")
+		s.eval("", input, true)
+	}
+}
+
+// reset starts the session over with no bindings.
+func (s *session) reset() {
+	s.env = object.NewEnvironment()
+	s.env.SetIO(s.stdio)
+	s.env.SetOverflow(s.overflow)
+}
+
+// readInput reads lines until they form an input with balanced brackets,
+// the user enters an empty line or the input ends. It reports false if
+// there was nothing left to read.
+func readInput(r *bufio.Reader, out io.Writer) (string, bool) {
+	io.WriteString(out, PROMPT)
+	input, ok := readLine(r)
+	if !ok {
+		return "", false
+	}
+	if strings.HasPrefix(strings.TrimSpace(input), ":") {
+		return input, true
+	}
+	for openBrackets(input) > 0 {
+		io.WriteString(out, CONTINUATION_PROMPT)
+		line, ok := readLine(r)
+		if !ok || strings.TrimSpace(line) == "" {
+			break
+		}
+		input += "\n" + line
+	}
+	return input, true
+}
+
+// readLine reads a line without its line ending. It reports false at the
+// end of the input.
+func readLine(r *bufio.Reader) (string, bool) {
+	line, err := r.ReadString('\n')
+	if err != nil && line == "" {
+		return "", false
+	}
+	line = strings.TrimSuffix(line, "\n")
+	return strings.TrimSuffix(line, "\r"), true
+}
+
+// openBrackets returns the number of brackets, braces and parentheses that
+// are opened in input but not closed. A block comment that is not closed
+// counts as one more.
+func openBrackets(input string) int {
+	l := lexer.New(input)
+	depth := 0
+	for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+		switch tok.Type {
+		case token.LPAREN, token.LBRACE, token.LBRACKET:
+			depth++
+		case token.RPAREN, token.RBRACE, token.RBRACKET:
+			depth--
+		case token.ILLEGAL:
+			if tok.Literal == "/*" {
+				depth++
+			}
+		}
+	}
+	return depth
+}
+
+// eval evaluates source in the session, printing its diagnostics or
+// runtime error, and its result if printResult is set.
+func (s *session) eval(filename, source string, printResult bool) {
+	p := parser.New(lexer.NewFile(filename, source))
+	program := p.ParseProgram()
+	if len(p.Diagnostics()) != 0 {
+		parser.RenderDiagnostics(s.out, source, p.Diagnostics())
+		return
+	}
+	evaluated := evaluator.Eval(program, s.env)
+	if evaluated == nil {
+		return
+	}
+	if _, isError := evaluated.(*object.Error); isError || printResult {
+		io.WriteString(s.out, evaluated.Inspect())
+		io.WriteString(s.out, "\n")
+	}
+}
+
+func (s *session) command(input string) {
+	name, arg := input, ""
+	if i := strings.IndexAny(input, " \t"); i >= 0 {
+		name, arg = input[:i], strings.TrimSpace(input[i:])
+	}
+
+	switch name {
+	case ":env":
+		names := s.env.Names()
+		sort.Strings(names)
+		for _, n := range names {
+			value, _ := s.env.Get(n)
+			fmt.Fprintf(s.out, "%s = %s\n", n, value.Inspect())
+		}
+	case ":reset":
+		s.reset()
+	case ":load":
+		if arg == "" {
+			fmt.Fprintln(s.out, "usage: :load file")
+			return
+		}
+		source, err := os.ReadFile(arg)
+		if err != nil {
+			fmt.Fprintf(s.out, "%s\n", err)
+			return
+		}
+		s.eval(arg, string(source), false)
+	case ":ast":
+		p := parser.New(lexer.New(arg))
+		program := p.ParseProgram()
+		if len(p.Diagnostics()) != 0 {
+			parser.RenderDiagnostics(s.out, arg, p.Diagnostics())
+			return
+		}
+		for _, stmt := range program.Statements {
+			fmt.Fprintf(s.out, "%T %s\n", stmt, stmt.String())
+		}
+	case ":tokens":
+		l := lexer.New(arg)
+		for tok := l.NextToken(); tok.Type != token.EOF; tok = l.NextToken() {
+			fmt.Fprintf(s.out, "%s\t%s\t%q\n", tok.Pos, tok.Type, tok.Literal)
 		}
+	case ":help":
+		io.WriteString(s.out, help)
+	default:
+		fmt.Fprintf(s.out, "unknown command %s, enter :help for a list\n", name)
 	}
 }
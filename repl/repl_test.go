@@ -0,0 +1,75 @@
+package repl
+
+import (
+	"bytes"
+	"monkey/object"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestStart(t *testing.T) {
+	dir := t.TempDir()
+	script := filepath.Join(dir, "lib.monkey")
+	if err := os.WriteFile(script, []byte("let double = fn(x) { x * 2 };\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		input    string
+		expected []string // in order of appearance
+	}{
+		{"1 + 2\n", []string{">> 3\n>> "}},
+		{"let f = fn(x) {\n  x + 1\n};\nf(1)\n", []string{">> .. .. >> 2\n"}},
+		{"1 + /* a\n  comment */ 2 // done\n", []string{">> .. 3\n"}},
+		{"let name = input();\nmonkey\nputs(\"hi \" + name)\n", []string{">> >> hi monkey\nnull\n"}},
+		{"[1,\n\n2]\n", []string{">> .. ", "error[P0002]"}},
+		{"let = 1;\n", []string{"1:5: error[P0001]", "1 | let = 1;", "  |     ^"}},
+		{"1 + true\n", []string{"ERROR: 1:3: type mismatch: INTEGER + BOOLEAN\n"}},
+		{"let a = 1;\nlet b = \"x\";\n:env\n", []string{"a = 1\nb = x\n"}},
+		{"let a = 1;\n:reset\na\n", []string{"identifier not found: a"}},
+		{"const x = 1;\nconst x = 2;\nx\n", []string{"cannot redeclare constant x", ">> 1\n"}},
+		{":load " + script + "\ndouble(21)\n", []string{">> >> 42\n"}},
+		{":load " + filepath.Join(dir, "missing") + "\n", []string{"no such file"}},
+		{":ast -a * b\n", []string{"*ast.ExpressionStatement ((-a) * b)\n"}},
+		{":tokens let x\n", []string{"1:1\tLET\t\"let\"\n1:5\tIDENT\t\"x\"\n"}},
+		{":nope\n", []string{"unknown command :nope"}},
+	}
+
+	for _, tt := range tests {
+		var out bytes.Buffer
+		Start(strings.NewReader(tt.input), &out, object.OverflowDefault)
+		rest := out.String()
+		for _, want := range tt.expected {
+			i := strings.Index(rest, want)
+			if i < 0 {
+				t.Errorf("%q: output does not contain %q. got=%q", tt.input, want, out.String())
+				break
+			}
+			rest = rest[i+len(want):]
+		}
+	}
+}
+
+func TestStartOverflow(t *testing.T) {
+	tests := []struct {
+		overflow object.Overflow
+		expected string
+	}{
+		{object.OverflowDefault, ">> 9223372036854775808\n"},
+		{object.OverflowWrap, ">> -9223372036854775808\n"},
+		{object.OverflowChecked, "integer overflow"},
+	}
+
+	for _, tt := range tests {
+		// :reset must keep the mode.
+		input := "9223372036854775807 + 1\n:reset\n9223372036854775807 + 1\n"
+		var out bytes.Buffer
+		Start(strings.NewReader(input), &out, tt.overflow)
+		if got := strings.Count(out.String(), tt.expected); got != 2 {
+			t.Errorf("mode %d: output does not contain %q twice. got=%q",
+				tt.overflow, tt.expected, out.String())
+		}
+	}
+}
@@ -0,0 +1,63 @@
+package vm
+
+import "monkey/object"
+
+// cell holds a variable captured by a closure. Capturing a local variable
+// moves its value into a cell that the closure and the function defining it
+// share, so that an assignment in either is seen by both, as with the
+// environments of Eval.
+type cell struct {
+	value object.Object
+}
+
+func (c *cell) Type() object.ObjectType { return "CELL" }
+func (c *cell) Inspect() string         { return "cell" }
+
+// constant holds the value of a variable declared by const, in its slot or
+// in its cell.
+type constant struct {
+	value object.Object
+}
+
+func (c *constant) Type() object.ObjectType { return "CONSTANT" }
+func (c *constant) Inspect() string         { return "constant" }
+
+// capture returns the cell of the variable in slot, moving its value into a
+// new cell if it has not been captured before.
+func capture(slot *object.Object) *cell {
+	if c, ok := (*slot).(*cell); ok {
+		return c
+	}
+	c := &cell{value: *slot}
+	*slot = c
+	return c
+}
+
+// load returns the value of the variable in slot v.
+func load(v object.Object) object.Object {
+	if c, ok := v.(*cell); ok {
+		v = c.value
+	}
+	if c, ok := v.(*constant); ok {
+		v = c.value
+	}
+	return v
+}
+
+// isConstant reports whether the variable in slot v is a constant.
+func isConstant(v object.Object) bool {
+	if c, ok := v.(*cell); ok {
+		v = c.value
+	}
+	_, ok := v.(*constant)
+	return ok
+}
+
+// store sets the value of the variable in slot.
+func store(slot *object.Object, val object.Object) {
+	if c, ok := (*slot).(*cell); ok {
+		c.value = val
+		return
+	}
+	*slot = val
+}
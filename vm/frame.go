@@ -0,0 +1,29 @@
+package vm
+
+import (
+	"monkey/code"
+	"monkey/object"
+	"monkey/token"
+)
+
+// Frame is the activation record of a function call.
+type Frame struct {
+	cl          *object.Closure
+	ip          int
+	basePointer int
+}
+
+func NewFrame(cl *object.Closure, basePointer int) *Frame {
+	return &Frame{cl: cl, ip: -1, basePointer: basePointer}
+}
+
+func (f *Frame) Instructions() code.Instructions {
+	return f.cl.Fn.Instructions
+}
+
+// callPosition returns the source position of the call the frame is
+// executing. The instruction pointer rests on the last byte of the operand
+// of its OpCall.
+func (f *Frame) callPosition() token.Position {
+	return f.cl.Fn.Positions[f.ip-2]
+}
@@ -0,0 +1,12 @@
+package vm
+
+import "monkey/object"
+
+// iterator is the state of a for loop, stored in a hidden variable.
+type iterator struct {
+	elements []object.Object
+	index    int
+}
+
+func (it *iterator) Type() object.ObjectType { return "ITERATOR" }
+func (it *iterator) Inspect() string         { return "iterator" }
@@ -0,0 +1,675 @@
+// Package vm executes the bytecode produced by the compiler package. It
+// shares the runtime semantics of the evaluator package, so a program has
+// the same result and errors on both backends.
+package vm
+
+import (
+	"monkey/code"
+	"monkey/compiler"
+	"monkey/evaluator"
+	"monkey/object"
+)
+
+const (
+	StackSize   = 2048
+	GlobalsSize = 65536
+	MaxFrames   = 1024
+)
+
+var (
+	True  = evaluator.True
+	False = evaluator.False
+	Null  = evaluator.Null
+)
+
+var binaryOperators = map[code.Opcode]string{
+	code.OpAdd:         "+",
+	code.OpSub:         "-",
+	code.OpMul:         "*",
+	code.OpDiv:         "/",
+	code.OpMod:         "%",
+	code.OpEqual:       "==",
+	code.OpNotEqual:    "!=",
+	code.OpGreaterThan: ">",
+	code.OpLessThan:    "<",
+}
+
+type VM struct {
+	// Overflow selects what integer arithmetic does with results that do
+	// not fit in 64 bits. The zero value promotes them to big integers.
+	Overflow object.Overflow
+	// IO is where builtins such as puts and input print to and read from.
+	// If it is nil, they use evaluator.Stdio.
+	IO *object.IO
+
+	constants []object.Object
+	builtins  []*object.Builtin
+
+	stack []object.Object
+	sp    int // Always points to the next free slot. Top of stack is stack[sp-1]
+
+	globals []object.Object
+
+	frames      []*Frame
+	framesIndex int
+
+	// handlers are the try handlers installed, innermost last.
+	handlers []handler
+
+	// lastPopped is the value of the last expression statement executed at
+	// the top level, which is the result of the program.
+	lastPopped object.Object
+}
+
+// handler is installed by OpTry and OpTryFinally to catch the errors raised
+// until the matching OpEndTry.
+type handler struct {
+	target      int  // the offset execution continues at
+	sp          int  // the stack pointer to restore
+	framesIndex int  // the frame the handler belongs to
+	finally     bool // whether the handler runs a finally block
+}
+
+func New(bytecode *compiler.Bytecode) *VM {
+	mainFn := &object.CompiledFunction{
+		Instructions: bytecode.Instructions,
+		Positions:    bytecode.Positions,
+		Identifiers:  bytecode.Identifiers,
+		NumLocals:    bytecode.NumLocals,
+	}
+	mainClosure := &object.Closure{Fn: mainFn}
+	mainFrame := NewFrame(mainClosure, 0)
+
+	frames := make([]*Frame, MaxFrames)
+	frames[0] = mainFrame
+
+	names := evaluator.BuiltinNames()
+	builtins := make([]*object.Builtin, len(names))
+	for i, name := range names {
+		builtins[i], _ = evaluator.LookupBuiltin(name)
+	}
+
+	return &VM{
+		constants:   bytecode.Constants,
+		builtins:    builtins,
+		stack:       make([]object.Object, StackSize),
+		sp:          bytecode.NumLocals,
+		globals:     make([]object.Object, GlobalsSize),
+		frames:      frames,
+		framesIndex: 1,
+	}
+}
+
+// NewWithGlobalsStore creates a VM that shares its globals with an earlier
+// run, as the REPL needs to.
+func NewWithGlobalsStore(bytecode *compiler.Bytecode, s []object.Object) *VM {
+	vm := New(bytecode)
+	vm.globals = s
+	return vm
+}
+
+// LastPoppedStackElem returns the result of the program: the value of the
+// last top-level expression statement or return, or nil if the program
+// ended with a let statement.
+func (vm *VM) LastPoppedStackElem() object.Object {
+	return vm.lastPopped
+}
+
+// Run executes the program. A runtime error stops execution and is returned
+// as an *object.Error carrying the position it occurred at.
+func (vm *VM) Run() error {
+	return vm.run(0)
+}
+
+// Call calls fn, a closure or builtin, with args while the VM is running,
+// as a builtin taking a callback needs to. It returns the result of the
+// call, or the *object.Error the call failed with.
+func (vm *VM) Call(fn object.Object, args []object.Object) object.Object {
+	sp := vm.sp
+	defer func() { vm.sp = sp }()
+
+	if err := vm.push(fn); err != nil {
+		return err.(*object.Error)
+	}
+	for _, arg := range args {
+		if err := vm.push(arg); err != nil {
+			return err.(*object.Error)
+		}
+	}
+	base := vm.framesIndex
+	err := vm.executeCall(len(args))
+	if err == nil && vm.framesIndex > base {
+		err = vm.run(base)
+	}
+	if err != nil {
+		if rtErr, ok := err.(*object.Error); ok {
+			return rtErr
+		}
+		return evaluator.NewError("%s", err)
+	}
+	return vm.pop()
+}
+
+// run executes instructions until the frames above base have returned, or
+// until the main function ends if base is 0.
+func (vm *VM) run(base int) error {
+	var ip int
+	var ins code.Instructions
+	var op code.Opcode
+
+	for vm.framesIndex > base && vm.currentFrame().ip < len(vm.currentFrame().Instructions())-1 {
+		vm.currentFrame().ip++
+
+		ip = vm.currentFrame().ip
+		ins = vm.currentFrame().Instructions()
+		op = code.Opcode(ins[ip])
+
+		var err error
+		switch op {
+		case code.OpConstant:
+			constIndex := code.ReadUint32(ins[ip+1:])
+			vm.currentFrame().ip += 4
+			err = vm.push(vm.constants[constIndex])
+
+		case code.OpPop:
+			vm.lastPopped = vm.pop()
+
+		case code.OpAdd, code.OpSub, code.OpMul, code.OpDiv, code.OpMod,
+			code.OpEqual, code.OpNotEqual, code.OpGreaterThan, code.OpLessThan:
+			right := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.InfixOperation(binaryOperators[op], left, right, vm.Overflow))
+
+		case code.OpBang:
+			err = vm.pushResult(evaluator.PrefixOperation("!", vm.pop(), vm.Overflow))
+
+		case code.OpMinus:
+			err = vm.pushResult(evaluator.PrefixOperation("-", vm.pop(), vm.Overflow))
+
+		case code.OpTrue:
+			err = vm.push(True)
+
+		case code.OpFalse:
+			err = vm.push(False)
+
+		case code.OpNull:
+			err = vm.push(Null)
+
+		case code.OpJump:
+			pos := int(code.ReadUint32(ins[ip+1:]))
+			vm.currentFrame().ip = pos - 1
+
+		case code.OpJumpNotTruthy:
+			pos := int(code.ReadUint32(ins[ip+1:]))
+			vm.currentFrame().ip += 4
+			condition := vm.pop()
+			if !evaluator.IsTruthy(condition) {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpIter:
+			elements, iterErr := evaluator.Iterate(vm.pop())
+			if iterErr != nil {
+				err = iterErr
+				break
+			}
+			err = vm.push(&iterator{elements: elements})
+
+		case code.OpIterNext:
+			pos := int(code.ReadUint32(ins[ip+1:]))
+			vm.currentFrame().ip += 4
+			it := vm.pop().(*iterator)
+			if it.index < len(it.elements) {
+				err = vm.push(it.elements[it.index])
+				it.index++
+			} else {
+				vm.currentFrame().ip = pos - 1
+			}
+
+		case code.OpSetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.declare(&vm.globals[globalIndex], false, ip)
+			// A let statement has no value, so a program ending with one
+			// has no result, as in Eval.
+			vm.lastPopped = nil
+
+		case code.OpSetGlobalConst:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.declare(&vm.globals[globalIndex], true, ip)
+			vm.lastPopped = nil
+
+		case code.OpGetGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.pushVariable(load(vm.globals[globalIndex]), ip)
+
+		case code.OpSetLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			frame := vm.currentFrame()
+			err = vm.declare(&vm.stack[frame.basePointer+int(localIndex)], false, ip)
+
+		case code.OpSetLocalConst:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			frame := vm.currentFrame()
+			err = vm.declare(&vm.stack[frame.basePointer+int(localIndex)], true, ip)
+
+		case code.OpGetLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			frame := vm.currentFrame()
+			err = vm.pushVariable(load(vm.stack[frame.basePointer+int(localIndex)]), ip)
+
+		case code.OpAssignGlobal:
+			globalIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.assign(&vm.globals[globalIndex], ip)
+
+		case code.OpAssignLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			frame := vm.currentFrame()
+			err = vm.assign(&vm.stack[frame.basePointer+int(localIndex)], ip)
+
+		case code.OpAssignFree:
+			freeIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.assign(&vm.currentFrame().cl.Free[freeIndex], ip)
+
+		case code.OpCaptureLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			frame := vm.currentFrame()
+			err = vm.push(capture(&vm.stack[frame.basePointer+int(localIndex)]))
+
+		case code.OpBindLocal:
+			localIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			frame := vm.currentFrame()
+			vm.stack[frame.basePointer+int(localIndex)] = vm.pop()
+
+		case code.OpCaptureFree:
+			freeIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.push(capture(&vm.currentFrame().cl.Free[freeIndex]))
+
+		case code.OpGetBuiltin:
+			builtinIndex := code.ReadUint8(ins[ip+1:])
+			vm.currentFrame().ip += 1
+			err = vm.push(vm.builtins[builtinIndex])
+
+		case code.OpGetFree:
+			freeIndex := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			currentClosure := vm.currentFrame().cl
+			err = vm.pushVariable(load(currentClosure.Free[freeIndex]), ip)
+
+		case code.OpCurrentClosure:
+			err = vm.push(vm.currentFrame().cl)
+
+		case code.OpArray:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			array := vm.buildArray(vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+			err = vm.push(array)
+
+		case code.OpHash:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			hash := object.NewHash(numElements / 2)
+			if insertErr := vm.insertPairs(hash, vm.sp-numElements, vm.sp); insertErr != nil {
+				err = insertErr
+				break
+			}
+			vm.sp = vm.sp - numElements
+			err = vm.push(hash)
+
+		case code.OpArrayAppend:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			array := vm.stack[vm.sp-numElements-1].(*object.Array)
+			array.Elements = append(array.Elements, vm.stack[vm.sp-numElements:vm.sp]...)
+			vm.sp = vm.sp - numElements
+
+		case code.OpHashInsert:
+			numElements := int(code.ReadUint16(ins[ip+1:]))
+			vm.currentFrame().ip += 2
+			hash := vm.stack[vm.sp-numElements-1].(*object.Hash)
+			err = vm.insertPairs(hash, vm.sp-numElements, vm.sp)
+			vm.sp = vm.sp - numElements
+
+		case code.OpIndex:
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.IndexOperation(left, index))
+
+		case code.OpSlice:
+			high := vm.pop()
+			low := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.SliceOperation(left, low, high))
+
+		case code.OpSetIndex:
+			val := vm.pop()
+			index := vm.pop()
+			left := vm.pop()
+			err = vm.pushResult(evaluator.SetIndexOperation(left, index, val))
+
+		case code.OpDup:
+			n := int(code.ReadUint8(ins[ip+1:]))
+			vm.currentFrame().ip += 1
+			start := vm.sp - n
+			for i := 0; i < n && err == nil; i++ {
+				err = vm.push(vm.stack[start+i])
+			}
+
+		case code.OpCall:
+			numArgs := code.ReadUint16(ins[ip+1:])
+			vm.currentFrame().ip += 2
+			err = vm.executeCall(int(numArgs))
+
+		case code.OpReturnValue:
+			returnValue := vm.pop()
+			if vm.framesIndex == 1 {
+				vm.lastPopped = returnValue
+				return nil
+			}
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			err = vm.push(returnValue)
+
+		case code.OpReturn:
+			if vm.framesIndex == 1 {
+				vm.lastPopped = Null
+				return nil
+			}
+			frame := vm.popFrame()
+			vm.sp = frame.basePointer - 1
+			err = vm.push(Null)
+
+		case code.OpClosure:
+			constIndex := code.ReadUint32(ins[ip+1:])
+			numFree := code.ReadUint16(ins[ip+5:])
+			vm.currentFrame().ip += 6
+			err = vm.pushClosure(int(constIndex), int(numFree))
+
+		case code.OpTry, code.OpTryFinally:
+			target := int(code.ReadUint32(ins[ip+1:]))
+			vm.currentFrame().ip += 4
+			vm.handlers = append(vm.handlers, handler{
+				target:      target,
+				sp:          vm.sp,
+				framesIndex: vm.framesIndex,
+				finally:     op == code.OpTryFinally,
+			})
+
+		case code.OpEndTry:
+			vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+		case code.OpThrow:
+			err = evaluator.Throw(vm.pop())
+
+		default:
+			def, lookupErr := code.Lookup(byte(op))
+			if lookupErr != nil {
+				return lookupErr
+			}
+			return evaluator.NewError("unhandled opcode %s", def.Name)
+		}
+
+		if err != nil {
+			err = vm.locate(err, ip)
+			if vm.catch(err, base) {
+				continue
+			}
+			if base == 0 {
+				return vm.unwind(err, 1)
+			}
+			return vm.unwind(err, base)
+		}
+	}
+	return nil
+}
+
+// locate records the source position of the instruction at ip on a runtime
+// error that does not know where it occurred yet.
+func (vm *VM) locate(err error, ip int) error {
+	if rtErr, ok := err.(*object.Error); ok && !rtErr.Pos.IsValid() {
+		rtErr.Pos = vm.currentFrame().cl.Fn.Positions[ip]
+	}
+	return err
+}
+
+// catch hands err to the innermost handler, if there is one that may catch
+// it, and reports whether it did. Only handlers of the frames above base
+// may catch it.
+func (vm *VM) catch(err error, base int) bool {
+	rtErr, ok := err.(*object.Error)
+	if !ok || rtErr.Kind == object.LimitExceeded || len(vm.handlers) == 0 {
+		return false
+	}
+	h := vm.handlers[len(vm.handlers)-1]
+	if h.framesIndex <= base {
+		return false
+	}
+	vm.handlers = vm.handlers[:len(vm.handlers)-1]
+
+	vm.unwind(rtErr, h.framesIndex)
+	vm.sp = h.sp
+	if h.finally {
+		vm.push(rtErr)
+	} else {
+		vm.push(evaluator.CaughtError(rtErr))
+	}
+	vm.currentFrame().ip = h.target - 1
+	return true
+}
+
+// unwind returns from the frames above framesIndex, recording the calls
+// err propagates out of in its trace.
+func (vm *VM) unwind(err error, framesIndex int) error {
+	rtErr, ok := err.(*object.Error)
+	for vm.framesIndex > framesIndex {
+		frame := vm.popFrame()
+		if ok {
+			rtErr.AddFrame(functionName(frame.cl.Fn), vm.currentFrame().callPosition())
+		}
+	}
+	return err
+}
+
+func functionName(fn *object.CompiledFunction) string {
+	if fn.Literal == nil {
+		return ""
+	}
+	return fn.Literal.Name
+}
+
+func (vm *VM) push(o object.Object) error {
+	if vm.sp >= StackSize {
+		return evaluator.NewError("stack overflow")
+	}
+	vm.stack[vm.sp] = o
+	vm.sp++
+	return nil
+}
+
+// pushResult pushes the result of an operation, or returns it if it is an
+// error.
+func (vm *VM) pushResult(o object.Object) error {
+	if err, ok := o.(*object.Error); ok {
+		return err
+	}
+	return vm.push(o)
+}
+
+// pushVariable pushes the value of the variable read by the instruction at
+// ip. A variable without a value is an error, unless the instruction only
+// captures it for a closure.
+func (vm *VM) pushVariable(o object.Object, ip int) error {
+	if o == nil {
+		if name, ok := vm.currentFrame().cl.Fn.Identifiers[ip]; ok {
+			return evaluator.NewError("identifier not found: " + name)
+		}
+	}
+	return vm.push(o)
+}
+
+// declare pops a value and binds the variable in slot to it, as let or as
+// const if isConst is set. A constant cannot be declared again.
+func (vm *VM) declare(slot *object.Object, isConst bool, ip int) error {
+	val := vm.pop()
+	if isConstant(*slot) {
+		name := vm.currentFrame().cl.Fn.Identifiers[ip]
+		return evaluator.NewError("cannot redeclare constant %s", name)
+	}
+	if isConst {
+		val = &constant{value: val}
+	}
+	store(slot, val)
+	return nil
+}
+
+// assign stores the value on top of the stack into the variable in slot,
+// which must have been given a value by let or const before and must not be
+// a constant.
+func (vm *VM) assign(slot *object.Object, ip int) error {
+	name := vm.currentFrame().cl.Fn.Identifiers[ip]
+	if load(*slot) == nil {
+		return evaluator.NewError("identifier not found: " + name)
+	}
+	if isConstant(*slot) {
+		return evaluator.NewError("cannot assign to constant %s", name)
+	}
+	store(slot, vm.stack[vm.sp-1])
+	return nil
+}
+
+func (vm *VM) pop() object.Object {
+	o := vm.stack[vm.sp-1]
+	vm.sp--
+	return o
+}
+
+func (vm *VM) currentFrame() *Frame {
+	return vm.frames[vm.framesIndex-1]
+}
+
+func (vm *VM) pushFrame(f *Frame) error {
+	if vm.framesIndex >= MaxFrames {
+		return evaluator.NewError("stack overflow")
+	}
+	vm.frames[vm.framesIndex] = f
+	vm.framesIndex++
+	return nil
+}
+
+func (vm *VM) popFrame() *Frame {
+	vm.framesIndex--
+	return vm.frames[vm.framesIndex]
+}
+
+func (vm *VM) buildArray(startIndex, endIndex int) object.Object {
+	elements := make([]object.Object, endIndex-startIndex)
+	copy(elements, vm.stack[startIndex:endIndex])
+	return &object.Array{Elements: elements}
+}
+
+// insertPairs sets the keys and values between startIndex and endIndex on
+// the stack in hash.
+func (vm *VM) insertPairs(hash *object.Hash, startIndex, endIndex int) error {
+	for i := startIndex; i < endIndex; i += 2 {
+		key := vm.stack[i]
+		value := vm.stack[i+1]
+		hashKey, ok := key.(object.Hashable)
+		if !ok {
+			return evaluator.NewError("unusable as hash key: %s", key.Type())
+		}
+		hash.Set(hashKey, value)
+	}
+	return nil
+}
+
+func (vm *VM) executeCall(numArgs int) error {
+	callee := vm.stack[vm.sp-1-numArgs]
+	switch callee := callee.(type) {
+	case *object.Closure:
+		return vm.callClosure(callee, numArgs)
+	case *object.Builtin:
+		return vm.callBuiltin(callee, numArgs)
+	default:
+		return evaluator.NewError("not a function: %s", callee.Type())
+	}
+}
+
+func (vm *VM) callClosure(cl *object.Closure, numArgs int) error {
+	if numArgs != cl.Fn.NumParameters {
+		return evaluator.NewError("wrong number of arguments: want=%d, got=%d",
+			cl.Fn.NumParameters, numArgs)
+	}
+	basePointer := vm.sp - numArgs
+	if basePointer+cl.Fn.NumLocals >= StackSize {
+		return evaluator.NewError("stack overflow")
+	}
+	if err := vm.pushFrame(NewFrame(cl, basePointer)); err != nil {
+		return err
+	}
+	// Clear the locals so that reading one before its let is executed is
+	// reported instead of seeing a value left over from an earlier call.
+	for i := basePointer + numArgs; i < basePointer+cl.Fn.NumLocals; i++ {
+		vm.stack[i] = nil
+	}
+	vm.sp = basePointer + cl.Fn.NumLocals
+	return nil
+}
+
+func (vm *VM) callBuiltin(builtin *object.Builtin, numArgs int) error {
+	args := make([]object.Object, numArgs)
+	copy(args, vm.stack[vm.sp-numArgs:vm.sp])
+
+	result := builtin.Fn(runtime{vm}, args...)
+	vm.sp = vm.sp - numArgs - 1
+	if result == nil {
+		return vm.push(Null)
+	}
+	return vm.pushResult(result)
+}
+
+// runtime is the VM as the builtins it calls see it.
+type runtime struct {
+	vm *VM
+}
+
+func (rt runtime) Call(fn object.Object, args []object.Object) object.Object {
+	return rt.vm.Call(fn, args)
+}
+
+func (rt runtime) IO() *object.IO {
+	if rt.vm.IO == nil {
+		return evaluator.Stdio
+	}
+	return rt.vm.IO
+}
+
+// Allocate accepts any size, since the VM does not limit allocations.
+func (rt runtime) Allocate(size int64) *object.Error {
+	return nil
+}
+
+func (vm *VM) pushClosure(constIndex int, numFree int) error {
+	constant := vm.constants[constIndex]
+	function, ok := constant.(*object.CompiledFunction)
+	if !ok {
+		return evaluator.NewError("not a function: %+v", constant)
+	}
+	free := make([]object.Object, numFree)
+	copy(free, vm.stack[vm.sp-numFree:vm.sp])
+	vm.sp = vm.sp - numFree
+
+	closure := &object.Closure{Fn: function, Free: free}
+	return vm.push(closure)
+}
@@ -0,0 +1,128 @@
+package vm
+
+import (
+	"monkey/ast"
+	"monkey/compiler"
+	"monkey/lexer"
+	"monkey/object"
+	"monkey/parser"
+	"testing"
+)
+
+type vmTestCase struct {
+	input    string
+	expected string // Inspect of the result, or "" for none
+}
+
+func TestRun(t *testing.T) {
+	tests := []vmTestCase{
+		{"1 + 2 * 3", "7"},
+		{"-5 + 10 / 2", "0"},
+		{"!(1 < 2) == false", "true"},
+		{`"mon" + "key"`, "monkey"},
+		{"if (1 > 2) { 10 }", "null"},
+		{"if (false) { 10 } else { 20 }", "20"},
+		{"let a = 1; let b = a + 1; b", "2"},
+		{"let a = 1;", ""},
+		{"[1, 2, 3][1]", "2"},
+		{"[1, 2, 3][-1]", "3"},
+		{`{"a": 1}["a"]`, "1"},
+		{"len(\"abc\") + len(\"\")", "3"},
+		{"fn(a, b) { a + b }(1, 2)", "3"},
+		{"fn() { }()", "null"},
+		{"fn() { return 1; 2 }()", "1"},
+		{"return 5; 10", "5"},
+		{"let adder = fn(a) { fn(b) { a + b } }; adder(2)(3)", "5"},
+		{"let f = fn() { let g = fn() { h() }; let h = fn() { 1 }; g() }; f()", "1"},
+		{"let fib = fn(n) { if (n < 2) { n } else { fib(n - 1) + fib(n - 2) } }; fib(15)", "610"},
+		{`let f = fn() { f() }; try { f() } catch (e) { e["message"] }`, "stack overflow"},
+		{`let f = fn(n) { try { g(n) } catch { n } }; let g = fn(n) { throw n }; [f(1), f(2)]`, "[1, 2]"},
+		{`let f = fn() { try { map([1], fn(x) { x + true }) } catch { "caught" } }; [f(), f()]`, "[caught, caught]"},
+		{`let r = try { map([1], fn(x) { throw x }) } catch (e) { 5 }; [r + 1, map([1], fn(x) { x })]`, "[6, [1]]"},
+		{`let f = fn(n) { if (n == 0) { 0 } else { 1 + map([n - 1], f)[0] } }; f(100)`, "100"},
+		{`let f = fn(n) { map([n], f) }; try { f(1) } catch (e) { e["message"] }`, "stack overflow"},
+	}
+	for _, tt := range tests {
+		result, err := run(t, tt.input)
+		if err != nil {
+			t.Errorf("%s: unexpected error: %s", tt.input, err)
+			continue
+		}
+		got := ""
+		if result != nil {
+			got = result.Inspect()
+		}
+		if got != tt.expected {
+			t.Errorf("%s: wrong result. want=%q, got=%q", tt.input, tt.expected, got)
+		}
+	}
+}
+
+func TestRuntimeErrors(t *testing.T) {
+	tests := []vmTestCase{
+		{"5 + true;", "1:3: type mismatch: INTEGER + BOOLEAN"},
+		{"-true", "1:1: unknown operator: -BOOLEAN"},
+		{"foobar", "1:1: identifier not found: foobar"},
+		{"let f = fn() { x }; f(); let x = 1;", "1:16: identifier not found: x"},
+		{"let f = fn(a) { a }; f()", "1:22: wrong number of arguments: want=1, got=0"},
+		{"1()", "1:1: not a function: INTEGER"},
+		{`let h = {fn(){}: 1}`, "1:9: unusable as hash key: FUNCTION"},
+		{`len(1)`, "1:1: argument 1 to `len` must be STRING, ARRAY or HASH, got INTEGER"},
+		{"let f = fn() { f() }; f()", "1:16: stack overflow"},
+	}
+	for _, tt := range tests {
+		_, err := run(t, tt.input)
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.input)
+			continue
+		}
+		if err.Error() != tt.expected {
+			t.Errorf("%s: wrong error. want=%q, got=%q", tt.input, tt.expected, err)
+		}
+	}
+}
+
+func TestGlobalsStore(t *testing.T) {
+	globals := make([]object.Object, GlobalsSize)
+	symbols := compiler.New().SymbolTable()
+	constants := []object.Object{}
+
+	for _, input := range []string{"let x = 40;", "let y = x + 1;", "y + 1"} {
+		comp := compiler.NewWithState(symbols, constants)
+		if err := comp.Compile(parse(t, input)); err != nil {
+			t.Fatalf("%s: compiler error: %s", input, err)
+		}
+		constants = comp.Bytecode().Constants
+
+		machine := NewWithGlobalsStore(comp.Bytecode(), globals)
+		if err := machine.Run(); err != nil {
+			t.Fatalf("%s: vm error: %s", input, err)
+		}
+		if input == "y + 1" {
+			if got := machine.LastPoppedStackElem().Inspect(); got != "42" {
+				t.Errorf("wrong result. want=42, got=%s", got)
+			}
+		}
+	}
+}
+
+func run(t *testing.T, input string) (object.Object, error) {
+	t.Helper()
+	comp := compiler.New()
+	if err := comp.Compile(parse(t, input)); err != nil {
+		t.Fatalf("%s: compiler error: %s", input, err)
+	}
+	machine := New(comp.Bytecode())
+	err := machine.Run()
+	return machine.LastPoppedStackElem(), err
+}
+
+func parse(t *testing.T, input string) *ast.Program {
+	t.Helper()
+	p := parser.New(lexer.New(input))
+	program := p.ParseProgram()
+	if len(p.Errors()) != 0 {
+		t.Fatalf("%s: parser errors: %v", input, p.Errors())
+	}
+	return program
+}